@@ -0,0 +1,46 @@
+package diodes_test
+
+import (
+	"context"
+	"diodes"
+	"testing"
+	"time"
+
+	v2 "plumbing/v2"
+)
+
+// The latency benchmarks measure the time from an envelope being set on an
+// empty diode until the blocked reader has received it.
+
+func BenchmarkManyToOneEnvelopeV2Latency(b *testing.B) {
+	d := diodes.NewManyToOneEnvelopeV2(1000, nil)
+	benchmarkLatency(b, d.Set, func() { d.Next() })
+}
+
+func BenchmarkWaitingManyToOneEnvelopeV2Latency(b *testing.B) {
+	d := diodes.NewWaitingManyToOneEnvelopeV2(1000, nil)
+	benchmarkLatency(b, d.Set, func() { d.Next(context.Background()) })
+}
+
+func benchmarkLatency(b *testing.B, set func(*v2.Envelope), next func()) {
+	e := &v2.Envelope{SourceId: "some-id"}
+	ready := make(chan struct{})
+	received := make(chan struct{})
+
+	go func() {
+		for {
+			ready <- struct{}{}
+			next()
+			received <- struct{}{}
+		}
+	}()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		<-ready
+		// Give the reader a chance to block before writing.
+		time.Sleep(100 * time.Microsecond)
+		set(e)
+		<-received
+	}
+}
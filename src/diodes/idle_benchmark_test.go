@@ -0,0 +1,68 @@
+// +build !windows
+
+package diodes_test
+
+import (
+	"context"
+	"diodes"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// The idle benchmarks measure the CPU time spent by a reader waiting on an
+// empty diode. They log the CPU time spent per millisecond of idle time,
+// which is shown when run with -v.
+
+func BenchmarkManyToOneEnvelopeV2Idle(b *testing.B) {
+	d := diodes.NewManyToOneEnvelopeV2(1000, nil)
+	benchmarkIdle(b, func(ctx context.Context) {
+		for {
+			if _, ok := d.TryNext(); ok {
+				return
+			}
+			select {
+			case <-ctx.Done():
+				return
+			default:
+				time.Sleep(10 * time.Millisecond)
+			}
+		}
+	})
+}
+
+func BenchmarkWaitingManyToOneEnvelopeV2Idle(b *testing.B) {
+	d := diodes.NewWaitingManyToOneEnvelopeV2(1000, nil)
+	benchmarkIdle(b, func(ctx context.Context) {
+		d.Next(ctx)
+	})
+}
+
+func benchmarkIdle(b *testing.B, wait func(context.Context)) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		wait(ctx)
+		close(done)
+	}()
+
+	start := cpuTime(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	b.StopTimer()
+
+	cancel()
+	<-done
+	b.Logf("%d cpu-ns/op", int64(cpuTime(b)-start)/int64(b.N))
+}
+
+func cpuTime(b *testing.B) time.Duration {
+	var usage syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &usage); err != nil {
+		b.Fatal(err)
+	}
+
+	return time.Duration(usage.Utime.Nano() + usage.Stime.Nano())
+}
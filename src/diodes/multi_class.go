@@ -0,0 +1,169 @@
+package diodes
+
+import (
+	"context"
+
+	gendiodes "github.com/cloudfoundry/diodes"
+)
+
+// Class is a kind of envelope that a multi-class diode buffers in its own
+// ring.
+type Class int
+
+const (
+	LogClass Class = iota
+	CounterClass
+	GaugeClass
+	TimerClass
+
+	numClasses
+)
+
+// String returns the name of the class as used in metric tags.
+func (c Class) String() string {
+	switch c {
+	case LogClass:
+		return "log"
+	case CounterClass:
+		return "counter"
+	case GaugeClass:
+		return "gauge"
+	case TimerClass:
+		return "timer"
+	}
+
+	return "unknown"
+}
+
+// Classes returns every Class.
+func Classes() []Class {
+	return []Class{LogClass, CounterClass, GaugeClass, TimerClass}
+}
+
+// ClassConfig is the number of envelopes a multi-class diode buffers for a
+// class and the number it reads from the class in a row when the other
+// classes also have envelopes. A Size or Weight below 1 is treated as 1.
+type ClassConfig struct {
+	Size   int
+	Weight int
+}
+
+// MultiClassConfig configures a multi-class diode.
+type MultiClassConfig struct {
+	Log     ClassConfig
+	Counter ClassConfig
+	Gauge   ClassConfig
+	Timer   ClassConfig
+}
+
+func (c MultiClassConfig) class(class Class) ClassConfig {
+	switch class {
+	case CounterClass:
+		return c.Counter
+	case GaugeClass:
+		return c.Gauge
+	case TimerClass:
+		return c.Timer
+	}
+
+	return c.Log
+}
+
+// ClassAlerter is notified of the data dropped from a class.
+type ClassAlerter interface {
+	Alert(class Class, missed int)
+}
+
+// ClassAlertFunc type is an adapter to allow the use of ordinary functions
+// as ClassAlerters.
+type ClassAlertFunc func(class Class, missed int)
+
+// Alert calls f(class, missed)
+func (f ClassAlertFunc) Alert(class Class, missed int) {
+	f(class, missed)
+}
+
+// multiClass buffers each class of data in its own many to one ring so that
+// a burst of one class only overwrites data of the same class. It is read
+// by a single reader using weighted round robin across the classes.
+type multiClass struct {
+	rings   [numClasses]*waiter
+	weights [numClasses]int
+	signal  chan struct{}
+
+	// current and credit are only accessed by the reader.
+	current Class
+	credit  int
+}
+
+func newMultiClass(c MultiClassConfig, alerter ClassAlerter) *multiClass {
+	m := &multiClass{
+		signal: make(chan struct{}, 1),
+	}
+
+	for _, class := range Classes() {
+		conf := c.class(class)
+		if conf.Size < 1 {
+			conf.Size = 1
+		}
+
+		var a gendiodes.Alerter
+		if alerter != nil {
+			class := class
+			a = gendiodes.AlertFunc(func(missed int) {
+				alerter.Alert(class, missed)
+			})
+		}
+
+		m.rings[class] = &waiter{
+			d:      gendiodes.NewManyToOne(conf.Size, a),
+			signal: m.signal,
+		}
+
+		m.weights[class] = conf.Weight
+		if m.weights[class] < 1 {
+			m.weights[class] = 1
+		}
+	}
+	m.credit = m.weights[m.current]
+
+	return m
+}
+
+func (m *multiClass) Set(class Class, data gendiodes.GenericDataType) {
+	m.rings[class].Set(data)
+}
+
+// TryNext reads from the current class until its credit is used up or it is
+// empty, then moves on to the next class.
+func (m *multiClass) TryNext() (gendiodes.GenericDataType, bool) {
+	for i := 0; i <= int(numClasses); i++ {
+		if m.credit > 0 {
+			if data, ok := m.rings[m.current].TryNext(); ok {
+				m.credit--
+				return data, true
+			}
+		}
+
+		m.current = (m.current + 1) % numClasses
+		m.credit = m.weights[m.current]
+	}
+
+	return nil, false
+}
+
+// Next blocks until data is available or the context is done.
+func (m *multiClass) Next(ctx context.Context) (gendiodes.GenericDataType, bool) {
+	for {
+		data, ok := m.TryNext()
+		if ok {
+			return data, true
+		}
+
+		select {
+		case <-m.signal:
+		case <-ctx.Done():
+			return nil, false
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package diodes_test
+
+import (
+	"context"
+	"diodes"
+	"sync"
+	"time"
+
+	v2 "plumbing/v2"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("WaitingMultiClassEnvelopeV2", func() {
+	var (
+		mu     sync.Mutex
+		missed map[diodes.Class]int
+		alert  diodes.ClassAlertFunc
+	)
+
+	BeforeEach(func() {
+		missed = make(map[diodes.Class]int)
+		alert = func(class diodes.Class, n int) {
+			mu.Lock()
+			defer mu.Unlock()
+			missed[class] += n
+		}
+	})
+
+	log := func(id string) *v2.Envelope {
+		return &v2.Envelope{
+			SourceId: id,
+			Message:  &v2.Envelope_Log{Log: &v2.Log{}},
+		}
+	}
+
+	gauge := func(id string) *v2.Envelope {
+		return &v2.Envelope{
+			SourceId: id,
+			Message:  &v2.Envelope_Gauge{Gauge: &v2.Gauge{}},
+		}
+	}
+
+	readAll := func(d *diodes.WaitingMultiClassEnvelopeV2) []*v2.Envelope {
+		var envelopes []*v2.Envelope
+		for {
+			e, ok := d.TryNext()
+			if !ok {
+				return envelopes
+			}
+			envelopes = append(envelopes, e)
+		}
+	}
+
+	It("does not overwrite logs with a burst of metrics", func() {
+		d := diodes.NewWaitingMultiClassEnvelopeV2(diodes.MultiClassConfig{
+			Log:   diodes.ClassConfig{Size: 5},
+			Gauge: diodes.ClassConfig{Size: 5},
+		}, alert)
+
+		for i := 0; i < 3; i++ {
+			d.Set(log("log"))
+		}
+		for i := 0; i < 100; i++ {
+			d.Set(gauge("gauge"))
+		}
+
+		var logs int
+		for _, e := range readAll(d) {
+			if e.GetLog() != nil {
+				logs++
+			}
+		}
+		Expect(logs).To(Equal(3))
+
+		mu.Lock()
+		defer mu.Unlock()
+		Expect(missed[diodes.GaugeClass]).To(BeNumerically(">", 0))
+		Expect(missed).ToNot(HaveKey(diodes.LogClass))
+	})
+
+	It("drains the classes by weight", func() {
+		d := diodes.NewWaitingMultiClassEnvelopeV2(diodes.MultiClassConfig{
+			Log:   diodes.ClassConfig{Size: 10, Weight: 3},
+			Gauge: diodes.ClassConfig{Size: 10, Weight: 1},
+		}, nil)
+
+		for i := 0; i < 6; i++ {
+			d.Set(log("log"))
+			d.Set(gauge("gauge"))
+		}
+
+		var ids []string
+		for _, e := range readAll(d) {
+			ids = append(ids, e.GetSourceId())
+		}
+		Expect(ids).To(Equal([]string{
+			"log", "log", "log", "gauge",
+			"log", "log", "log", "gauge",
+			"gauge", "gauge", "gauge", "gauge",
+		}))
+	})
+
+	It("blocks until data is set", func() {
+		d := diodes.NewWaitingMultiClassEnvelopeV2(diodes.MultiClassConfig{
+			Timer: diodes.ClassConfig{Size: 5},
+		}, nil)
+		received := make(chan *v2.Envelope, 1)
+		go func() {
+			e, _ := d.Next(context.Background())
+			received <- e
+		}()
+
+		Consistently(received, 50*time.Millisecond).ShouldNot(Receive())
+
+		e := &v2.Envelope{Message: &v2.Envelope_Timer{Timer: &v2.Timer{}}}
+		d.Set(e)
+		Eventually(received).Should(Receive(Equal(e)))
+	})
+
+	It("returns when the context is done", func() {
+		d := diodes.NewWaitingMultiClassEnvelopeV2(diodes.MultiClassConfig{}, nil)
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan bool, 1)
+		go func() {
+			_, ok := d.Next(ctx)
+			done <- ok
+		}()
+
+		cancel()
+		Eventually(done).Should(Receive(BeFalse()))
+	})
+})
@@ -0,0 +1,51 @@
+package diodes
+
+import (
+	"context"
+
+	gendiodes "github.com/cloudfoundry/diodes"
+)
+
+// waiter wraps a diode and signals a single reader whenever data is set.
+// The signal channel holds at most one pending notification, so a Set that
+// races with a reader about to block is never missed.
+type waiter struct {
+	d      gendiodes.Diode
+	signal chan struct{}
+}
+
+func newWaiter(d gendiodes.Diode) *waiter {
+	return &waiter{
+		d:      d,
+		signal: make(chan struct{}, 1),
+	}
+}
+
+func (w *waiter) Set(data gendiodes.GenericDataType) {
+	w.d.Set(data)
+
+	select {
+	case w.signal <- struct{}{}:
+	default:
+	}
+}
+
+func (w *waiter) TryNext() (gendiodes.GenericDataType, bool) {
+	return w.d.TryNext()
+}
+
+// Next blocks until data is available or the context is done.
+func (w *waiter) Next(ctx context.Context) (gendiodes.GenericDataType, bool) {
+	for {
+		data, ok := w.d.TryNext()
+		if ok {
+			return data, true
+		}
+
+		select {
+		case <-w.signal:
+		case <-ctx.Done():
+			return nil, false
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package diodes
+
+import (
+	"context"
+
+	gendiodes "github.com/cloudfoundry/diodes"
+	"github.com/cloudfoundry/sonde-go/events"
+)
+
+// WaitingManyToOneEnvelope diode is optimal for many writers and a single
+// reader. Unlike ManyToOneEnvelope the reader blocks until it is notified
+// of new data instead of polling.
+type WaitingManyToOneEnvelope struct {
+	d *waiter
+}
+
+func NewWaitingManyToOneEnvelope(size int, alerter gendiodes.Alerter) *WaitingManyToOneEnvelope {
+	return &WaitingManyToOneEnvelope{
+		d: newWaiter(gendiodes.NewManyToOne(size, alerter)),
+	}
+}
+
+func (d *WaitingManyToOneEnvelope) Set(data *events.Envelope) {
+	d.d.Set(gendiodes.GenericDataType(data))
+}
+
+func (d *WaitingManyToOneEnvelope) TryNext() (*events.Envelope, bool) {
+	data, ok := d.d.TryNext()
+	if !ok {
+		return nil, ok
+	}
+
+	return (*events.Envelope)(data), true
+}
+
+// Next blocks until an envelope is available or the context is done, in
+// which case it returns false.
+func (d *WaitingManyToOneEnvelope) Next(ctx context.Context) (*events.Envelope, bool) {
+	data, ok := d.d.Next(ctx)
+	if !ok {
+		return nil, ok
+	}
+
+	return (*events.Envelope)(data), true
+}
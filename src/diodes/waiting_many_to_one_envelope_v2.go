@@ -0,0 +1,45 @@
+package diodes
+
+import (
+	"context"
+	v2 "plumbing/v2"
+
+	gendiodes "github.com/cloudfoundry/diodes"
+)
+
+// WaitingManyToOneEnvelopeV2 diode is optimal for many writers and a single
+// reader. Unlike ManyToOneEnvelopeV2 the reader blocks until it is notified
+// of new data instead of polling.
+type WaitingManyToOneEnvelopeV2 struct {
+	d *waiter
+}
+
+func NewWaitingManyToOneEnvelopeV2(size int, alerter gendiodes.Alerter) *WaitingManyToOneEnvelopeV2 {
+	return &WaitingManyToOneEnvelopeV2{
+		d: newWaiter(gendiodes.NewManyToOne(size, alerter)),
+	}
+}
+
+func (d *WaitingManyToOneEnvelopeV2) Set(data *v2.Envelope) {
+	d.d.Set(gendiodes.GenericDataType(data))
+}
+
+func (d *WaitingManyToOneEnvelopeV2) TryNext() (*v2.Envelope, bool) {
+	data, ok := d.d.TryNext()
+	if !ok {
+		return nil, ok
+	}
+
+	return (*v2.Envelope)(data), true
+}
+
+// Next blocks until an envelope is available or the context is done, in
+// which case it returns false.
+func (d *WaitingManyToOneEnvelopeV2) Next(ctx context.Context) (*v2.Envelope, bool) {
+	data, ok := d.d.Next(ctx)
+	if !ok {
+		return nil, ok
+	}
+
+	return (*v2.Envelope)(data), true
+}
@@ -0,0 +1,59 @@
+package diodes
+
+import (
+	"context"
+
+	gendiodes "github.com/cloudfoundry/diodes"
+	"github.com/cloudfoundry/sonde-go/events"
+)
+
+// WaitingMultiClassEnvelope diode is a WaitingManyToOneEnvelope with a
+// separate ring for each class of envelope, so that a burst of metrics
+// does not overwrite logs. Value and container metrics are gauges, HTTP
+// start stop events are timers and errors are logs.
+type WaitingMultiClassEnvelope struct {
+	d *multiClass
+}
+
+func NewWaitingMultiClassEnvelope(c MultiClassConfig, alerter ClassAlerter) *WaitingMultiClassEnvelope {
+	return &WaitingMultiClassEnvelope{
+		d: newMultiClass(c, alerter),
+	}
+}
+
+func (d *WaitingMultiClassEnvelope) Set(data *events.Envelope) {
+	d.d.Set(classOf(data), gendiodes.GenericDataType(data))
+}
+
+func (d *WaitingMultiClassEnvelope) TryNext() (*events.Envelope, bool) {
+	data, ok := d.d.TryNext()
+	if !ok {
+		return nil, ok
+	}
+
+	return (*events.Envelope)(data), true
+}
+
+// Next blocks until an envelope is available or the context is done, in
+// which case it returns false.
+func (d *WaitingMultiClassEnvelope) Next(ctx context.Context) (*events.Envelope, bool) {
+	data, ok := d.d.Next(ctx)
+	if !ok {
+		return nil, ok
+	}
+
+	return (*events.Envelope)(data), true
+}
+
+func classOf(e *events.Envelope) Class {
+	switch e.GetEventType() {
+	case events.Envelope_CounterEvent:
+		return CounterClass
+	case events.Envelope_ValueMetric, events.Envelope_ContainerMetric:
+		return GaugeClass
+	case events.Envelope_HttpStartStop:
+		return TimerClass
+	}
+
+	return LogClass
+}
@@ -0,0 +1,58 @@
+package diodes
+
+import (
+	"context"
+	v2 "plumbing/v2"
+
+	gendiodes "github.com/cloudfoundry/diodes"
+)
+
+// WaitingMultiClassEnvelopeV2 diode is a WaitingManyToOneEnvelopeV2 with a
+// separate ring for each class of envelope, so that a burst of metrics
+// does not overwrite logs.
+type WaitingMultiClassEnvelopeV2 struct {
+	d *multiClass
+}
+
+func NewWaitingMultiClassEnvelopeV2(c MultiClassConfig, alerter ClassAlerter) *WaitingMultiClassEnvelopeV2 {
+	return &WaitingMultiClassEnvelopeV2{
+		d: newMultiClass(c, alerter),
+	}
+}
+
+func (d *WaitingMultiClassEnvelopeV2) Set(data *v2.Envelope) {
+	d.d.Set(classOfV2(data), gendiodes.GenericDataType(data))
+}
+
+func (d *WaitingMultiClassEnvelopeV2) TryNext() (*v2.Envelope, bool) {
+	data, ok := d.d.TryNext()
+	if !ok {
+		return nil, ok
+	}
+
+	return (*v2.Envelope)(data), true
+}
+
+// Next blocks until an envelope is available or the context is done, in
+// which case it returns false.
+func (d *WaitingMultiClassEnvelopeV2) Next(ctx context.Context) (*v2.Envelope, bool) {
+	data, ok := d.d.Next(ctx)
+	if !ok {
+		return nil, ok
+	}
+
+	return (*v2.Envelope)(data), true
+}
+
+func classOfV2(e *v2.Envelope) Class {
+	switch e.GetMessage().(type) {
+	case *v2.Envelope_Counter:
+		return CounterClass
+	case *v2.Envelope_Gauge:
+		return GaugeClass
+	case *v2.Envelope_Timer:
+		return TimerClass
+	}
+
+	return LogClass
+}
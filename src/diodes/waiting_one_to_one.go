@@ -0,0 +1,44 @@
+package diodes
+
+import (
+	"context"
+
+	gendiodes "github.com/cloudfoundry/diodes"
+)
+
+// WaitingOneToOne diode is optimized for a single writer and a single
+// reader. Unlike OneToOne the reader blocks until it is notified of new data
+// instead of polling.
+type WaitingOneToOne struct {
+	d *waiter
+}
+
+func NewWaitingOneToOne(size int, alerter gendiodes.Alerter) *WaitingOneToOne {
+	return &WaitingOneToOne{
+		d: newWaiter(gendiodes.NewOneToOne(size, alerter)),
+	}
+}
+
+func (d *WaitingOneToOne) Set(data []byte) {
+	d.d.Set(gendiodes.GenericDataType(&data))
+}
+
+func (d *WaitingOneToOne) TryNext() ([]byte, bool) {
+	data, ok := d.d.TryNext()
+	if !ok {
+		return nil, ok
+	}
+
+	return *(*[]byte)(data), true
+}
+
+// Next blocks until data is available or the context is done, in which case
+// it returns false.
+func (d *WaitingOneToOne) Next(ctx context.Context) ([]byte, bool) {
+	data, ok := d.d.Next(ctx)
+	if !ok {
+		return nil, ok
+	}
+
+	return *(*[]byte)(data), true
+}
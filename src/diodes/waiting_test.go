@@ -0,0 +1,103 @@
+package diodes_test
+
+import (
+	"context"
+	"diodes"
+	"time"
+
+	v2 "plumbing/v2"
+
+	"github.com/cloudfoundry/sonde-go/events"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Waiting diodes", func() {
+	Describe("WaitingManyToOneEnvelopeV2", func() {
+		It("returns data that is already available", func() {
+			d := diodes.NewWaitingManyToOneEnvelopeV2(5, nil)
+			e := &v2.Envelope{SourceId: "some-id"}
+			d.Set(e)
+
+			next, ok := d.Next(context.Background())
+			Expect(ok).To(BeTrue())
+			Expect(next).To(Equal(e))
+		})
+
+		It("blocks until data is set", func() {
+			d := diodes.NewWaitingManyToOneEnvelopeV2(5, nil)
+			received := make(chan *v2.Envelope, 1)
+			go func() {
+				e, _ := d.Next(context.Background())
+				received <- e
+			}()
+
+			Consistently(received, 50*time.Millisecond).ShouldNot(Receive())
+
+			e := &v2.Envelope{SourceId: "some-id"}
+			d.Set(e)
+			Eventually(received).Should(Receive(Equal(e)))
+		})
+
+		It("returns when the context is done", func() {
+			d := diodes.NewWaitingManyToOneEnvelopeV2(5, nil)
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan bool, 1)
+			go func() {
+				_, ok := d.Next(ctx)
+				done <- ok
+			}()
+
+			cancel()
+			Eventually(done).Should(Receive(BeFalse()))
+		})
+
+		It("does not block TryNext", func() {
+			d := diodes.NewWaitingManyToOneEnvelopeV2(5, nil)
+			_, ok := d.TryNext()
+			Expect(ok).To(BeFalse())
+		})
+	})
+
+	Describe("WaitingManyToOneEnvelope", func() {
+		It("blocks until data is set", func() {
+			d := diodes.NewWaitingManyToOneEnvelope(5, nil)
+			received := make(chan bool, 1)
+			go func() {
+				_, ok := d.Next(context.Background())
+				received <- ok
+			}()
+
+			Consistently(received, 50*time.Millisecond).ShouldNot(Receive())
+
+			d.Set(&events.Envelope{})
+			Eventually(received).Should(Receive(BeTrue()))
+		})
+	})
+
+	Describe("WaitingOneToOne", func() {
+		It("blocks until data is set", func() {
+			d := diodes.NewWaitingOneToOne(5, nil)
+			received := make(chan []byte, 1)
+			go func() {
+				data, _ := d.Next(context.Background())
+				received <- data
+			}()
+
+			Consistently(received, 50*time.Millisecond).ShouldNot(Receive())
+
+			d.Set([]byte("some-data"))
+			Eventually(received).Should(Receive(Equal([]byte("some-data"))))
+		})
+
+		It("returns when the context is done", func() {
+			d := diodes.NewWaitingOneToOne(5, nil)
+			ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+			defer cancel()
+
+			_, ok := d.Next(ctx)
+			Expect(ok).To(BeFalse())
+		})
+	})
+})
@@ -30,6 +30,26 @@ type GRPC struct {
 	KeyFile  string
 }
 
+// BufferClass is the number of envelopes of one type buffered between
+// ingress and the sinks and the number read from it in a row while other
+// types wait.
+type BufferClass struct {
+	Size   int
+	Weight int
+}
+
+// EnvelopeBuffer configures the buffer between ingress and the sinks. Every
+// type of envelope shares a single ring unless Classes is set, in which case
+// logs, counters, gauges and timers each have their own so that a burst of
+// one type does not overwrite the others.
+type EnvelopeBuffer struct {
+	Classes bool
+	Log     BufferClass
+	Counter BufferClass
+	Gauge   BufferClass
+	Timer   BufferClass
+}
+
 type Config struct {
 	DisableSyslogDrains             bool
 	DisableAnnounce                 bool
@@ -63,6 +83,7 @@ type Config struct {
 	Zone                            string
 	PPROFPort                       uint32
 	HealthAddr                      string
+	EnvelopeBuffer                  EnvelopeBuffer
 }
 
 func (c *Config) validate() (err error) {
@@ -120,6 +141,12 @@ func ParseConfig(configFile string) (*Config, error) {
 func Parse(confData []byte) (*Config, error) {
 	config := &Config{
 		IncomingUDPPort: 3456,
+		EnvelopeBuffer: EnvelopeBuffer{
+			Log:     BufferClass{Size: 10000, Weight: 4},
+			Counter: BufferClass{Size: 5000, Weight: 1},
+			Gauge:   BufferClass{Size: 5000, Weight: 1},
+			Timer:   BufferClass{Size: 5000, Weight: 1},
+		},
 	}
 
 	err := json.Unmarshal(confData, config)
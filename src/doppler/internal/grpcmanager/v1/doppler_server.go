@@ -132,22 +132,14 @@ func marshalEnvelopes(envelopes []*events.Envelope) [][]byte {
 }
 
 func (m *DopplerServer) sendData(req *plumbing.SubscriptionRequest, sender sender) error {
-	d := diodes.NewOneToOne(1000, m)
+	d := diodes.NewWaitingOneToOne(1000, m)
 	cleanup := m.registrar.Register(req, d)
 	defer cleanup()
 
-	var done int64
-	go m.monitorContext(sender.Context(), &done)
-
 	for {
-		if atomic.LoadInt64(&done) > 0 {
-			break
-		}
-
-		data, ok := d.TryNext()
+		data, ok := d.Next(sender.Context())
 		if !ok {
-			time.Sleep(10 * time.Millisecond)
-			continue
+			break
 		}
 
 		err := sender.Send(&plumbing.Response{Payload: data})
@@ -171,8 +163,3 @@ func (m *DopplerServer) Alert(missed int) {
 
 	log.Printf("Dropped (egress) %d envelopes", missed)
 }
-
-func (m *DopplerServer) monitorContext(ctx context.Context, done *int64) {
-	<-ctx.Done()
-	atomic.StoreInt64(done, 1)
-}
@@ -1,9 +1,13 @@
 package v2
 
 import (
+	"log"
+	"math"
 	"metricemitter"
 	"plumbing/conversion"
 	plumbing "plumbing/v2"
+	"sync"
+	"time"
 
 	"github.com/cloudfoundry/dropsonde/metricbatcher"
 	"github.com/cloudfoundry/sonde-go/events"
@@ -26,11 +30,31 @@ type DataSetter interface {
 	Set(data *events.Envelope)
 }
 
+// ShedCounter is implemented by DataSetters that drop envelopes when they
+// fall behind. Received returns the number of envelopes set so far, from
+// every ingress, and Shed the number dropped.
+type ShedCounter interface {
+	Received() uint64
+	Shed() uint64
+}
+
 type IngressServer struct {
 	envelopeBuffer DataSetter
 	batcher        Batcher
 	ingressMetric  *metricemitter.CounterMetric
 	health         HealthRegistrar
+	ackInterval    time.Duration
+}
+
+// IngressServerOption is a type that will manipulate an IngressServer
+type IngressServerOption func(*IngressServer)
+
+// WithAckInterval sets how often a BatchAck is sent on AckedBatchSender
+// streams. It defaults to one second.
+func WithAckInterval(d time.Duration) IngressServerOption {
+	return func(i *IngressServer) {
+		i.ackInterval = d
+	}
 }
 
 func NewIngressServer(
@@ -38,17 +62,25 @@ func NewIngressServer(
 	batcher Batcher,
 	metricClient metricemitter.MetricClient,
 	health HealthRegistrar,
+	opts ...IngressServerOption,
 ) *IngressServer {
 	ingressMetric := metricClient.NewCounterMetric("ingress",
 		metricemitter.WithVersion(2, 0),
 	)
 
-	return &IngressServer{
+	i := &IngressServer{
 		envelopeBuffer: envelopeBuffer,
 		batcher:        batcher,
 		ingressMetric:  ingressMetric,
 		health:         health,
+		ackInterval:    time.Second,
+	}
+
+	for _, o := range opts {
+		o(i)
 	}
+
+	return i
 }
 
 func (i IngressServer) BatchSender(s plumbing.DopplerIngress_BatchSenderServer) error {
@@ -62,23 +94,68 @@ func (i IngressServer) BatchSender(s plumbing.DopplerIngress_BatchSenderServer)
 		}
 
 		for _, v2e := range v2eBatch.Batch {
-			envelopes := conversion.ToV1(v2e)
-			for _, v1e := range envelopes {
-				if v1e == nil || v1e.EventType == nil {
-					continue
-				}
-
-				i.envelopeBuffer.Set(v1e)
-
-				// metric-documentation-v1: (listeners.totalReceivedMessageCount)
-				// Total number of messages received by doppler.
-				i.batcher.BatchCounter("listeners.totalReceivedMessageCount").
-					Increment()
-
-				// metric-documentation-v2: (loggregator.doppler.ingress) Number of received
-				// envelopes from Metron on Doppler's v2 gRPC server
-				i.ingressMetric.Increment(1)
+			i.set(v2e)
+		}
+	}
+}
+
+// AckedBatchSender receives batches like BatchSender. Every ack interval it
+// sends back a BatchAck with the number of envelopes accepted from the
+// stream and the stream's share of the envelopes shed by the envelope
+// buffer in that window.
+func (i IngressServer) AckedBatchSender(s plumbing.DopplerIngress_AckedBatchSenderServer) error {
+	i.health.Inc("ingressStreamCount")
+	defer i.health.Dec("ingressStreamCount")
+
+	shed, _ := i.envelopeBuffer.(ShedCounter)
+	w := newAckWindow(shed)
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		i.sendAcks(s, w, done)
+	}()
+	defer wg.Wait()
+	defer close(done)
+
+	for {
+		v2eBatch, err := s.Recv()
+		if err != nil {
+			return err
+		}
+
+		var n uint64
+		for _, v2e := range v2eBatch.Batch {
+			n += i.set(v2e)
+		}
+		w.add(n)
+	}
+}
+
+func (i IngressServer) sendAcks(
+	s plumbing.DopplerIngress_AckedBatchSenderServer,
+	w *ackWindow,
+	done chan struct{},
+) {
+	t := time.NewTicker(i.ackInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+			ack := w.ack()
+			if ack.Batches == 0 {
+				continue
+			}
+
+			if err := s.Send(ack); err != nil {
+				log.Printf("failed to send ack to metron: %s", err)
+				return
 			}
+		case <-done:
+			return
 		}
 	}
 }
@@ -93,22 +170,94 @@ func (i IngressServer) Sender(s plumbing.DopplerIngress_SenderServer) error {
 			return err
 		}
 
-		envelopes := conversion.ToV1(v2e)
-		for _, v1e := range envelopes {
-			if v1e == nil || v1e.EventType == nil {
-				continue
-			}
+		i.set(v2e)
+	}
+}
+
+// set converts the envelope to v1 and sets it on the envelope buffer. It
+// returns the number of v1 envelopes set.
+func (i IngressServer) set(v2e *plumbing.Envelope) uint64 {
+	var n uint64
+	envelopes := conversion.ToV1(v2e)
+	for _, v1e := range envelopes {
+		if v1e == nil || v1e.EventType == nil {
+			continue
+		}
+
+		i.envelopeBuffer.Set(v1e)
+		n++
+
+		// metric-documentation-v1: (listeners.totalReceivedMessageCount)
+		// Total number of messages received by doppler.
+		i.batcher.BatchCounter("listeners.totalReceivedMessageCount").
+			Increment()
+
+		// metric-documentation-v2: (loggregator.doppler.ingress) Number of received
+		// envelopes from Metron on Doppler's v2 gRPC server
+		i.ingressMetric.Increment(1)
+	}
+
+	return n
+}
 
-			i.envelopeBuffer.Set(v1e)
+// ackWindow counts the batches and envelopes received on a stream since the
+// last BatchAck.
+type ackWindow struct {
+	shed ShedCounter
 
-			// metric-documentation-v1: (listeners.totalReceivedMessageCount)
-			// Total number of messages received by doppler.
-			i.batcher.BatchCounter("listeners.totalReceivedMessageCount").
-				Increment()
+	mu           sync.Mutex
+	batches      uint64
+	accepted     uint64
+	lastReceived uint64
+	lastShed     uint64
+}
+
+func newAckWindow(shed ShedCounter) *ackWindow {
+	w := &ackWindow{
+		shed: shed,
+	}
+
+	if shed != nil {
+		w.lastReceived = shed.Received()
+		w.lastShed = shed.Shed()
+	}
+
+	return w
+}
+
+func (w *ackWindow) add(accepted uint64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
 
-			// metric-documentation-v2: (loggregator.doppler.ingress) Number of received
-			// envelopes from Metron on Doppler's v2 gRPC server
-			i.ingressMetric.Increment(1)
+	w.batches++
+	w.accepted += accepted
+}
+
+// ack returns the BatchAck for the window and starts a new one. The buffer
+// is shared by every stream and does not know which stream an envelope came
+// from, so each stream is given a share of the envelopes shed in the window
+// in proportion to its share of the envelopes received from every ingress.
+func (w *ackWindow) ack() *plumbing.BatchAck {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	ack := &plumbing.BatchAck{
+		Batches:  w.batches,
+		Accepted: w.accepted,
+	}
+
+	if w.shed != nil {
+		received, shed := w.shed.Received(), w.shed.Shed()
+		if r := received - w.lastReceived; r > 0 {
+			share := float64(shed-w.lastShed) * float64(w.accepted) / float64(r)
+			ack.Shed = uint64(math.Min(share, float64(w.accepted)))
 		}
+		w.lastReceived = received
+		w.lastShed = shed
 	}
+
+	w.batches = 0
+	w.accepted = 0
+
+	return ack
 }
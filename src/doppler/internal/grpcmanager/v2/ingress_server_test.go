@@ -6,8 +6,11 @@ import (
 	"metricemitter/testhelper"
 	plumbing "plumbing/v2"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/cloudfoundry/dropsonde/metricbatcher"
+	"github.com/cloudfoundry/sonde-go/events"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 )
@@ -90,6 +93,82 @@ var _ = Describe("Ingress", func() {
 		Expect(mockDataSetter.SetCalled).To(HaveLen(0))
 	})
 
+	Describe("AckedBatchSender()", func() {
+		It("acks the envelopes accepted and its share of those shed", func() {
+			ingestor = v2.NewIngressServer(
+				&SpyShedCountingSetter{},
+				SpyBatcher{},
+				testhelper.NewMetricClient(),
+				healthRegistrar,
+				v2.WithAckInterval(10*time.Millisecond),
+			)
+			spyStream := newSpyAckedBatchSenderServer()
+			go ingestor.AckedBatchSender(spyStream)
+
+			spyStream.batches <- &plumbing.EnvelopeBatch{
+				Batch: []*plumbing.Envelope{
+					{Message: &plumbing.Envelope_Log{Log: &plumbing.Log{}}},
+					{Message: &plumbing.Envelope_Log{Log: &plumbing.Log{}}},
+				},
+			}
+
+			var ack *plumbing.BatchAck
+			Eventually(spyStream.acks).Should(Receive(&ack))
+			Expect(ack.GetBatches()).To(Equal(uint64(1)))
+			Expect(ack.GetAccepted()).To(Equal(uint64(2)))
+			Expect(ack.GetShed()).To(Equal(uint64(1)))
+
+			close(spyStream.batches)
+		})
+
+		It("shares the envelopes shed with the other ingress", func() {
+			spySetter := &SpyShedCountingSetter{}
+			ingestor = v2.NewIngressServer(
+				spySetter,
+				SpyBatcher{},
+				testhelper.NewMetricClient(),
+				healthRegistrar,
+				v2.WithAckInterval(100*time.Millisecond),
+			)
+			spyStream := newSpyAckedBatchSenderServer()
+			go ingestor.AckedBatchSender(spyStream)
+
+			spyStream.batches <- &plumbing.EnvelopeBatch{
+				Batch: []*plumbing.Envelope{
+					{Message: &plumbing.Envelope_Log{Log: &plumbing.Log{}}},
+					{Message: &plumbing.Envelope_Log{Log: &plumbing.Log{}}},
+				},
+			}
+			Eventually(spySetter.Received).Should(Equal(uint64(2)))
+
+			// Envelopes from v1 and the other ingress share the buffer.
+			spySetter.Set(&events.Envelope{})
+			spySetter.Set(&events.Envelope{})
+
+			var ack *plumbing.BatchAck
+			Eventually(spyStream.acks).Should(Receive(&ack))
+			Expect(ack.GetAccepted()).To(Equal(uint64(2)))
+			Expect(ack.GetShed()).To(Equal(uint64(1)))
+
+			close(spyStream.batches)
+		})
+
+		It("does not ack windows without batches", func() {
+			ingestor = v2.NewIngressServer(
+				mockDataSetter,
+				SpyBatcher{},
+				testhelper.NewMetricClient(),
+				healthRegistrar,
+				v2.WithAckInterval(10*time.Millisecond),
+			)
+			spyStream := newSpyAckedBatchSenderServer()
+			go ingestor.AckedBatchSender(spyStream)
+
+			Consistently(spyStream.acks).ShouldNot(Receive())
+			close(spyStream.batches)
+		})
+	})
+
 	Describe("health monitoring", func() {
 		Describe("Sender()", func() {
 			It("increments and decrements the number of ingress streams", func() {
@@ -127,6 +206,51 @@ var _ = Describe("Ingress", func() {
 	})
 })
 
+// SpyShedCountingSetter sheds every other envelope it is given.
+type SpyShedCountingSetter struct {
+	count uint64
+}
+
+func (s *SpyShedCountingSetter) Set(*events.Envelope) {
+	atomic.AddUint64(&s.count, 1)
+}
+
+func (s *SpyShedCountingSetter) Received() uint64 {
+	return atomic.LoadUint64(&s.count)
+}
+
+func (s *SpyShedCountingSetter) Shed() uint64 {
+	return atomic.LoadUint64(&s.count) / 2
+}
+
+type SpyAckedBatchSenderServer struct {
+	plumbing.DopplerIngress_AckedBatchSenderServer
+
+	batches chan *plumbing.EnvelopeBatch
+	acks    chan *plumbing.BatchAck
+}
+
+func newSpyAckedBatchSenderServer() *SpyAckedBatchSenderServer {
+	return &SpyAckedBatchSenderServer{
+		batches: make(chan *plumbing.EnvelopeBatch),
+		acks:    make(chan *plumbing.BatchAck, 100),
+	}
+}
+
+func (s *SpyAckedBatchSenderServer) Recv() (*plumbing.EnvelopeBatch, error) {
+	b, ok := <-s.batches
+	if !ok {
+		return nil, io.EOF
+	}
+
+	return b, nil
+}
+
+func (s *SpyAckedBatchSenderServer) Send(ack *plumbing.BatchAck) error {
+	s.acks <- ack
+	return nil
+}
+
 type SpyBatcher struct {
 	metricbatcher.BatchCounterChainer
 }
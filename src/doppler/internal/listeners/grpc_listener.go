@@ -1,7 +1,6 @@
 package listeners
 
 import (
-	"diodes"
 	"doppler/app"
 	"doppler/internal/grpcmanager/v1"
 	"doppler/internal/grpcmanager/v2"
@@ -15,11 +14,17 @@ import (
 	plumbingv2 "plumbing/v2"
 
 	"github.com/cloudfoundry/dropsonde/metricbatcher"
+	"github.com/cloudfoundry/sonde-go/events"
 
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
 )
 
+// EnvelopeSetter receives the envelopes sent to the gRPC ingress.
+type EnvelopeSetter interface {
+	Set(*events.Envelope)
+}
+
 type GRPCListener struct {
 	listener net.Listener
 	server   *grpc.Server
@@ -29,7 +34,7 @@ func NewGRPCListener(
 	reg v1.Registrar,
 	sinkmanager *sinkmanager.SinkManager,
 	conf app.GRPC,
-	envelopeBuffer *diodes.ManyToOneEnvelope,
+	envelopeBuffer EnvelopeSetter,
 	batcher *metricbatcher.MetricBatcher,
 	metricClient metricemitter.MetricClient,
 	health *healthendpoint.Registrar,
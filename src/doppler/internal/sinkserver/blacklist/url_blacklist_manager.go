@@ -5,9 +5,11 @@ import (
 	"errors"
 	"log"
 	"net/url"
+	"sync"
 )
 
 type URLBlacklistManager struct {
+	mu              sync.RWMutex
 	blacklistIPs    []iprange.IPRange
 	blacklistedURLs []string
 }
@@ -16,13 +18,24 @@ func New(blacklistIPs []iprange.IPRange) *URLBlacklistManager {
 	return &URLBlacklistManager{blacklistIPs: blacklistIPs}
 }
 
+// SetBlacklistIPs replaces the IP ranges that URLs are checked against.
+func (blacklistManager *URLBlacklistManager) SetBlacklistIPs(blacklistIPs []iprange.IPRange) {
+	blacklistManager.mu.Lock()
+	defer blacklistManager.mu.Unlock()
+	blacklistManager.blacklistIPs = blacklistIPs
+}
+
 func (blacklistManager *URLBlacklistManager) CheckUrl(rawUrl string) (outputURL *url.URL, err error) {
 	outputURL, err = url.Parse(rawUrl)
 	if err != nil {
 		return nil, err
 	}
 
-	ipNotBlacklisted, err := iprange.IpOutsideOfRanges(*outputURL, blacklistManager.blacklistIPs)
+	blacklistManager.mu.RLock()
+	blacklistIPs := blacklistManager.blacklistIPs
+	blacklistManager.mu.RUnlock()
+
+	ipNotBlacklisted, err := iprange.IpOutsideOfRanges(*outputURL, blacklistIPs)
 	if err != nil {
 		_, ok := err.(iprange.ResolutionFailure)
 		if !ok {
@@ -49,4 +49,16 @@ var _ = Describe("UrlBlacklistManager", func() {
 			Expect(err.Error()).To(MatchRegexp("(?i:incomplete url)"))
 		})
 	})
+
+	Describe("SetBlacklistIPs", func() {
+		It("checks URLs against the new IP ranges", func() {
+			urlBlacklistManager.SetBlacklistIPs([]iprange.IPRange{{Start: "10.10.10.1", End: "10.10.10.20"}})
+
+			_, err := urlBlacklistManager.CheckUrl("http://10.10.10.10")
+			Expect(err).To(MatchError("Syslog Drain URL is blacklisted"))
+
+			_, err = urlBlacklistManager.CheckUrl("http://14.15.16.18")
+			Expect(err).NotTo(HaveOccurred())
+		})
+	})
 })
@@ -1,7 +1,7 @@
 package sinkserver
 
 import (
-	"diodes"
+	"context"
 	"log"
 	"sync"
 
@@ -19,6 +19,11 @@ type EnvelopeSender interface {
 	SendTo(string, *events.Envelope)
 }
 
+// EnvelopeReader is a buffer of envelopes with a single reader.
+type EnvelopeReader interface {
+	Next(ctx context.Context) (*events.Envelope, bool)
+}
+
 func NewMessageRouter(e ...EnvelopeSender) *MessageRouter {
 	return &MessageRouter{
 		senders: e,
@@ -26,11 +31,14 @@ func NewMessageRouter(e ...EnvelopeSender) *MessageRouter {
 	}
 }
 
-func (r *MessageRouter) Start(incomingLog *diodes.ManyToOneEnvelope) {
+func (r *MessageRouter) Start(incomingLog EnvelopeReader) {
 	log.Print("MessageRouter:Starting")
 
 	for {
-		envelope := incomingLog.Next()
+		envelope, ok := incomingLog.Next(context.Background())
+		if !ok {
+			continue
+		}
 		appId := envelope_extensions.GetAppId(envelope)
 
 		for _, sm := range r.senders {
@@ -67,9 +67,9 @@ var _ = Describe("Message Router", func() {
 
 	Describe("Start", func() {
 		Context("with an incoming message", func() {
-			var incoming *diodes.ManyToOneEnvelope
+			var incoming *diodes.WaitingManyToOneEnvelope
 			BeforeEach(func() {
-				incoming = diodes.NewManyToOneEnvelope(5, nil)
+				incoming = diodes.NewWaitingManyToOneEnvelope(5, nil)
 				go messageRouter.Start(incoming)
 			})
 
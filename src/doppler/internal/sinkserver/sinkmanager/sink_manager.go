@@ -33,8 +33,13 @@ type HealthRegistrar interface {
 }
 
 type SinkManager struct {
+	settingsMu             sync.RWMutex
 	messageDrainBufferSize uint
-	dropsondeOrigin        string
+	sinkTimeout            time.Duration
+	sinkIOTimeout          time.Duration
+	dialTimeout            time.Duration
+
+	dropsondeOrigin string
 
 	metrics        *metrics.SinkManagerMetrics
 	recentLogCount uint32
@@ -44,10 +49,7 @@ type SinkManager struct {
 	urlBlacklistManager *blacklist.URLBlacklistManager
 	sinks               *groupedsinks.GroupedSinks
 	skipCertVerify      bool
-	sinkTimeout         time.Duration
-	sinkIOTimeout       time.Duration
 	metricTTL           time.Duration
-	dialTimeout         time.Duration
 	health              HealthRegistrar
 
 	stopOnce sync.Once
@@ -85,6 +87,23 @@ func New(
 	}
 }
 
+// SetSinkSettings replaces the drain buffer size and timeouts given to
+// sinks. Sinks that already exist keep the settings they were created with.
+func (sm *SinkManager) SetSinkSettings(
+	messageDrainBufferSize uint,
+	sinkTimeout,
+	sinkIOTimeout,
+	dialTimeout time.Duration,
+) {
+	sm.settingsMu.Lock()
+	defer sm.settingsMu.Unlock()
+
+	sm.messageDrainBufferSize = messageDrainBufferSize
+	sm.sinkTimeout = sinkTimeout
+	sm.sinkIOTimeout = sinkIOTimeout
+	sm.dialTimeout = dialTimeout
+}
+
 func (sm *SinkManager) Start(newAppServiceChan, deletedAppServiceChan <-chan store.AppService) {
 	go sm.listenForNewAppServices(newAppServiceChan)
 	go sm.listenForDeletedAppServices(deletedAppServiceChan)
@@ -243,13 +262,19 @@ func (sm *SinkManager) registerNewSyslogSink(appId, syslogSinkURL, hostname stri
 		return
 	}
 
+	sm.settingsMu.RLock()
+	messageDrainBufferSize := sm.messageDrainBufferSize
+	dialTimeout := sm.dialTimeout
+	sinkIOTimeout := sm.sinkIOTimeout
+	sm.settingsMu.RUnlock()
+
 	syslogWriter, err := syslogwriter.NewWriter(
 		parsedSyslogDrainURL,
 		appId,
 		hostname,
 		sm.skipCertVerify,
-		sm.dialTimeout,
-		sm.sinkIOTimeout,
+		dialTimeout,
+		sinkIOTimeout,
 	)
 	if err != nil {
 		logURL := fmt.Sprintf("%s://%s%s", parsedSyslogDrainURL.Scheme, parsedSyslogDrainURL.Host, parsedSyslogDrainURL.Path)
@@ -260,7 +285,7 @@ func (sm *SinkManager) registerNewSyslogSink(appId, syslogSinkURL, hostname stri
 	syslogSink := syslog.NewSyslogSink(
 		appId,
 		parsedSyslogDrainURL,
-		sm.messageDrainBufferSize,
+		messageDrainBufferSize,
 		syslogWriter,
 		sm.SendSyslogErrorToLoggregator,
 		sm.dropsondeOrigin,
@@ -281,7 +306,7 @@ func (sm *SinkManager) ensureRecentLogsSinkFor(appId string) {
 	sink := dump.NewDumpSink(
 		appId,
 		sm.recentLogCount,
-		sm.sinkTimeout,
+		sm.inactivityTimeout(),
 		sm.health,
 	)
 
@@ -296,9 +321,15 @@ func (sm *SinkManager) ensureContainerMetricsSinkFor(appId string) {
 	sink := containermetric.NewContainerMetricSink(
 		appId,
 		sm.metricTTL,
-		sm.sinkTimeout,
+		sm.inactivityTimeout(),
 		sm.health,
 	)
 
 	sm.RegisterSink(sink)
 }
+
+func (sm *SinkManager) inactivityTimeout() time.Duration {
+	sm.settingsMu.RLock()
+	defer sm.settingsMu.RUnlock()
+	return sm.sinkTimeout
+}
@@ -31,7 +31,7 @@ var _ = Describe("Dumping", func() {
 		sinkManager         *sinkmanager.SinkManager
 		TestMessageRouter   *sinkserver.MessageRouter
 		TestWebsocketServer *websocketserver.WebsocketServer
-		dataRead            *diodes.ManyToOneEnvelope
+		dataRead            *diodes.WaitingManyToOneEnvelope
 		services            sync.WaitGroup
 		serverPort          string
 		mockBatcher         *mockBatcher
@@ -46,7 +46,7 @@ var _ = Describe("Dumping", func() {
 
 		port := 9081 + config.GinkgoConfig.ParallelNode
 		serverPort = strconv.Itoa(port)
-		dataRead = diodes.NewManyToOneEnvelope(5, nil)
+		dataRead = diodes.NewWaitingManyToOneEnvelope(5, nil)
 
 		newAppServiceChan := make(chan store.AppService)
 		deletedAppServiceChan := make(chan store.AppService)
@@ -65,7 +65,7 @@ var _ = Describe("Dumping", func() {
 		TestMessageRouter = sinkserver.NewMessageRouter(sinkManager)
 		tempMessageRouter := TestMessageRouter
 
-		go func(dataRead *diodes.ManyToOneEnvelope) {
+		go func(dataRead *diodes.WaitingManyToOneEnvelope) {
 			tempMessageRouter.Start(dataRead)
 		}(dataRead)
 
@@ -10,6 +10,7 @@ import (
 	"net"
 	"net/http"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/cloudfoundry/dropsonde/metricbatcher"
@@ -69,10 +70,13 @@ func (f *firehoseCounter) Increment(typ events.Envelope_EventType) {
 }
 
 type WebsocketServer struct {
+	// bufferSize is accessed atomically. It is kept first for 64-bit
+	// alignment.
+	bufferSize uint64
+
 	sinkManager       *sinkmanager.SinkManager
 	writeTimeout      time.Duration
 	keepAliveInterval time.Duration
-	bufferSize        uint
 	batcher           Batcher
 	listener          net.Listener
 	dropsondeOrigin   string
@@ -100,13 +104,19 @@ func New(
 		sinkManager:       sinkManager,
 		writeTimeout:      writeTimeout,
 		keepAliveInterval: keepAliveInterval,
-		bufferSize:        messageDrainBufferSize,
+		bufferSize:        uint64(messageDrainBufferSize),
 		batcher:           batcher,
 		dropsondeOrigin:   dropsondeOrigin,
 		done:              make(chan struct{}),
 	}, nil
 }
 
+// SetBufferSize replaces the buffer size of websocket sinks created from
+// now on.
+func (w *WebsocketServer) SetBufferSize(messageDrainBufferSize uint) {
+	atomic.StoreUint64(&w.bufferSize, uint64(messageDrainBufferSize))
+}
+
 func (w *WebsocketServer) Start() {
 	s := &http.Server{
 		ReadTimeout:  5 * time.Second,
@@ -220,7 +230,7 @@ func (w *WebsocketServer) streamLogs(appId string, websocketConnection *gorilla.
 	websocketSink := websocket.NewWebsocketSink(
 		appId,
 		websocketConnection,
-		w.bufferSize,
+		uint(atomic.LoadUint64(&w.bufferSize)),
 		w.writeTimeout,
 		w.dropsondeOrigin,
 	)
@@ -234,7 +244,7 @@ func (w *WebsocketServer) streamFirehose(subscriptionId string, websocketConnect
 	websocketSink := websocket.NewWebsocketSink(
 		subscriptionId,
 		websocketConnection,
-		w.bufferSize,
+		uint(atomic.LoadUint64(&w.bufferSize)),
 		w.writeTimeout,
 		w.dropsondeOrigin,
 	)
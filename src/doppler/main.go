@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"dopplerservice"
 	"flag"
 	"fmt"
@@ -12,6 +13,7 @@ import (
 	"os/signal"
 	"plumbing"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"diodes"
@@ -25,6 +27,7 @@ import (
 	"doppler/internal/store"
 	"monitor"
 	"profiler"
+	"reload"
 
 	"code.cloudfoundry.org/workpool"
 	gendiodes "github.com/cloudfoundry/diodes"
@@ -124,10 +127,11 @@ func main() {
 	//------------------------------
 	// Caching
 	//------------------------------
+	urlBlacklistManager := blacklist.New(conf.BlackListIps)
 	sinkManager := sinkmanager.New(
 		conf.MaxRetainedLogMessages,
 		conf.SinkSkipCertVerify,
-		blacklist.New(conf.BlackListIps),
+		urlBlacklistManager,
 		conf.MessageDrainBufferSize,
 		dopplerOrigin,
 		time.Duration(conf.SinkInactivityTimeoutSeconds)*time.Second,
@@ -150,21 +154,7 @@ func main() {
 	errChan := make(chan error)
 	dropsondeUnmarshallerCollection := dropsonde_unmarshaller.NewDropsondeUnmarshallerCollection(conf.UnmarshallerCount)
 
-	droppedMetric := metricClient.NewCounterMetric("dropped",
-		metricemitter.WithVersion(2, 0),
-		metricemitter.WithTags(map[string]string{"direction": "ingress"}),
-	)
-
-	envelopeBuffer := diodes.NewManyToOneEnvelope(10000, gendiodes.AlertFunc(func(missed int) {
-		log.Printf("Shed %d envelopes", missed)
-		// metric-documentation-v1: (doppler.shedEnvelopes) Number of envelopes dropped by the
-		// diode inbound from metron
-		batcher.BatchCounter("doppler.shedEnvelopes").Add(uint64(missed))
-
-		// metric-documentation-v2: (loggregator.doppler.dropped) Number of envelopes dropped by the
-		// diode inbound from metron
-		droppedMetric.Increment(uint64(missed))
-	}))
+	envelopeBuffer := newEnvelopeBuffer(conf.EnvelopeBuffer, batcher, metricClient)
 
 	udpListener, dropsondeBytesChan := listeners.NewUDPListener(
 		fmt.Sprintf("%s:%d", conf.IP, conf.IncomingUDPPort),
@@ -242,6 +232,11 @@ func main() {
 	p := profiler.New(conf.PPROFPort)
 	go p.Start()
 
+	reload.Notify(
+		reloader(*configFile, conf, urlBlacklistManager, sinkManager, websocketServer),
+		metricClient,
+	)
+
 	//------------------------------
 	// Post Start
 	//------------------------------
@@ -257,7 +252,7 @@ func start(
 	dropsondeUnmarshallerCollection *dropsonde_unmarshaller.DropsondeUnmarshallerCollection,
 	openFileMonitor *monitor.LinuxFileDescriptor,
 	uptimeMonitor *monitor.Uptime,
-	envelopeBuffer *diodes.ManyToOneEnvelope,
+	envelopeBuffer envelopeBuffer,
 	appStoreWatcher *store.AppServiceStoreWatcher,
 	newAppServiceChan <-chan store.AppService,
 	deletedAppServiceChan <-chan store.AppService,
@@ -317,6 +312,140 @@ func start(
 	}
 }
 
+// reloadable are the Config fields that are applied without a restart.
+var reloadable = []string{
+	"BlackListIps",
+	"MessageDrainBufferSize",
+	"SinkDialTimeoutSeconds",
+	"SinkIOTimeoutSeconds",
+	"SinkInactivityTimeoutSeconds",
+}
+
+// envelopeBuffer holds the envelopes received from metron until they are
+// routed to sinks.
+type envelopeBuffer interface {
+	Set(*events.Envelope)
+	Next(ctx context.Context) (*events.Envelope, bool)
+}
+
+// shedCountingBuffer counts the envelopes set on and shed by an
+// envelopeBuffer so that the share of each metron can be reported back to
+// it.
+type shedCountingBuffer struct {
+	envelopeBuffer
+	received uint64
+	shed     uint64
+}
+
+// Set counts the envelope and sets it on the envelopeBuffer.
+func (b *shedCountingBuffer) Set(e *events.Envelope) {
+	atomic.AddUint64(&b.received, 1)
+	b.envelopeBuffer.Set(e)
+}
+
+// Received returns the number of envelopes set so far.
+func (b *shedCountingBuffer) Received() uint64 {
+	return atomic.LoadUint64(&b.received)
+}
+
+// Shed returns the number of envelopes shed so far.
+func (b *shedCountingBuffer) Shed() uint64 {
+	return atomic.LoadUint64(&b.shed)
+}
+
+// newEnvelopeBuffer returns a single ring of 10000 envelopes, or a ring per
+// class of envelope when conf.Classes is set.
+func newEnvelopeBuffer(
+	conf app.EnvelopeBuffer,
+	batcher *metricbatcher.MetricBatcher,
+	metricClient metricemitter.MetricClient,
+) *shedCountingBuffer {
+	b := &shedCountingBuffer{}
+	droppedMetric := func(tags map[string]string) *metricemitter.CounterMetric {
+		tags["direction"] = "ingress"
+		return metricClient.NewCounterMetric("dropped",
+			metricemitter.WithVersion(2, 0),
+			metricemitter.WithTags(tags),
+		)
+	}
+
+	if !conf.Classes {
+		m := droppedMetric(map[string]string{})
+		b.envelopeBuffer = diodes.NewWaitingManyToOneEnvelope(10000, gendiodes.AlertFunc(func(missed int) {
+			log.Printf("Shed %d envelopes", missed)
+			atomic.AddUint64(&b.shed, uint64(missed))
+			// metric-documentation-v1: (doppler.shedEnvelopes) Number of envelopes dropped by the
+			// diode inbound from metron
+			batcher.BatchCounter("doppler.shedEnvelopes").Add(uint64(missed))
+
+			// metric-documentation-v2: (loggregator.doppler.dropped) Number of envelopes dropped by the
+			// diode inbound from metron
+			m.Increment(uint64(missed))
+		}))
+		return b
+	}
+
+	metrics := make(map[diodes.Class]*metricemitter.CounterMetric)
+	for _, class := range diodes.Classes() {
+		metrics[class] = droppedMetric(map[string]string{"class": class.String()})
+	}
+
+	b.envelopeBuffer = diodes.NewWaitingMultiClassEnvelope(diodes.MultiClassConfig{
+		Log:     diodes.ClassConfig(conf.Log),
+		Counter: diodes.ClassConfig(conf.Counter),
+		Gauge:   diodes.ClassConfig(conf.Gauge),
+		Timer:   diodes.ClassConfig(conf.Timer),
+	}, diodes.ClassAlertFunc(func(class diodes.Class, missed int) {
+		log.Printf("Shed %d %s envelopes", missed, class)
+		atomic.AddUint64(&b.shed, uint64(missed))
+		// metric-documentation-v1: (doppler.shedEnvelopes) Number of envelopes dropped by the
+		// diode inbound from metron
+		batcher.BatchCounter("doppler.shedEnvelopes").
+			SetTag("class", class.String()).
+			Add(uint64(missed))
+
+		// metric-documentation-v2: (loggregator.doppler.dropped) Number of envelopes dropped by the
+		// diode inbound from metron by envelope class
+		metrics[class].Increment(uint64(missed))
+	}))
+
+	return b
+}
+
+// reloader returns a func that re-reads the config file and applies it to
+// drains and websocket streams created from then on. It fails without
+// applying anything when a field that is not reloadable has changed.
+func reloader(
+	path string,
+	current *app.Config,
+	urlBlacklistManager *blacklist.URLBlacklistManager,
+	sinkManager *sinkmanager.SinkManager,
+	websocketServer *websocketserver.WebsocketServer,
+) reload.Func {
+	return func() error {
+		conf, err := app.ParseConfig(path)
+		if err != nil {
+			return err
+		}
+
+		if err := reload.RestartRequired(current, conf, reloadable...); err != nil {
+			return err
+		}
+
+		urlBlacklistManager.SetBlacklistIPs(conf.BlackListIps)
+		sinkManager.SetSinkSettings(
+			conf.MessageDrainBufferSize,
+			time.Duration(conf.SinkInactivityTimeoutSeconds)*time.Second,
+			time.Duration(conf.SinkIOTimeoutSeconds)*time.Second,
+			time.Duration(conf.SinkDialTimeoutSeconds)*time.Second,
+		)
+		websocketServer.SetBufferSize(conf.MessageDrainBufferSize)
+		current = conf
+
+		return nil
+	}
+}
+
 func initializeMetrics(batchIntervalMilliseconds uint) *metricbatcher.MetricBatcher {
 	eventEmitter := dropsonde.AutowiredEmitter()
 	metricSender := metric_sender.NewMetricSender(eventEmitter)
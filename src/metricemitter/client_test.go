@@ -7,6 +7,7 @@ import (
 
 	v2 "plumbing/v2"
 
+	"golang.org/x/net/context"
 	"google.golang.org/grpc"
 
 	. "github.com/onsi/ginkgo"
@@ -218,6 +219,10 @@ func (s *SpyIngressServer) BatchSender(sender v2.Ingress_BatchSenderServer) erro
 	return nil
 }
 
+func (s *SpyIngressServer) Send(context.Context, *v2.EnvelopeBatch) (*v2.SendResponse, error) {
+	return &v2.SendResponse{}, nil
+}
+
 func (s *SpyIngressServer) stop() {
 	s.server.Stop()
 }
@@ -1,9 +1,11 @@
 package app
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"math/rand"
+	"sync"
 	"time"
 
 	"github.com/cloudfoundry/dropsonde/metric_sender"
@@ -23,10 +25,33 @@ type AppV1 struct {
 	config         *Config
 	creds          credentials.TransportCredentials
 	healthRegistry *health.Registry
+	v2Setter       ingress.V2Setter
+
+	mu            sync.Mutex
+	networkReader *ingress.NetworkReader
+	balancers     []*clientpool.Balancer
 }
 
-func NewV1App(c *Config, r *health.Registry, creds credentials.TransportCredentials) *AppV1 {
-	return &AppV1{config: c, healthRegistry: r, creds: creds}
+// AppV1Option is a type that will manipulate an AppV1
+type AppV1Option func(*AppV1)
+
+// WithV2Setter routes the envelopes received over UDP through the v2
+// egress pipeline by converting them to v2 and setting them on s, instead
+// of writing them to doppler over the v1 API.
+func WithV2Setter(s ingress.V2Setter) AppV1Option {
+	return func(a *AppV1) {
+		a.v2Setter = s
+	}
+}
+
+func NewV1App(c *Config, r *health.Registry, creds credentials.TransportCredentials, opts ...AppV1Option) *AppV1 {
+	a := &AppV1{config: c, healthRegistry: r, creds: creds}
+
+	for _, o := range opts {
+		o(a)
+	}
+
+	return a
 }
 
 func (a *AppV1) Start() {
@@ -38,30 +63,81 @@ func (a *AppV1) Start() {
 	batcher, eventWriter := a.initializeMetrics(statsStopChan)
 
 	log.Print("Startup: Setting up the Metron agent")
-	marshaller := a.initializeV1DopplerPool(batcher)
+	writer := a.envelopeWriter(batcher)
+	eventWriter.SetWriter(writer)
 
-	messageTagger := egress.NewTagger(
-		a.config.Deployment,
-		a.config.Job,
-		a.config.Index,
-		a.config.IP,
-		marshaller,
-	)
-	aggregator := egress.NewAggregator(messageTagger)
-	eventWriter.SetWriter(aggregator)
-
-	dropsondeUnmarshaller := ingress.NewUnMarshaller(aggregator, batcher)
+	dropsondeUnmarshaller := ingress.NewUnMarshaller(writer, batcher)
 	metronAddress := fmt.Sprintf("127.0.0.1:%d", a.config.IncomingUDPPort)
 	networkReader, err := ingress.New(metronAddress, "dropsondeAgentListener", dropsondeUnmarshaller)
 	if err != nil {
 		log.Panic(fmt.Errorf("Failed to listen on %s: %s", metronAddress, err))
 	}
 
+	a.mu.Lock()
+	a.networkReader = networkReader
+	a.mu.Unlock()
+
 	log.Printf("metron v1 API started on addr %s", metronAddress)
 	go networkReader.StartReading()
 	networkReader.StartWriting()
 }
 
+// Stop stops accepting envelopes on the UDP listener and writes the
+// envelopes already received to doppler until ctx is done. It returns the
+// number of envelopes flushed and the number abandoned.
+func (a *AppV1) Stop(ctx context.Context) (flushed, abandoned uint64) {
+	a.mu.Lock()
+	networkReader := a.networkReader
+	a.mu.Unlock()
+
+	if networkReader == nil {
+		return 0, 0
+	}
+
+	return networkReader.Shutdown(ctx)
+}
+
+// Reload applies the DopplerAddr of c.
+func (a *AppV1) Reload(c *Config) {
+	a.mu.Lock()
+	balancers := a.balancers
+	a.mu.Unlock()
+
+	if len(balancers) == 0 {
+		return
+	}
+	balancers[0].SetAddr(fmt.Sprintf("%s.%s", c.Zone, c.DopplerAddr))
+	balancers[1].SetAddr(c.DopplerAddr)
+}
+
+// envelopeWriter returns the writer that tags and forwards unmarshalled
+// envelopes, either to the v2 egress pipeline or to the v1 doppler pool.
+// Counter totals are accumulated by v2 egress in the former and by a v1
+// aggregator in the latter.
+func (a *AppV1) envelopeWriter(batcher *metricbatcher.MetricBatcher) egress.EnvelopeWriter {
+	if a.v2Setter != nil {
+		log.Print("Routing v1 envelopes through the v2 pipeline")
+		return egress.NewTagger(
+			a.config.Deployment,
+			a.config.Job,
+			a.config.Index,
+			a.config.IP,
+			ingress.NewV2Converter(a.v2Setter),
+		)
+	}
+
+	marshaller := a.initializeV1DopplerPool(batcher)
+
+	messageTagger := egress.NewTagger(
+		a.config.Deployment,
+		a.config.Job,
+		a.config.Index,
+		a.config.IP,
+		marshaller,
+	)
+	return egress.NewAggregator(messageTagger)
+}
+
 func (a *AppV1) initializeMetrics(stopChan chan struct{}) (*metricbatcher.MetricBatcher, *egress.EventWriter) {
 	eventWriter := egress.New("MetronAgent")
 	metricSender := metric_sender.NewMetricSender(eventWriter)
@@ -92,6 +168,10 @@ func (a *AppV1) setupGRPC() egress.BatchChainByteWriter {
 		clientpool.NewBalancer(a.config.DopplerAddr),
 	}
 
+	a.mu.Lock()
+	a.balancers = balancers
+	a.mu.Unlock()
+
 	fetcher := clientpool.NewPusherFetcher(
 		a.healthRegistry,
 		grpc.WithTransportCredentials(a.creds),
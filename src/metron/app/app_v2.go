@@ -1,30 +1,62 @@
 package app
 
 import (
+	"context"
 	"diodes"
 	"fmt"
 	"log"
 	"math/rand"
 	"metricemitter"
+	"os"
+	"plumbing"
+	"sync"
 	"time"
 
 	gendiodes "github.com/cloudfoundry/diodes"
 
+	"metron/internal/archive"
 	clientpool "metron/internal/clientpool/v2"
 	egress "metron/internal/egress/v2"
 	"metron/internal/health"
+	"metron/internal/httpingress"
 	ingress "metron/internal/ingress/v2"
+	"metron/internal/scraper"
+	"metron/internal/spill"
+	"metron/internal/statsd"
+	"metron/internal/syslog"
+	"metron/internal/tagfile"
 
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
 )
 
+// envelopeDiode buffers v2 envelopes between ingress and a Transponder.
+type envelopeDiode interface {
+	ingress.DataSetter
+	egress.WaitingNexter
+}
+
 type AppV2 struct {
 	config         *Config
 	healthRegistry *health.Registry
 	clientCreds    credentials.TransportCredentials
 	serverCreds    credentials.TransportCredentials
 	metricClient   metricemitter.MetricClient
+	envelopeBuffer envelopeDiode
+	poolBuffers    map[string]envelopeDiode
+	setter         ingress.DataSetter
+
+	mu               sync.Mutex
+	tx               *egress.Transponder
+	poolTxs          []*egress.Transponder
+	defaultBalancers []*clientpool.Balancer
+	allBalancers     []*clientpool.Balancer
+	servers          []*ingress.Server
+	listeners        []*statsd.Listener
+	syslog           *syslog.Server
+	httpIngress      *httpingress.Server
+	archive          *archive.Archive
+	tagFile          *tagfile.Watcher
 }
 
 func NewV2App(
@@ -34,67 +66,688 @@ func NewV2App(
 	serverCreds credentials.TransportCredentials,
 	metricClient metricemitter.MetricClient,
 ) *AppV2 {
-	return &AppV2{
+	a := &AppV2{
 		config:         c,
 		healthRegistry: r,
 		clientCreds:    clientCreds,
 		serverCreds:    serverCreds,
 		metricClient:   metricClient,
 	}
+	a.envelopeBuffer = a.newEnvelopeBuffer(nil)
+	a.setter = a.validate(a.rateLimit(a.route()))
+
+	return a
 }
 
-func (a *AppV2) Start() {
-	if a.serverCreds == nil {
-		log.Panic("Failed to load TLS server config")
+// newEnvelopeBuffer returns a diode whose dropped metric has the given
+// tags. When EnvelopeBuffer.Classes is set each type of envelope has its
+// own ring and dropped metric.
+func (a *AppV2) newEnvelopeBuffer(tags map[string]string) envelopeDiode {
+	droppedMetric := func(extra map[string]string) *metricemitter.CounterMetric {
+		droppedTags := map[string]string{"direction": "ingress"}
+		for k, v := range tags {
+			droppedTags[k] = v
+		}
+		for k, v := range extra {
+			droppedTags[k] = v
+		}
+
+		return a.metricClient.NewCounterMetric("dropped",
+			metricemitter.WithVersion(2, 0),
+			metricemitter.WithTags(droppedTags),
+		)
 	}
 
-	droppedMetric := a.metricClient.NewCounterMetric("dropped",
-		metricemitter.WithVersion(2, 0),
-		metricemitter.WithTags(map[string]string{"direction": "ingress"}),
-	)
+	conf := a.config.EnvelopeBuffer
+	if !conf.Classes {
+		m := droppedMetric(nil)
+		return diodes.NewWaitingManyToOneEnvelopeV2(10000, gendiodes.AlertFunc(func(missed int) {
+			// metric-documentation-v2: (loggregator.metron.dropped) Number of v2 envelopes
+			// dropped from the metron ingress diode
+			m.Increment(uint64(missed))
+
+			log.Printf("Dropped %d v2 envelopes", missed)
+		}))
+	}
+
+	metrics := make(map[diodes.Class]*metricemitter.CounterMetric)
+	for _, class := range diodes.Classes() {
+		metrics[class] = droppedMetric(map[string]string{"class": class.String()})
+	}
 
-	envelopeBuffer := diodes.NewManyToOneEnvelopeV2(10000, gendiodes.AlertFunc(func(missed int) {
+	return diodes.NewWaitingMultiClassEnvelopeV2(diodes.MultiClassConfig{
+		Log:     diodes.ClassConfig(conf.Log),
+		Counter: diodes.ClassConfig(conf.Counter),
+		Gauge:   diodes.ClassConfig(conf.Gauge),
+		Timer:   diodes.ClassConfig(conf.Timer),
+	}, diodes.ClassAlertFunc(func(class diodes.Class, missed int) {
 		// metric-documentation-v2: (loggregator.metron.dropped) Number of v2 envelopes
-		// dropped from the metron ingress diode
-		droppedMetric.Increment(uint64(missed))
+		// dropped from the metron ingress diode by envelope class
+		metrics[class].Increment(uint64(missed))
 
-		log.Printf("Dropped %d v2 envelopes", missed)
+		log.Printf("Dropped %d v2 %s envelopes", missed, class)
 	}))
+}
+
+// Setter returns the setter that every v2 ingress sets envelopes on.
+// Envelopes set on it are validated and rate limited, then routed to the
+// Transponder of their egress pool.
+func (a *AppV2) Setter() ingress.DataSetter {
+	return a.setter
+}
 
-	pool := a.initializePool()
+func (a *AppV2) Start() {
+	if a.serverCreds == nil {
+		log.Panic("Failed to load TLS server config")
+	}
+
+	opts := a.transponderOptions()
+
+	pool := a.initializePool(a.balancers(), a.clientCreds)
 	counterAggr := egress.NewCounterAggregator(pool)
 	tx := egress.NewTransponder(
-		envelopeBuffer,
-		counterAggr,
+		a.envelopeBuffer,
+		a.aggregate(counterAggr),
 		a.config.Tags,
-		100, time.Second,
+		int(a.config.BatchMaxEnvelopes),
+		time.Duration(a.config.BatchIntervalMilliseconds)*time.Millisecond,
 		a.metricClient,
+		append(a.spillOptions(), opts...)...,
 	)
 	go tx.Start()
+	a.setTransponder(tx)
+
+	a.startPools(opts)
 
 	metronAddress := fmt.Sprintf("127.0.0.1:%d", a.config.GRPC.Port)
 	log.Printf("metron v2 API started on addr %s", metronAddress)
-	rx := ingress.NewReceiver(envelopeBuffer, a.metricClient)
+	authorizer := a.authorizer()
+	var rxOpts []ingress.ReceiverOption
+	if authorizer != nil {
+		rxOpts = append(rxOpts, ingress.WithAuthorizer(authorizer))
+	}
+	rx := ingress.NewReceiver(a.setter, a.metricClient, rxOpts...)
+
+	a.startScraper(a.setter)
+	a.startStatsDListeners(a.setter)
+	a.startSyslogServer(a.setter)
+	a.startHTTPIngress(a.setter, authorizer)
+
+	if a.config.UnixSocket.Path != "" {
+		log.Printf("metron v2 API started on unix socket %s", a.config.UnixSocket.Path)
+		unixServer := ingress.NewUnixServer(ingress.UnixSocketConfig{
+			Path:        a.config.UnixSocket.Path,
+			FileMode:    os.FileMode(a.config.UnixSocket.FileMode),
+			AllowedUIDs: a.config.UnixSocket.AllowedUIDs,
+			AllowedGIDs: a.config.UnixSocket.AllowedGIDs,
+		}, rx)
+		a.addServer(unixServer)
+		go unixServer.Start()
+	}
+
 	ingressServer := ingress.NewServer(metronAddress, rx, grpc.Creds(a.serverCreds))
+	a.addServer(ingressServer)
 	ingressServer.Start()
 }
 
-func (a *AppV2) initializePool() *clientpool.ClientPool {
-	if a.clientCreds == nil {
-		log.Panic("Failed to load TLS client config")
+// Stop stops the ingress servers and flushes the envelopes already received
+// to doppler until ctx is done. It returns the number of envelopes flushed
+// and the number abandoned.
+func (a *AppV2) Stop(ctx context.Context) (flushed, abandoned uint64) {
+	a.mu.Lock()
+	servers := a.servers
+	listeners := a.listeners
+	syslogServer := a.syslog
+	httpServer := a.httpIngress
+	archiveWriter := a.archive
+	tagFile := a.tagFile
+	txs := a.poolTxs
+	if a.tx != nil {
+		txs = append([]*egress.Transponder{a.tx}, txs...)
+	}
+	balancers := a.allBalancers
+	a.mu.Unlock()
+
+	for _, s := range servers {
+		s.Stop()
+	}
+
+	for _, l := range listeners {
+		l.Stop()
+	}
+
+	if syslogServer != nil {
+		syslogServer.Stop()
+	}
+
+	if httpServer != nil {
+		httpServer.Stop()
+	}
+
+	var (
+		wg       sync.WaitGroup
+		countsMu sync.Mutex
+	)
+	for _, tx := range txs {
+		wg.Add(1)
+		go func(tx *egress.Transponder) {
+			defer wg.Done()
+			f, ab := tx.Stop(ctx)
+
+			countsMu.Lock()
+			defer countsMu.Unlock()
+			flushed += f
+			abandoned += ab
+		}(tx)
+	}
+	wg.Wait()
+
+	if archiveWriter != nil {
+		archiveWriter.Stop()
 	}
 
-	balancers := []*clientpool.Balancer{
-		clientpool.NewBalancer(fmt.Sprintf("%s.%s", a.config.Zone, a.config.DopplerAddr)),
-		clientpool.NewBalancer(a.config.DopplerAddr),
+	if tagFile != nil {
+		tagFile.Stop()
+	}
+
+	for _, b := range balancers {
+		b.Stop()
+	}
+
+	return flushed, abandoned
+}
+
+// Reload applies the Tags, batch interval and DopplerAddr of c. DopplerAddr
+// is ignored when DopplerTargets are configured, so the reloader requires a
+// restart to change it then.
+func (a *AppV2) Reload(c *Config) {
+	a.mu.Lock()
+	txs := a.poolTxs
+	if a.tx != nil {
+		txs = append([]*egress.Transponder{a.tx}, txs...)
+	}
+	balancers := a.defaultBalancers
+	a.mu.Unlock()
+
+	for _, tx := range txs {
+		tx.SetTags(c.Tags)
+		tx.SetBatchInterval(time.Duration(c.BatchIntervalMilliseconds) * time.Millisecond)
+	}
+
+	if len(balancers) == 0 {
+		return
+	}
+	balancers[0].SetAddr(fmt.Sprintf("%s.%s", c.Zone, c.DopplerAddr))
+	balancers[1].SetAddr(c.DopplerAddr)
+}
+
+// route returns a setter that sends envelopes matching the EgressRoutes to
+// the buffers of their pools and the rest to the default buffer.
+func (a *AppV2) route() ingress.DataSetter {
+	if len(a.config.EgressRoutes) == 0 {
+		return a.envelopeBuffer
+	}
+
+	a.poolBuffers = make(map[string]envelopeDiode)
+	pools := make(map[string]egress.Setter)
+	for _, p := range a.config.EgressPools {
+		b := a.newEnvelopeBuffer(map[string]string{"pool": p.Name})
+		a.poolBuffers[p.Name] = b
+		pools[p.Name] = b
+	}
+
+	routes := make([]egress.RouteConfig, 0, len(a.config.EgressRoutes))
+	for _, r := range a.config.EgressRoutes {
+		routes = append(routes, egress.RouteConfig(r))
+	}
+
+	router, err := egress.NewRouter(routes, pools, a.envelopeBuffer, a.metricClient)
+	if err != nil {
+		log.Panicf("Failed to configure egress routes: %s", err)
+	}
+
+	return router
+}
+
+// startPools starts a Transponder for each egress pool so that a slow pool
+// does not hold up the others.
+func (a *AppV2) startPools(opts []egress.TransponderOption) {
+	cache := clientpool.WithCache(
+		time.Duration(a.config.DopplerResolveIntervalSeconds)*time.Second,
+		time.Duration(a.config.DopplerResolveTTLSeconds)*time.Second,
+	)
+
+	for _, p := range a.config.EgressPools {
+		buffer, ok := a.poolBuffers[p.Name]
+		if !ok {
+			continue
+		}
+
+		pool := a.initializePool(targetBalancers(p.DopplerTargets, cache), a.poolCredentials(p))
+		tx := egress.NewTransponder(
+			buffer,
+			a.aggregate(egress.NewCounterAggregator(pool)),
+			a.config.Tags,
+			int(a.config.BatchMaxEnvelopes),
+			time.Duration(a.config.BatchIntervalMilliseconds)*time.Millisecond,
+			a.metricClient,
+			append([]egress.TransponderOption{
+				egress.WithMetricTags(map[string]string{"pool": p.Name}),
+			}, opts...)...,
+		)
+		go tx.Start()
+		log.Printf("v2 egress pool %s started", p.Name)
+
+		a.mu.Lock()
+		a.poolTxs = append(a.poolTxs, tx)
+		a.mu.Unlock()
+	}
+}
+
+func (a *AppV2) poolCredentials(p EgressPool) credentials.TransportCredentials {
+	if p.CAFile == "" && p.CertFile == "" && p.KeyFile == "" && p.ServerName == "" {
+		return a.clientCreds
+	}
+
+	serverName := p.ServerName
+	if serverName == "" {
+		serverName = "doppler"
+	}
+
+	creds, err := plumbing.NewCredentials(
+		orDefault(p.CertFile, a.config.GRPC.CertFile),
+		orDefault(p.KeyFile, a.config.GRPC.KeyFile),
+		orDefault(p.CAFile, a.config.GRPC.CAFile),
+		serverName,
+	)
+	if err != nil {
+		log.Panicf("Failed to load TLS config for egress pool %s: %s", p.Name, err)
+	}
+
+	return creds
+}
+
+func orDefault(s, def string) string {
+	if s == "" {
+		return def
+	}
+
+	return s
+}
+
+func (a *AppV2) setTransponder(tx *egress.Transponder) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.tx = tx
+}
+
+func (a *AppV2) addServer(s *ingress.Server) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.servers = append(a.servers, s)
+}
+
+func (a *AppV2) startScraper(setter scraper.DataSetter) {
+	conf := a.config.PrometheusScrape
+	if len(conf.Targets) == 0 {
+		return
+	}
+
+	targets := make([]scraper.Target, 0, len(conf.Targets))
+	for _, t := range conf.Targets {
+		targets = append(targets, scraper.Target(t))
+	}
+
+	log.Printf("scraping %d prometheus endpoints", len(targets))
+	s := scraper.New(
+		targets,
+		setter,
+		time.Duration(conf.IntervalSeconds)*time.Second,
+		a.metricClient,
+	)
+	go s.Start()
+}
+
+func (a *AppV2) startStatsDListeners(setter statsd.DataSetter) {
+	for _, conf := range a.config.StatsDListeners {
+		l, err := statsd.NewListener(conf.Addr, conf.SourceID, setter, a.metricClient)
+		if err != nil {
+			log.Panicf("Failed to listen for statsd on %s: %s", conf.Addr, err)
+		}
+		log.Printf("statsd listener for %s started on addr %s", conf.SourceID, l.Addr())
+
+		a.mu.Lock()
+		a.listeners = append(a.listeners, l)
+		a.mu.Unlock()
+
+		go l.Start()
+	}
+}
+
+func (a *AppV2) startSyslogServer(setter syslog.DataSetter) {
+	conf := a.config.Syslog
+	if conf.TCPAddr == "" && conf.UDPAddr == "" {
+		return
 	}
 
+	s, err := syslog.NewServer(
+		conf.TCPAddr,
+		conf.UDPAddr,
+		setter,
+		a.metricClient,
+		syslog.WithDefaultSourceID(conf.DefaultSourceID),
+	)
+	if err != nil {
+		log.Panicf("Failed to listen for syslog: %s", err)
+	}
+	log.Printf("syslog ingress started on tcp %q and udp %q", conf.TCPAddr, conf.UDPAddr)
+
+	a.mu.Lock()
+	a.syslog = s
+	a.mu.Unlock()
+
+	go s.Start()
+}
+
+func (a *AppV2) startHTTPIngress(setter httpingress.DataSetter, authorizer *ingress.Authorizer) {
+	conf := a.config.HTTPIngress
+	if conf.Addr == "" {
+		return
+	}
+
+	opts := []httpingress.ServerOption{
+		httpingress.WithMaxBodyBytes(conf.MaxBodyBytes),
+	}
+	if conf.CertFile != "" {
+		tlsConfig, err := plumbing.NewMutualTLSConfig(
+			conf.CertFile,
+			conf.KeyFile,
+			conf.CAFile,
+			"metron",
+		)
+		if err != nil {
+			log.Panicf("Failed to load HTTP ingress TLS config: %s", err)
+		}
+		opts = append(opts, httpingress.WithTLSConfig(tlsConfig))
+	}
+	if authorizer != nil {
+		opts = append(opts, httpingress.WithAuthorizer(authorizer))
+	}
+
+	s, err := httpingress.NewServer(conf.Addr, setter, a.metricClient, opts...)
+	if err != nil {
+		log.Panicf("Failed to listen for HTTP ingress: %s", err)
+	}
+	log.Printf("http ingress started on %s", s.Addr())
+
+	a.mu.Lock()
+	a.httpIngress = s
+	a.mu.Unlock()
+
+	go s.Start()
+}
+
+func (a *AppV2) validate(setter ingress.DataSetter) ingress.DataSetter {
+	conf := a.config.IngressValidation
+
+	return ingress.NewValidator(setter, ingress.ValidationLimits{
+		RequireFields:    conf.RequireFields,
+		MaxPayloadBytes:  conf.MaxPayloadBytes,
+		MaxTags:          conf.MaxTags,
+		MaxTagKeyBytes:   conf.MaxTagKeyBytes,
+		MaxTagValueBytes: conf.MaxTagValueBytes,
+		MaxFutureSkew:    time.Duration(conf.MaxFutureSkewSeconds) * time.Second,
+	}, a.metricClient)
+}
+
+func (a *AppV2) rateLimit(setter ingress.DataSetter) ingress.DataSetter {
+	limits := a.config.IngressRateLimits
+	if limits.Default.EnvelopesPerSecond <= 0 && len(limits.SourceIDs) == 0 {
+		return setter
+	}
+
+	overrides := make(map[string]ingress.RateLimit, len(limits.SourceIDs))
+	for sourceID, l := range limits.SourceIDs {
+		overrides[sourceID] = ingress.RateLimit(l)
+	}
+
+	return ingress.NewRateLimiter(
+		setter,
+		ingress.RateLimit(limits.Default),
+		overrides,
+		a.metricClient,
+	)
+}
+
+// authorizer returns the Authorizer for the configured sender rules, or nil
+// when every sender may use every source_id.
+func (a *AppV2) authorizer() *ingress.Authorizer {
+	conf := a.config.SenderAuthorization
+	if len(conf.Rules) == 0 {
+		return nil
+	}
+
+	mode, err := ingress.ParseViolationMode(conf.Mode)
+	if err != nil {
+		log.Panicf("Failed to configure sender authorization: %s", err)
+	}
+
+	rules := make([]ingress.SenderRule, 0, len(conf.Rules))
+	for _, r := range conf.Rules {
+		rules = append(rules, ingress.SenderRule(r))
+	}
+
+	authorizer, err := ingress.NewAuthorizer(rules, mode, a.metricClient)
+	if err != nil {
+		log.Panicf("Failed to configure sender authorization: %s", err)
+	}
+
+	return authorizer
+}
+
+func (a *AppV2) aggregate(w egress.Writer) egress.Writer {
+	conf := a.config.Aggregation
+	if conf.IntervalMilliseconds == 0 {
+		return w
+	}
+
+	var opts []egress.AggregatorOption
+	if conf.DeduplicateGauges {
+		opts = append(opts, egress.WithGaugeDeduplication())
+	}
+	if len(conf.TimerSummarySourceIDs) > 0 {
+		opts = append(opts, egress.WithTimerSummaries(conf.TimerSummarySourceIDs...))
+	}
+
+	return egress.NewAggregator(
+		w,
+		time.Duration(conf.IntervalMilliseconds)*time.Millisecond,
+		a.metricClient,
+		opts...,
+	)
+}
+
+func (a *AppV2) transponderOptions() []egress.TransponderOption {
+	opts := []egress.TransponderOption{
+		egress.WithMaxBatchBytes(int(a.config.BatchMaxBytes)),
+	}
+
+	if len(a.config.EnvelopeRules) > 0 {
+		rules := make([]egress.RuleConfig, 0, len(a.config.EnvelopeRules))
+		for _, r := range a.config.EnvelopeRules {
+			rules = append(rules, egress.RuleConfig(r))
+		}
+
+		pipeline, err := egress.NewPipeline(rules, a.metricClient)
+		if err != nil {
+			log.Panicf("Failed to configure envelope rules: %s", err)
+		}
+		opts = append(opts, egress.WithPipeline(pipeline))
+	}
+
+	if a.config.TagFile != "" {
+		tags, err := tagfile.New(a.config.TagFile, a.metricClient)
+		if err != nil {
+			log.Panicf("Failed to load tag file %s: %s", a.config.TagFile, err)
+		}
+		opts = append(opts, egress.WithTagSource(tags))
+
+		a.mu.Lock()
+		a.tagFile = tags
+		a.mu.Unlock()
+	}
+
+	if a.config.Archive.Dir != "" {
+		opts = append(opts, egress.WithTee(a.startArchive()))
+	}
+
+	return opts
+}
+
+// spillOptions returns the options of the default Transponder only, as
+// batches for the egress pools are not spilled.
+func (a *AppV2) spillOptions() []egress.TransponderOption {
+	if a.config.Spill.Dir == "" {
+		return nil
+	}
+
+	wal, err := spill.New(
+		a.config.Spill.Dir,
+		a.config.Spill.MaxBytes,
+		time.Duration(a.config.Spill.MaxAgeSeconds)*time.Second,
+		a.metricClient,
+	)
+	if err != nil {
+		log.Panicf("Failed to create spill buffer in %s: %s", a.config.Spill.Dir, err)
+	}
+
+	return []egress.TransponderOption{egress.WithSpillBuffer(wal)}
+}
+
+// startArchive starts writing the envelopes sent to doppler to disk.
+func (a *AppV2) startArchive() *archive.Archive {
+	conf := a.config.Archive
+
+	opts := []archive.Option{
+		archive.WithMaxFileBytes(conf.MaxFileBytes),
+		archive.WithMaxFileAge(time.Duration(conf.MaxFileAgeSeconds) * time.Second),
+		archive.WithMaxTotalBytes(conf.MaxTotalBytes),
+	}
+	if len(conf.SourceIDs) > 0 {
+		opts = append(opts, archive.WithSourceIDs(conf.SourceIDs...))
+	}
+	if len(conf.EnvelopeTypes) > 0 {
+		opts = append(opts, archive.WithEnvelopeTypes(conf.EnvelopeTypes...))
+	}
+
+	w, err := archive.New(conf.Dir, a.metricClient, opts...)
+	if err != nil {
+		log.Panicf("Failed to create archive in %s: %s", conf.Dir, err)
+	}
+	log.Printf("archiving v2 envelopes to %s", conf.Dir)
+
+	a.mu.Lock()
+	a.archive = w
+	a.mu.Unlock()
+
+	go w.Start()
+
+	return w
+}
+
+// balancers returns a balancer per configured doppler target. Without
+// targets, dopplers in the same zone are preferred over DopplerAddr.
+func (a *AppV2) balancers() []*clientpool.Balancer {
+	cache := clientpool.WithCache(
+		time.Duration(a.config.DopplerResolveIntervalSeconds)*time.Second,
+		time.Duration(a.config.DopplerResolveTTLSeconds)*time.Second,
+	)
+
+	if len(a.config.DopplerTargets) == 0 {
+		balancers := []*clientpool.Balancer{
+			clientpool.NewBalancer(
+				fmt.Sprintf("%s.%s", a.config.Zone, a.config.DopplerAddr),
+				clientpool.WithPriority(0),
+				cache,
+			),
+			clientpool.NewBalancer(
+				a.config.DopplerAddr,
+				clientpool.WithPriority(1),
+				cache,
+			),
+		}
+
+		a.mu.Lock()
+		a.defaultBalancers = balancers
+		a.mu.Unlock()
+
+		return balancers
+	}
+
+	return targetBalancers(a.config.DopplerTargets, cache)
+}
+
+func targetBalancers(targets []DopplerTarget, cache clientpool.BalancerOption) []*clientpool.Balancer {
+	var balancers []*clientpool.Balancer
+	for _, t := range targets {
+		if t.SRV {
+			balancers = append(balancers, clientpool.NewSRVBalancer(
+				t.Addr,
+				clientpool.WithPriority(t.Priority),
+				cache,
+			))
+			continue
+		}
+
+		balancers = append(balancers, clientpool.NewBalancer(
+			t.Addr,
+			clientpool.WithPriority(t.Priority),
+			cache,
+		))
+	}
+
+	return balancers
+}
+
+func (a *AppV2) initializePool(
+	balancers []*clientpool.Balancer,
+	creds credentials.TransportCredentials,
+) *clientpool.ClientPool {
+	if creds == nil {
+		log.Panic("Failed to load TLS client config")
+	}
+
+	a.mu.Lock()
+	a.allBalancers = append(a.allBalancers, balancers...)
+	a.mu.Unlock()
+
+	ejection := a.config.DopplerOutlierEjection
+	tracker := clientpool.NewHealthTracker(
+		a.healthRegistry,
+		clientpool.WithMaxErrorRate(ejection.MaxErrorRate),
+		clientpool.WithLatencyOutlierRatio(ejection.LatencyRatio),
+		clientpool.WithMinSamples(ejection.MinSamples),
+		clientpool.WithEjectionDuration(time.Duration(ejection.DurationSeconds)*time.Second),
+	)
+
 	fetcher := clientpool.NewSenderFetcher(
 		a.healthRegistry,
-		grpc.WithTransportCredentials(a.clientCreds),
+		grpc.WithTransportCredentials(creds),
 	)
+	if a.config.DopplerAcks {
+		fetcher = clientpool.NewAckingSenderFetcher(
+			a.healthRegistry,
+			tracker,
+			a.metricClient,
+			grpc.WithTransportCredentials(creds),
+		)
+	}
 
-	connector := clientpool.MakeGRPCConnector(fetcher, balancers)
+	connector := clientpool.MakeGRPCConnector(
+		fetcher,
+		balancers,
+		clientpool.WithHealthTracker(tracker),
+	)
 
 	var connManagers []clientpool.Conn
 	for i := 0; i < 5; i++ {
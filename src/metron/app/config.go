@@ -14,6 +14,217 @@ type GRPC struct {
 	KeyFile  string
 }
 
+// UnixSocket configures an additional listener for the v2 Ingress API on a
+// Unix domain socket. It is disabled when Path is empty.
+type UnixSocket struct {
+	Path        string
+	FileMode    uint32
+	AllowedUIDs []uint32
+	AllowedGIDs []uint32
+}
+
+// BufferClass is the number of v2 envelopes of one type buffered for
+// egress and the number read from it in a row while other types wait.
+type BufferClass struct {
+	Size   int
+	Weight int
+}
+
+// EnvelopeBuffer configures the buffer between v2 ingress and egress. Every
+// type of envelope shares a single ring unless Classes is set, in which case
+// logs, counters, gauges and timers each have their own so that a burst of
+// one type does not overwrite the others.
+type EnvelopeBuffer struct {
+	Classes bool
+	Log     BufferClass
+	Counter BufferClass
+	Gauge   BufferClass
+	Timer   BufferClass
+}
+
+// RateLimit is a token bucket limit on the number of envelopes a single
+// source_id may send. A zero EnvelopesPerSecond means unlimited.
+type RateLimit struct {
+	EnvelopesPerSecond float64
+	Burst              uint
+}
+
+// IngressRateLimits configures per source_id rate limiting of v2 ingress.
+// Default applies to every source_id without an entry in SourceIDs.
+type IngressRateLimits struct {
+	Default   RateLimit
+	SourceIDs map[string]RateLimit
+}
+
+// IngressValidation bounds the size of v2 envelopes received. A zero limit
+// means unlimited. Log payloads longer than MaxPayloadBytes are truncated
+// and envelopes beyond the other limits are dropped. RequireFields drops
+// envelopes without a source_id or the required fields of their message.
+type IngressValidation struct {
+	RequireFields        bool
+	MaxPayloadBytes      int
+	MaxTags              int
+	MaxTagKeyBytes       int
+	MaxTagValueBytes     int
+	MaxFutureSkewSeconds uint
+}
+
+// SenderRule allows the v2 ingress senders whose client certificate
+// matches Subject and SAN, or whose Unix domain socket peer credentials
+// match UIDs and GIDs, to use the source_ids matching SourceIDs. All
+// patterns are shell patterns.
+type SenderRule struct {
+	Subject   string
+	SAN       string
+	UIDs      []uint32
+	GIDs      []uint32
+	SourceIDs []string
+}
+
+// SenderAuthorization restricts the source_ids each v2 ingress sender may
+// use, including clients of the HTTP ingress. It is disabled when there are
+// no Rules. Mode is what happens to envelopes with other source_ids:
+// reject, rewrite or tag.
+type SenderAuthorization struct {
+	Mode  string
+	Rules []SenderRule
+}
+
+// HTTPIngress configures the HTTP endpoint that accepts v2 envelopes as
+// JSON. It is disabled when Addr is empty. Clients must present a
+// certificate signed by CAFile when CertFile is set.
+type HTTPIngress struct {
+	Addr         string
+	MaxBodyBytes int64
+	CAFile       string
+	CertFile     string
+	KeyFile      string
+}
+
+// EnvelopeRule is a filter or rewrite rule applied to every v2 envelope
+// before egress. Rules are applied in the order they are configured.
+type EnvelopeRule struct {
+	Name string
+
+	SourceID     string
+	EnvelopeType string
+	TagName      string
+	TagPattern   string
+
+	Action      string
+	Tag         string
+	Value       string
+	NewTag      string
+	Pattern     string
+	Replacement string
+}
+
+// Aggregation configures the reduction of gauge and timer envelopes before
+// v2 egress. It is disabled when IntervalMilliseconds is zero. Timers are
+// summarized for the source_ids in TimerSummarySourceIDs, where "*" matches
+// every source_id.
+type Aggregation struct {
+	IntervalMilliseconds  uint
+	DeduplicateGauges     bool
+	TimerSummarySourceIDs []string
+}
+
+// StatsDListener is a UDP address on which StatsD metrics are received.
+// Every metric received is given SourceID.
+type StatsDListener struct {
+	Addr     string
+	SourceID string
+}
+
+// Syslog configures the listeners for RFC5424 and RFC3164 syslog messages.
+// A transport is disabled when its address is empty. Messages without an
+// APP-NAME are given DefaultSourceID.
+type Syslog struct {
+	TCPAddr         string
+	UDPAddr         string
+	DefaultSourceID string
+}
+
+// PrometheusTarget is a Prometheus text format endpoint scraped into v2
+// envelopes with the given SourceID and Tags.
+type PrometheusTarget struct {
+	URL      string
+	SourceID string
+	Tags     map[string]string
+}
+
+// PrometheusScrape configures the scraping of local Prometheus endpoints.
+// It is disabled when there are no Targets.
+type PrometheusScrape struct {
+	IntervalSeconds uint
+	Targets         []PrometheusTarget
+}
+
+// Spill configures the on disk buffer used to hold v2 batches while no
+// Doppler can be written to. It is disabled when Dir is empty.
+type Spill struct {
+	Dir           string
+	MaxBytes      int64
+	MaxAgeSeconds uint
+}
+
+// Archive configures a local copy of v2 envelopes in rotating newline
+// delimited JSON files. It is disabled when Dir is empty. When SourceIDs or
+// EnvelopeTypes are set only matching envelopes are archived.
+type Archive struct {
+	Dir               string
+	MaxFileBytes      int64
+	MaxFileAgeSeconds uint
+	MaxTotalBytes     int64
+	SourceIDs         []string
+	EnvelopeTypes     []string
+}
+
+// DopplerTarget is an address from which Dopplers are discovered. Targets
+// with a lower Priority are preferred. When SRV is set Addr is the name of a
+// DNS SRV record, otherwise it is a host:port resolved via A records.
+type DopplerTarget struct {
+	Addr     string
+	SRV      bool
+	Priority uint
+}
+
+// EgressPool is a named set of Dopplers that v2 envelopes can be routed to.
+// CAFile, CertFile and KeyFile default to those of GRPC and ServerName to
+// "doppler".
+type EgressPool struct {
+	Name           string
+	DopplerTargets []DopplerTarget
+	CAFile         string
+	CertFile       string
+	KeyFile        string
+	ServerName     string
+}
+
+// EgressRoute sends the v2 envelopes matching it to the named pool instead
+// of the default Dopplers, or to both when Copy is set. Envelopes are sent
+// to the pool of the first route they match.
+type EgressRoute struct {
+	Pool string
+
+	SourceID     string
+	EnvelopeType string
+	TagName      string
+	TagPattern   string
+
+	Copy bool
+}
+
+// OutlierEjection configures when a Doppler is considered unhealthy and
+// avoided by the v2 client pool. MaxErrorRate is between 0 and 1 and
+// LatencyRatio is relative to the average latency of the other Dopplers.
+type OutlierEjection struct {
+	MaxErrorRate    float64
+	LatencyRatio    float64
+	MinSamples      int64
+	DurationSeconds uint
+}
+
 type Config struct {
 	Deployment string
 	Zone       string
@@ -21,19 +232,69 @@ type Config struct {
 	Index      string
 	IP         string
 
-	Tags map[string]string
+	Tags          map[string]string
+	EnvelopeRules []EnvelopeRule
+	Aggregation   Aggregation
+
+	// TagFile is a JSON or YAML file of global and per source_id tags that
+	// is reloaded whenever it changes.
+	TagFile string
 
 	DisableUDP         bool
 	IncomingUDPPort    int
 	HealthEndpointPort uint
 
-	GRPC GRPC
+	// RouteUDPThroughV2 converts envelopes received over UDP to v2 and
+	// sends them through the v2 egress pipeline instead of the v1 API.
+	RouteUDPThroughV2 bool
+
+	GRPC       GRPC
+	UnixSocket UnixSocket
+
+	EnvelopeBuffer      EnvelopeBuffer
+	IngressValidation   IngressValidation
+	IngressRateLimits   IngressRateLimits
+	SenderAuthorization SenderAuthorization
+	PrometheusScrape    PrometheusScrape
+	StatsDListeners     []StatsDListener
+	Syslog              Syslog
+	HTTPIngress         HTTPIngress
+
+	DopplerAddr            string
+	DopplerOutlierEjection OutlierEjection
+
+	// DopplerAcks opens AckedBatchSender streams to Dopplers so that the
+	// envelopes they shed are reported back. Every Doppler must support it.
+	DopplerAcks bool
 
-	DopplerAddr string
+	// DopplerTargets replaces DopplerAddr for the v2 client pool when set.
+	DopplerTargets                []DopplerTarget
+	DopplerResolveIntervalSeconds uint
+	DopplerResolveTTLSeconds      uint
+
+	// EgressPools and EgressRoutes send some v2 envelopes to Dopplers other
+	// than the default ones.
+	EgressPools  []EgressPool
+	EgressRoutes []EgressRoute
+
+	Spill   Spill
+	Archive Archive
+
+	// BatchIntervalMilliseconds is the longest time v2 envelopes wait to be
+	// written to doppler when their batch is not full. A batch is full when
+	// it holds BatchMaxEnvelopes or when the next envelope would take its
+	// encoded size over BatchMaxBytes. A BatchMaxBytes of 0 means unlimited.
+	BatchIntervalMilliseconds uint
+	BatchMaxEnvelopes         uint
+	BatchMaxBytes             uint
 
 	MetricBatchIntervalMilliseconds  uint
 	RuntimeStatsIntervalMilliseconds uint
 
+	// ShutdownTimeoutSeconds bounds how long buffered envelopes are flushed
+	// for after receiving SIGTERM.
+	ShutdownTimeoutSeconds uint
+
 	PPROFPort uint32
 }
 
@@ -49,8 +310,50 @@ func ParseConfig(configFile string) (*Config, error) {
 
 func Parse(reader io.Reader) (*Config, error) {
 	config := &Config{
+		BatchIntervalMilliseconds:        1000,
+		BatchMaxEnvelopes:                100,
+		BatchMaxBytes:                    1024 * 1024,
 		MetricBatchIntervalMilliseconds:  5000,
 		RuntimeStatsIntervalMilliseconds: 15000,
+		ShutdownTimeoutSeconds:           10,
+		UnixSocket: UnixSocket{
+			FileMode: 0660,
+		},
+		DopplerResolveIntervalSeconds: 10,
+		DopplerResolveTTLSeconds:      60,
+		DopplerOutlierEjection: OutlierEjection{
+			MaxErrorRate:    0.5,
+			LatencyRatio:    3,
+			MinSamples:      10,
+			DurationSeconds: 30,
+		},
+		PrometheusScrape: PrometheusScrape{
+			IntervalSeconds: 15,
+		},
+		Syslog: Syslog{
+			DefaultSourceID: "syslog",
+		},
+		HTTPIngress: HTTPIngress{
+			MaxBodyBytes: 1024 * 1024,
+		},
+		Spill: Spill{
+			MaxBytes:      100 * 1024 * 1024,
+			MaxAgeSeconds: 3600,
+		},
+		Archive: Archive{
+			MaxFileBytes:      64 * 1024 * 1024,
+			MaxFileAgeSeconds: 3600,
+			MaxTotalBytes:     1024 * 1024 * 1024,
+		},
+		EnvelopeBuffer: EnvelopeBuffer{
+			Log:     BufferClass{Size: 10000, Weight: 4},
+			Counter: BufferClass{Size: 5000, Weight: 1},
+			Gauge:   BufferClass{Size: 5000, Weight: 1},
+			Timer:   BufferClass{Size: 5000, Weight: 1},
+		},
+		SenderAuthorization: SenderAuthorization{
+			Mode: "reject",
+		},
 	}
 	err := json.NewDecoder(reader).Decode(config)
 	if err != nil {
@@ -61,5 +364,39 @@ func Parse(reader io.Reader) (*Config, error) {
 		return nil, fmt.Errorf("DopplerAddr is required")
 	}
 
+	if config.BatchMaxEnvelopes == 0 {
+		return nil, fmt.Errorf("BatchMaxEnvelopes must be greater than 0")
+	}
+
+	if err := validateEgressPools(config); err != nil {
+		return nil, err
+	}
+
 	return config, nil
 }
+
+func validateEgressPools(c *Config) error {
+	pools := make(map[string]bool, len(c.EgressPools))
+	for i, p := range c.EgressPools {
+		if p.Name == "" {
+			return fmt.Errorf("EgressPools[%d] requires a Name", i)
+		}
+
+		if pools[p.Name] {
+			return fmt.Errorf("EgressPools[%d] has duplicate Name %q", i, p.Name)
+		}
+		pools[p.Name] = true
+
+		if len(p.DopplerTargets) == 0 {
+			return fmt.Errorf("EgressPools[%d] requires DopplerTargets", i)
+		}
+	}
+
+	for i, r := range c.EgressRoutes {
+		if !pools[r.Pool] {
+			return fmt.Errorf("EgressRoutes[%d] has unknown Pool %q", i, r.Pool)
+		}
+	}
+
+	return nil
+}
@@ -152,6 +152,14 @@ type mockDopplerIngressServerV2 struct {
 	BatchSenderOutput struct {
 		Ret0 chan error
 	}
+
+	AckedBatchSenderCalled chan bool
+	AckedBatchSenderInput  struct {
+		Arg0 chan v2.DopplerIngress_AckedBatchSenderServer
+	}
+	AckedBatchSenderOutput struct {
+		Ret0 chan error
+	}
 }
 
 func newMockDopplerIngressServerV2() *mockDopplerIngressServerV2 {
@@ -163,6 +171,10 @@ func newMockDopplerIngressServerV2() *mockDopplerIngressServerV2 {
 	m.BatchSenderCalled = make(chan bool, 100)
 	m.BatchSenderInput.Arg0 = make(chan v2.DopplerIngress_BatchSenderServer, 100)
 	m.BatchSenderOutput.Ret0 = make(chan error, 100)
+
+	m.AckedBatchSenderCalled = make(chan bool, 100)
+	m.AckedBatchSenderInput.Arg0 = make(chan v2.DopplerIngress_AckedBatchSenderServer, 100)
+	m.AckedBatchSenderOutput.Ret0 = make(chan error, 100)
 	return m
 }
 func (m *mockDopplerIngressServerV2) Sender(arg0 v2.DopplerIngress_SenderServer) error {
@@ -175,6 +187,11 @@ func (m *mockDopplerIngressServerV2) BatchSender(arg0 v2.DopplerIngress_BatchSen
 	m.BatchSenderInput.Arg0 <- arg0
 	return <-m.BatchSenderOutput.Ret0
 }
+func (m *mockDopplerIngressServerV2) AckedBatchSender(arg0 v2.DopplerIngress_AckedBatchSenderServer) error {
+	m.AckedBatchSenderCalled <- true
+	m.AckedBatchSenderInput.Arg0 <- arg0
+	return <-m.AckedBatchSenderOutput.Ret0
+}
 
 type mockDopplerIngestor_PusherServerV2 struct {
 	SendAndCloseCalled chan bool
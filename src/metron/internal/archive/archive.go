@@ -0,0 +1,432 @@
+// Package archive keeps a local copy of v2 envelopes in rotating newline
+// delimited JSON files.
+package archive
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"log"
+	"metricemitter"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/jsonpb"
+
+	plumbing "plumbing/v2"
+)
+
+const (
+	filePrefix     = "envelopes-"
+	fileSuffix     = ".ndjson"
+	gzipSuffix     = ".gz"
+	fileTimeFormat = "20060102T150405.000000000Z"
+)
+
+type segment struct {
+	path string
+	size int64
+}
+
+// Archive is an egress Writer that appends the JSON form of each envelope
+// it is given to a file in a directory, one envelope per line. Files are
+// rotated when they reach a maximum size or age and closed files are
+// gzipped. When the files in the directory exceed the disk budget the
+// oldest are removed.
+//
+// Write only encodes envelopes and never waits on the disk. Encoded batches
+// are written by Start and are dropped when it falls behind.
+type Archive struct {
+	dir           string
+	maxFileBytes  int64
+	maxFileAge    time.Duration
+	maxTotalBytes int64
+	sourceIDs     []string
+	types         map[string]bool
+	marshaler     jsonpb.Marshaler
+
+	batches chan []byte
+	done    chan struct{}
+	stopped chan struct{}
+
+	segments   []*segment
+	totalBytes int64
+	active     *os.File
+	activeAt   time.Time
+
+	archivedMetric *metricemitter.CounterMetric
+	droppedMetric  *metricemitter.CounterMetric
+}
+
+// Option is a type that will manipulate an Archive
+type Option func(*Archive)
+
+// WithMaxFileBytes sets the size after which a new file is started. It
+// defaults to 64MB.
+func WithMaxFileBytes(n int64) Option {
+	return func(a *Archive) {
+		a.maxFileBytes = n
+	}
+}
+
+// WithMaxFileAge sets the age after which a new file is started. Files are
+// not rotated by age by default.
+func WithMaxFileAge(d time.Duration) Option {
+	return func(a *Archive) {
+		a.maxFileAge = d
+	}
+}
+
+// WithMaxTotalBytes sets the disk budget of the directory. It is unlimited
+// by default.
+func WithMaxTotalBytes(n int64) Option {
+	return func(a *Archive) {
+		a.maxTotalBytes = n
+	}
+}
+
+// WithSourceIDs archives only the envelopes whose source_id matches one of
+// the given shell patterns.
+func WithSourceIDs(patterns ...string) Option {
+	return func(a *Archive) {
+		a.sourceIDs = patterns
+	}
+}
+
+// WithEnvelopeTypes archives only envelopes of the given types: log,
+// counter, gauge or timer.
+func WithEnvelopeTypes(types ...string) Option {
+	return func(a *Archive) {
+		a.types = make(map[string]bool, len(types))
+		for _, t := range types {
+			a.types[t] = true
+		}
+	}
+}
+
+// WithBufferSize sets the number of batches that may wait to be written to
+// disk before batches are dropped. It defaults to 1000.
+func WithBufferSize(n int) Option {
+	return func(a *Archive) {
+		a.batches = make(chan []byte, n)
+	}
+}
+
+// New returns an Archive that writes to dir. Files left uncompressed by a
+// previous run are gzipped.
+func New(
+	dir string,
+	metricClient metricemitter.MetricClient,
+	opts ...Option,
+) (*Archive, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	a := &Archive{
+		dir:          dir,
+		maxFileBytes: 64 * 1024 * 1024,
+		marshaler:    jsonpb.Marshaler{OrigName: true},
+		batches:      make(chan []byte, 1000),
+		done:         make(chan struct{}),
+		stopped:      make(chan struct{}),
+		archivedMetric: metricClient.NewCounterMetric("archived",
+			metricemitter.WithVersion(2, 0),
+		),
+		droppedMetric: metricClient.NewCounterMetric("dropped",
+			metricemitter.WithVersion(2, 0),
+			metricemitter.WithTags(map[string]string{"direction": "archive"}),
+		),
+	}
+
+	for _, o := range opts {
+		o(a)
+	}
+
+	// Keep several files within the budget so that removing the oldest one
+	// does not throw away most of the archive.
+	if a.maxTotalBytes > 0 && a.maxFileBytes > a.maxTotalBytes/4 {
+		a.maxFileBytes = a.maxTotalBytes/4 + 1
+	}
+
+	if err := a.loadSegments(); err != nil {
+		return nil, err
+	}
+
+	return a, nil
+}
+
+// Write encodes the envelopes that pass the filters and queues them to be
+// written to disk. It never blocks and always returns nil.
+func (a *Archive) Write(batch []*plumbing.Envelope) error {
+	var (
+		buf bytes.Buffer
+		n   int
+	)
+	for _, e := range batch {
+		if !a.matches(e) {
+			continue
+		}
+
+		if err := a.marshaler.Marshal(&buf, e); err != nil {
+			log.Printf("failed to encode envelope for archive: %s", err)
+			continue
+		}
+		buf.WriteByte('\n')
+		n++
+	}
+
+	if n == 0 {
+		return nil
+	}
+
+	select {
+	case a.batches <- buf.Bytes():
+		// metric-documentation-v2: (loggregator.metron.archived) Number of
+		// v2 envelopes queued to be written to the local archive
+		a.archivedMetric.Increment(uint64(n))
+	default:
+		// metric-documentation-v2: (loggregator.metron.dropped) Number of v2
+		// envelopes dropped because the local archive fell behind
+		a.droppedMetric.Increment(uint64(n))
+	}
+
+	return nil
+}
+
+// Start writes queued batches to disk until the Archive is stopped.
+func (a *Archive) Start() {
+	defer close(a.stopped)
+
+	var tick <-chan time.Time
+	if a.maxFileAge > 0 {
+		t := time.NewTicker(time.Second)
+		defer t.Stop()
+		tick = t.C
+	}
+
+	for {
+		select {
+		case data := <-a.batches:
+			a.write(data)
+		case <-tick:
+			if a.active != nil && time.Since(a.activeAt) >= a.maxFileAge {
+				a.rotate()
+			}
+		case <-a.done:
+			a.drain()
+			return
+		}
+	}
+}
+
+// Stop writes the batches already queued and closes the current file. It
+// must only be called once Start has been called.
+func (a *Archive) Stop() {
+	close(a.done)
+	<-a.stopped
+}
+
+func (a *Archive) drain() {
+	for {
+		select {
+		case data := <-a.batches:
+			a.write(data)
+		default:
+			if a.active != nil {
+				a.rotate()
+			}
+			return
+		}
+	}
+}
+
+func (a *Archive) write(data []byte) {
+	if a.active == nil {
+		if err := a.open(); err != nil {
+			log.Printf("failed to open archive file: %s", err)
+			return
+		}
+	}
+
+	seg := a.segments[len(a.segments)-1]
+	n, err := a.active.Write(data)
+	seg.size += int64(n)
+	a.totalBytes += int64(n)
+	if err != nil {
+		log.Printf("failed to write archive file %s: %s", seg.path, err)
+	}
+
+	if seg.size >= a.maxFileBytes {
+		a.rotate()
+	}
+
+	a.enforceMaxTotalBytes()
+}
+
+func (a *Archive) open() error {
+	now := time.Now().UTC()
+	path := filepath.Join(a.dir, filePrefix+now.Format(fileTimeFormat)+fileSuffix)
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+
+	a.active = f
+	a.activeAt = now
+	a.segments = append(a.segments, &segment{path: path})
+
+	return nil
+}
+
+// rotate closes and compresses the current file. The next write starts a
+// new one.
+func (a *Archive) rotate() {
+	if err := a.active.Close(); err != nil {
+		log.Printf("failed to close archive file: %s", err)
+	}
+	a.active = nil
+
+	seg := a.segments[len(a.segments)-1]
+	a.compress(seg)
+}
+
+func (a *Archive) compress(seg *segment) {
+	size, err := gzipFile(seg.path)
+	if err != nil {
+		log.Printf("failed to compress archive file %s: %s", seg.path, err)
+		return
+	}
+
+	a.totalBytes += size - seg.size
+	seg.path += gzipSuffix
+	seg.size = size
+}
+
+func (a *Archive) enforceMaxTotalBytes() {
+	for a.maxTotalBytes > 0 && a.totalBytes > a.maxTotalBytes && len(a.segments) > 1 {
+		head := a.segments[0]
+		if err := os.Remove(head.path); err != nil && !os.IsNotExist(err) {
+			log.Printf("failed to remove archive file %s: %s", head.path, err)
+		}
+
+		a.totalBytes -= head.size
+		a.segments = a.segments[1:]
+	}
+}
+
+func (a *Archive) loadSegments() error {
+	files, err := ioutil.ReadDir(a.dir)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range files {
+		name := f.Name()
+		if f.IsDir() || !strings.HasPrefix(name, filePrefix) {
+			continue
+		}
+
+		if !strings.HasSuffix(name, fileSuffix) && !strings.HasSuffix(name, fileSuffix+gzipSuffix) {
+			continue
+		}
+
+		seg := &segment{
+			path: filepath.Join(a.dir, name),
+			size: f.Size(),
+		}
+		a.segments = append(a.segments, seg)
+		a.totalBytes += seg.size
+
+		if strings.HasSuffix(name, fileSuffix) {
+			a.compress(seg)
+		}
+	}
+
+	sort.Sort(byPath(a.segments))
+	a.enforceMaxTotalBytes()
+
+	return nil
+}
+
+func (a *Archive) matches(e *plumbing.Envelope) bool {
+	if len(a.types) > 0 && !a.types[envelopeType(e)] {
+		return false
+	}
+
+	if len(a.sourceIDs) == 0 {
+		return true
+	}
+
+	for _, p := range a.sourceIDs {
+		if ok, _ := filepath.Match(p, e.GetSourceId()); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+func envelopeType(e *plumbing.Envelope) string {
+	switch e.GetMessage().(type) {
+	case *plumbing.Envelope_Log:
+		return "log"
+	case *plumbing.Envelope_Counter:
+		return "counter"
+	case *plumbing.Envelope_Gauge:
+		return "gauge"
+	case *plumbing.Envelope_Timer:
+		return "timer"
+	}
+
+	return ""
+}
+
+// gzipFile replaces the file at path with a gzipped copy at path.gz and
+// returns the size of the copy.
+func gzipFile(path string) (int64, error) {
+	src, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(path+gzipSuffix, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return 0, err
+	}
+
+	gz := gzip.NewWriter(dst)
+	if _, err := io.Copy(gz, src); err != nil {
+		dst.Close()
+		return 0, err
+	}
+
+	if err := gz.Close(); err != nil {
+		dst.Close()
+		return 0, err
+	}
+
+	info, err := dst.Stat()
+	if err != nil {
+		dst.Close()
+		return 0, err
+	}
+
+	if err := dst.Close(); err != nil {
+		return 0, err
+	}
+
+	return info.Size(), os.Remove(path)
+}
+
+type byPath []*segment
+
+func (s byPath) Len() int           { return len(s) }
+func (s byPath) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byPath) Less(i, j int) bool { return s[i].path < s[j].path }
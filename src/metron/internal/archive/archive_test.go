@@ -0,0 +1,175 @@
+package archive_test
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"metricemitter/testhelper"
+	"metron/internal/archive"
+	plumbing "plumbing/v2"
+
+	"github.com/golang/protobuf/jsonpb"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Archive", func() {
+	var (
+		dir        string
+		spyMetrics *testhelper.SpyMetricClient
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "archive")
+		Expect(err).ToNot(HaveOccurred())
+
+		spyMetrics = testhelper.NewMetricClient()
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	start := func(opts ...archive.Option) *archive.Archive {
+		a, err := archive.New(dir, spyMetrics, opts...)
+		Expect(err).ToNot(HaveOccurred())
+		go a.Start()
+
+		return a
+	}
+
+	It("writes envelopes as gzipped newline delimited JSON", func() {
+		a := start()
+		Expect(a.Write([]*plumbing.Envelope{counter("a"), counter("b")})).To(Succeed())
+		a.Stop()
+
+		files := archived(dir)
+		Expect(files).To(HaveLen(1))
+		Expect(files[0]).To(HaveSuffix(".ndjson.gz"))
+
+		envelopes := readArchive(files[0])
+		Expect(envelopes).To(HaveLen(2))
+		Expect(envelopes[0].GetSourceId()).To(Equal("a"))
+		Expect(envelopes[1].GetCounter().GetName()).To(Equal("requests"))
+		Expect(spyMetrics.GetDelta("archived")).To(Equal(uint64(2)))
+	})
+
+	It("archives only the configured source_ids and types", func() {
+		a := start(
+			archive.WithSourceIDs("app-*"),
+			archive.WithEnvelopeTypes("counter"),
+		)
+		Expect(a.Write([]*plumbing.Envelope{
+			counter("app-1"),
+			counter("router"),
+			{SourceId: "app-2", Message: &plumbing.Envelope_Log{Log: &plumbing.Log{}}},
+		})).To(Succeed())
+		a.Stop()
+
+		files := archived(dir)
+		Expect(files).To(HaveLen(1))
+		envelopes := readArchive(files[0])
+		Expect(envelopes).To(HaveLen(1))
+		Expect(envelopes[0].GetSourceId()).To(Equal("app-1"))
+	})
+
+	It("rotates files by size", func() {
+		a := start(archive.WithMaxFileBytes(1))
+		for i := 0; i < 3; i++ {
+			Expect(a.Write([]*plumbing.Envelope{counter("a")})).To(Succeed())
+		}
+
+		Eventually(func() []string { return archived(dir) }).Should(HaveLen(3))
+		a.Stop()
+	})
+
+	It("rotates files by age", func() {
+		a := start(archive.WithMaxFileAge(time.Millisecond))
+		Expect(a.Write([]*plumbing.Envelope{counter("a")})).To(Succeed())
+
+		Eventually(func() []string { return archived(dir) }, 3).Should(
+			ConsistOf(HaveSuffix(".ndjson.gz")),
+		)
+		a.Stop()
+	})
+
+	It("removes the oldest files beyond the disk budget", func() {
+		a := start(archive.WithMaxTotalBytes(400))
+		for i := 0; i < 20; i++ {
+			Expect(a.Write([]*plumbing.Envelope{counter(strings.Repeat("a", 50))})).To(Succeed())
+		}
+		a.Stop()
+
+		var total int64
+		for _, f := range archived(dir) {
+			info, err := os.Stat(f)
+			Expect(err).ToNot(HaveOccurred())
+			total += info.Size()
+		}
+		Expect(total).To(BeNumerically("<=", 400))
+	})
+
+	It("compresses files left by a previous run", func() {
+		path := filepath.Join(dir, "envelopes-20170101T000000.000000000Z.ndjson")
+		Expect(ioutil.WriteFile(path, []byte(`{"source_id":"old"}`+"\n"), 0600)).To(Succeed())
+
+		_, err := archive.New(dir, spyMetrics)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(archived(dir)).To(ConsistOf(path + ".gz"))
+	})
+
+	It("drops batches instead of blocking when it falls behind", func() {
+		a, err := archive.New(dir, spyMetrics, archive.WithBufferSize(1))
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(a.Write([]*plumbing.Envelope{counter("a")})).To(Succeed())
+		Expect(a.Write([]*plumbing.Envelope{counter("a"), counter("b")})).To(Succeed())
+
+		Expect(spyMetrics.GetDelta("dropped")).To(Equal(uint64(2)))
+	})
+})
+
+func counter(sourceID string) *plumbing.Envelope {
+	return &plumbing.Envelope{
+		SourceId:  sourceID,
+		Timestamp: time.Now().UnixNano(),
+		Message: &plumbing.Envelope_Counter{
+			Counter: &plumbing.Counter{Name: "requests"},
+		},
+	}
+}
+
+func archived(dir string) []string {
+	files, err := filepath.Glob(filepath.Join(dir, "envelopes-*"))
+	Expect(err).ToNot(HaveOccurred())
+
+	return files
+}
+
+func readArchive(path string) []*plumbing.Envelope {
+	f, err := os.Open(path)
+	Expect(err).ToNot(HaveOccurred())
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	Expect(err).ToNot(HaveOccurred())
+
+	var envelopes []*plumbing.Envelope
+	scanner := bufio.NewScanner(gz)
+	for scanner.Scan() {
+		var e plumbing.Envelope
+		Expect(jsonpb.UnmarshalString(scanner.Text(), &e)).To(Succeed())
+		envelopes = append(envelopes, &e)
+	}
+	Expect(scanner.Err()).ToNot(HaveOccurred())
+
+	return envelopes
+}
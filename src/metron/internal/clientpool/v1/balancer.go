@@ -4,10 +4,12 @@ import (
 	"fmt"
 	"math/rand"
 	"net"
+	"sync"
 )
 
 // Balancer provides IPs resolved from a DNS address in random order
 type Balancer struct {
+	mu     sync.RWMutex
 	addr   string
 	lookup func(string) ([]net.IP, error)
 }
@@ -36,11 +38,22 @@ func NewBalancer(addr string, opts ...BalancerOption) *Balancer {
 	return balancer
 }
 
+// SetAddr replaces the address the balancer resolves.
+func (b *Balancer) SetAddr(addr string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.addr = addr
+}
+
 // NextHostPort returns hostport resolved from the balancer's addr.
 // It returns error for an invalid addr or if lookup failed or
 // doesn't resolve to anything.
 func (b *Balancer) NextHostPort() (string, error) {
-	host, port, err := net.SplitHostPort(b.addr)
+	b.mu.RLock()
+	addr := b.addr
+	b.mu.RUnlock()
+
+	host, port, err := net.SplitHostPort(addr)
 	if err != nil {
 		return "", err
 	}
@@ -51,7 +64,7 @@ func (b *Balancer) NextHostPort() (string, error) {
 	}
 
 	if len(ips) == 0 {
-		return "", fmt.Errorf("lookup failed with addr %s", b.addr)
+		return "", fmt.Errorf("lookup failed with addr %s", addr)
 	}
 
 	return net.JoinHostPort(ips[rand.Int()%len(ips)].String(), port), nil
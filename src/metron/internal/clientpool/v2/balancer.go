@@ -2,14 +2,44 @@ package v2
 
 import (
 	"fmt"
+	"log"
 	"math/rand"
 	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
+// endpoint is a resolved doppler address. Endpoints are preferred by
+// ascending priority and then by ascending SRV priority. Within a group
+// endpoints are chosen in proportion to their weight.
+type endpoint struct {
+	hostPort    string
+	priority    uint
+	srvPriority uint16
+	weight      uint16
+}
+
 // Balancer provides IPs resolved from a DNS address in random order
 type Balancer struct {
+	addrMu sync.RWMutex
 	addr   string
-	lookup func(string) ([]net.IP, error)
+
+	srv       bool
+	priority  uint
+	lookup    func(string) ([]net.IP, error)
+	lookupSRV func(service, proto, name string) (string, []*net.SRV, error)
+
+	refreshInterval time.Duration
+	ttl             time.Duration
+
+	mu         sync.Mutex
+	endpoints  []endpoint
+	resolvedAt time.Time
+
+	stop     chan struct{}
+	stopOnce sync.Once
 }
 
 // BalancerOption is a type that will manipulate a config
@@ -22,38 +52,241 @@ func WithLookup(lookup func(string) ([]net.IP, error)) func(*Balancer) {
 	}
 }
 
+// WithSRVLookup sets the behavior of looking up SRV records
+func WithSRVLookup(lookup func(service, proto, name string) (string, []*net.SRV, error)) func(*Balancer) {
+	return func(b *Balancer) {
+		b.lookupSRV = lookup
+	}
+}
+
+// WithPriority sets the priority of the balancer's addresses. Lower
+// priorities are preferred.
+func WithPriority(p uint) func(*Balancer) {
+	return func(b *Balancer) {
+		b.priority = p
+	}
+}
+
+// WithCache makes the balancer re-resolve its address in the background
+// every refreshInterval rather than on every call. Resolved addresses are
+// used for up to ttl when re-resolving fails.
+func WithCache(refreshInterval, ttl time.Duration) func(*Balancer) {
+	return func(b *Balancer) {
+		b.refreshInterval = refreshInterval
+		b.ttl = ttl
+	}
+}
+
 // NewBalancer returns a Balancer
 func NewBalancer(addr string, opts ...BalancerOption) *Balancer {
 	balancer := &Balancer{
-		addr:   addr,
-		lookup: net.LookupIP,
+		addr:      addr,
+		lookup:    net.LookupIP,
+		lookupSRV: net.LookupSRV,
+		stop:      make(chan struct{}),
 	}
 
 	for _, o := range opts {
 		o(balancer)
 	}
 
+	if balancer.refreshInterval > 0 {
+		go balancer.refresh()
+	}
+
 	return balancer
 }
 
+// NewSRVBalancer returns a Balancer that resolves addresses from the DNS
+// SRV record with the given name. The record's ports, priorities and
+// weights are honored.
+func NewSRVBalancer(name string, opts ...BalancerOption) *Balancer {
+	return NewBalancer(name, append([]BalancerOption{
+		func(b *Balancer) { b.srv = true },
+	}, opts...)...)
+}
+
 // NextHostPort returns hostport resolved from the balancer's addr.
 // It returns error for an invalid addr or if lookup failed or
 // doesn't resolve to anything.
 func (b *Balancer) NextHostPort() (string, error) {
-	host, port, err := net.SplitHostPort(b.addr)
+	hostPorts, err := b.HostPorts()
 	if err != nil {
 		return "", err
 	}
 
+	return hostPorts[rand.Int()%len(hostPorts)], nil
+}
+
+// HostPorts returns every hostport resolved from the balancer's addr.
+// It returns error for an invalid addr or if lookup failed or
+// doesn't resolve to anything.
+func (b *Balancer) HostPorts() ([]string, error) {
+	endpoints, err := b.resolved()
+	if err != nil {
+		return nil, err
+	}
+
+	hostPorts := make([]string, 0, len(endpoints))
+	for _, e := range endpoints {
+		hostPorts = append(hostPorts, e.hostPort)
+	}
+
+	return hostPorts, nil
+}
+
+// resolved returns the cached endpoints while they are within their ttl and
+// resolves them otherwise. Lookups run without holding b.mu so that callers
+// are not held up behind a slow DNS server.
+func (b *Balancer) resolved() ([]endpoint, error) {
+	if b.refreshInterval == 0 {
+		return b.resolve(b.address())
+	}
+
+	b.mu.Lock()
+	if len(b.endpoints) > 0 && time.Since(b.resolvedAt) < b.ttl {
+		endpoints := b.endpoints
+		b.mu.Unlock()
+		return endpoints, nil
+	}
+	b.mu.Unlock()
+
+	addr := b.address()
+	endpoints, err := b.resolve(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	b.mu.Lock()
+	// The address may have been replaced while resolving.
+	if addr == b.address() {
+		b.endpoints = endpoints
+		b.resolvedAt = time.Now()
+	}
+	b.mu.Unlock()
+
+	return endpoints, nil
+}
+
+// SetAddr replaces the address the balancer resolves. Cached addresses are
+// discarded.
+func (b *Balancer) SetAddr(addr string) {
+	b.addrMu.Lock()
+	b.addr = addr
+	b.addrMu.Unlock()
+
+	b.mu.Lock()
+	b.endpoints = nil
+	b.mu.Unlock()
+}
+
+// Stop stops the balancer re-resolving its address in the background.
+func (b *Balancer) Stop() {
+	b.stopOnce.Do(func() {
+		close(b.stop)
+	})
+}
+
+func (b *Balancer) address() string {
+	b.addrMu.RLock()
+	defer b.addrMu.RUnlock()
+	return b.addr
+}
+
+func (b *Balancer) refresh() {
+	t := time.NewTicker(b.refreshInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+		case <-b.stop:
+			return
+		}
+
+		addr := b.address()
+		endpoints, err := b.resolve(addr)
+		if err != nil {
+			log.Printf("failed to re-resolve %s: %s", addr, err)
+			continue
+		}
+
+		b.mu.Lock()
+		// The address may have been replaced while resolving.
+		if addr == b.address() {
+			b.endpoints = endpoints
+			b.resolvedAt = time.Now()
+		}
+		b.mu.Unlock()
+	}
+}
+
+func (b *Balancer) resolve(addr string) ([]endpoint, error) {
+	var endpoints []endpoint
+	var err error
+	if b.srv {
+		endpoints, err = b.resolveSRV(addr)
+	} else {
+		endpoints, err = b.resolveHost(addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(endpoints) == 0 {
+		return nil, fmt.Errorf("lookup failed with addr %s", addr)
+	}
+
+	return endpoints, nil
+}
+
+func (b *Balancer) resolveHost(addr string) ([]endpoint, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
 	ips, err := b.lookup(host)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	if len(ips) == 0 {
-		return "", fmt.Errorf("lookup failed with addr %s", b.addr)
+	endpoints := make([]endpoint, 0, len(ips))
+	for _, ip := range ips {
+		endpoints = append(endpoints, endpoint{
+			hostPort: net.JoinHostPort(ip.String(), port),
+			priority: b.priority,
+			weight:   1,
+		})
 	}
 
-	return net.JoinHostPort(ips[rand.Int()%len(ips)].String(), port), nil
+	return endpoints, nil
+}
+
+func (b *Balancer) resolveSRV(name string) ([]endpoint, error) {
+	_, records, err := b.lookupSRV("", "", name)
+	if err != nil {
+		return nil, err
+	}
+
+	var endpoints []endpoint
+	for _, r := range records {
+		ips, err := b.lookup(strings.TrimSuffix(r.Target, "."))
+		if err != nil {
+			log.Printf("failed to lookup SRV target %s: %s", r.Target, err)
+			continue
+		}
+
+		port := strconv.Itoa(int(r.Port))
+		for _, ip := range ips {
+			endpoints = append(endpoints, endpoint{
+				hostPort:    net.JoinHostPort(ip.String(), port),
+				priority:    b.priority,
+				srvPriority: r.Priority,
+				weight:      r.Weight,
+			})
+		}
+	}
 
+	return endpoints, nil
 }
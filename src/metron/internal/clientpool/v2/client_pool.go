@@ -13,6 +13,12 @@ type Conn interface {
 	Write(data []*plumbing.Envelope) (err error)
 }
 
+// Scorer is implemented by Conns that can report the health of the doppler
+// they write to. Lower scores are healthier.
+type Scorer interface {
+	Score() float64
+}
+
 type ClientPool struct {
 	conns []unsafe.Pointer
 }
@@ -29,10 +35,10 @@ func New(conns ...Conn) *ClientPool {
 }
 
 func (c *ClientPool) Write(msgs []*plumbing.Envelope) error {
-	seed := rand.Int()
+	first := c.first()
 	for i := range c.conns {
-		idx := (i + seed) % len(c.conns)
-		conn := *(*Conn)(atomic.LoadPointer(&c.conns[idx]))
+		idx := (i + first) % len(c.conns)
+		conn := c.conn(idx)
 
 		if err := conn.Write(msgs); err == nil {
 			return nil
@@ -41,3 +47,30 @@ func (c *ClientPool) Write(msgs []*plumbing.Envelope) error {
 
 	return errors.New("unable to write to any dopplers")
 }
+
+// first picks the conn to try first. When conns report their health the
+// healthier of two random conns is chosen.
+func (c *ClientPool) first() int {
+	n := len(c.conns)
+	if n < 2 {
+		return 0
+	}
+
+	i := rand.Intn(n)
+	a, ok := c.conn(i).(Scorer)
+	if !ok {
+		return i
+	}
+
+	j := (i + 1 + rand.Intn(n-1)) % n
+	b, ok := c.conn(j).(Scorer)
+	if ok && b.Score() < a.Score() {
+		return j
+	}
+
+	return i
+}
+
+func (c *ClientPool) conn(idx int) Conn {
+	return *(*Conn)(atomic.LoadPointer(&c.conns[idx]))
+}
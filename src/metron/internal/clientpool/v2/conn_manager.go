@@ -4,6 +4,7 @@ import (
 	"errors"
 	"io"
 	"log"
+	"math"
 	plumbing "plumbing/v2"
 	"sync/atomic"
 	"time"
@@ -14,6 +15,13 @@ type Connector interface {
 	Connect() (io.Closer, plumbing.DopplerIngress_BatchSenderClient, error)
 }
 
+// healthScorer is implemented by clients that track the health of the
+// doppler they are connected to.
+type healthScorer interface {
+	Score() float64
+	Ejected() bool
+}
+
 type v2GRPCConn struct {
 	client plumbing.DopplerIngress_BatchSenderClient
 	closer io.Closer
@@ -61,14 +69,42 @@ func (m *ConnManager) Write(envelopes []*plumbing.Envelope) error {
 
 	if atomic.AddInt64(&gRPCConn.writes, 1) >= m.maxWrites {
 		log.Printf("recycling connection to doppler after %d writes", m.maxWrites)
-		atomic.StorePointer(&m.conn, nil)
-		gRPCConn.closer.Close()
-		m.reset <- true
+		m.recycle(conn, gRPCConn)
+		return nil
+	}
+
+	if s, ok := gRPCConn.client.(healthScorer); ok && s.Ejected() {
+		log.Print("recycling connection to ejected doppler")
+		m.recycle(conn, gRPCConn)
 	}
 
 	return nil
 }
 
+// Score returns the cost of writing to the current connection. Lower is
+// healthier. Connections that do not track health score zero.
+func (m *ConnManager) Score() float64 {
+	conn := atomic.LoadPointer(&m.conn)
+	if conn == nil || (*v2GRPCConn)(conn) == nil {
+		return math.Inf(1)
+	}
+
+	if s, ok := (*v2GRPCConn)(conn).client.(healthScorer); ok {
+		return s.Score()
+	}
+
+	return 0
+}
+
+func (m *ConnManager) recycle(conn unsafe.Pointer, gRPCConn *v2GRPCConn) {
+	if !atomic.CompareAndSwapPointer(&m.conn, conn, nil) {
+		return
+	}
+
+	gRPCConn.closer.Close()
+	m.reset <- true
+}
+
 func (m *ConnManager) maintainConn() {
 
 	// Ensure initial connection does not wait on timer
@@ -0,0 +1,377 @@
+package v2_test
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync"
+	"time"
+
+	clientpool "metron/internal/clientpool/v2"
+	"metron/internal/health"
+	plumbing "plumbing/v2"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type SpyV2Fetcher struct {
+	mu    sync.Mutex
+	addrs []string
+}
+
+func (f *SpyV2Fetcher) Fetch(addr string) (io.Closer, plumbing.DopplerIngress_BatchSenderClient, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.addrs = append(f.addrs, addr)
+	return ioutil.NopCloser(nil), &SpyClient{}, nil
+}
+
+func (f *SpyV2Fetcher) lastAddr() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.addrs[len(f.addrs)-1]
+}
+
+func staticLookup(ips ...string) func(string) ([]net.IP, error) {
+	return func(string) ([]net.IP, error) {
+		var result []net.IP
+		for _, ip := range ips {
+			result = append(result, net.ParseIP(ip))
+		}
+		return result, nil
+	}
+}
+
+var _ = Describe("Doppler discovery", func() {
+	Describe("SRV balancer", func() {
+		It("resolves the targets and ports of the SRV record", func() {
+			srvLookup := func(service, proto, name string) (string, []*net.SRV, error) {
+				Expect(name).To(Equal("_grpc._tcp.doppler.com"))
+				return "", []*net.SRV{
+					{Target: "doppler-a.com.", Port: 8082, Weight: 10},
+					{Target: "doppler-b.com.", Port: 9092, Weight: 10},
+				}, nil
+			}
+			lookup := func(host string) ([]net.IP, error) {
+				switch host {
+				case "doppler-a.com":
+					return []net.IP{net.ParseIP("10.0.0.1")}, nil
+				case "doppler-b.com":
+					return []net.IP{net.ParseIP("10.0.0.2")}, nil
+				}
+				return nil, errors.New("unknown host")
+			}
+
+			balancer := clientpool.NewSRVBalancer(
+				"_grpc._tcp.doppler.com",
+				clientpool.WithSRVLookup(srvLookup),
+				clientpool.WithLookup(lookup),
+			)
+
+			Expect(balancer.HostPorts()).To(ConsistOf("10.0.0.1:8082", "10.0.0.2:9092"))
+		})
+
+		It("prefers SRV records with a lower priority", func() {
+			srvLookup := func(service, proto, name string) (string, []*net.SRV, error) {
+				return "", []*net.SRV{
+					{Target: "backup.com.", Port: 8082, Priority: 20, Weight: 1},
+					{Target: "primary.com.", Port: 8082, Priority: 10, Weight: 1},
+				}, nil
+			}
+			lookup := func(host string) ([]net.IP, error) {
+				if host == "primary.com" {
+					return []net.IP{net.ParseIP("10.0.0.1")}, nil
+				}
+				return []net.IP{net.ParseIP("10.0.0.2")}, nil
+			}
+
+			fetcher := &SpyV2Fetcher{}
+			connector := clientpool.MakeGRPCConnector(fetcher, []*clientpool.Balancer{
+				clientpool.NewSRVBalancer(
+					"doppler.com",
+					clientpool.WithSRVLookup(srvLookup),
+					clientpool.WithLookup(lookup),
+				),
+			})
+
+			for i := 0; i < 10; i++ {
+				_, _, err := connector.Connect()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(fetcher.lastAddr()).To(Equal("10.0.0.1:8082"))
+			}
+		})
+
+		It("picks SRV records in proportion to their weight", func() {
+			srvLookup := func(service, proto, name string) (string, []*net.SRV, error) {
+				return "", []*net.SRV{
+					{Target: "heavy.com.", Port: 8082, Weight: 100},
+					{Target: "unused.com.", Port: 8082, Weight: 0},
+				}, nil
+			}
+			lookup := func(host string) ([]net.IP, error) {
+				if host == "heavy.com" {
+					return []net.IP{net.ParseIP("10.0.0.1")}, nil
+				}
+				return []net.IP{net.ParseIP("10.0.0.2")}, nil
+			}
+
+			fetcher := &SpyV2Fetcher{}
+			connector := clientpool.MakeGRPCConnector(fetcher, []*clientpool.Balancer{
+				clientpool.NewSRVBalancer(
+					"doppler.com",
+					clientpool.WithSRVLookup(srvLookup),
+					clientpool.WithLookup(lookup),
+				),
+			})
+
+			for i := 0; i < 10; i++ {
+				connector.Connect()
+				Expect(fetcher.lastAddr()).To(Equal("10.0.0.1:8082"))
+			}
+		})
+	})
+
+	Describe("caching", func() {
+		It("does not lookup on every call", func() {
+			var mu sync.Mutex
+			var lookups int
+			lookup := func(string) ([]net.IP, error) {
+				mu.Lock()
+				defer mu.Unlock()
+				lookups++
+				return []net.IP{net.ParseIP("10.0.0.1")}, nil
+			}
+
+			balancer := clientpool.NewBalancer(
+				"doppler.com:8082",
+				clientpool.WithLookup(lookup),
+				clientpool.WithCache(time.Hour, time.Hour),
+			)
+
+			for i := 0; i < 5; i++ {
+				Expect(balancer.NextHostPort()).To(Equal("10.0.0.1:8082"))
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			Expect(lookups).To(Equal(1))
+		})
+
+		It("re-resolves in the background", func() {
+			var mu sync.Mutex
+			ip := "10.0.0.1"
+			lookup := func(string) ([]net.IP, error) {
+				mu.Lock()
+				defer mu.Unlock()
+				return []net.IP{net.ParseIP(ip)}, nil
+			}
+
+			balancer := clientpool.NewBalancer(
+				"doppler.com:8082",
+				clientpool.WithLookup(lookup),
+				clientpool.WithCache(10*time.Millisecond, time.Hour),
+			)
+			Expect(balancer.NextHostPort()).To(Equal("10.0.0.1:8082"))
+
+			mu.Lock()
+			ip = "10.0.0.2"
+			mu.Unlock()
+
+			Eventually(balancer.NextHostPort).Should(Equal("10.0.0.2:8082"))
+		})
+
+		It("keeps using cached addresses within the ttl when lookup fails", func() {
+			var mu sync.Mutex
+			var fail bool
+			lookup := func(string) ([]net.IP, error) {
+				mu.Lock()
+				defer mu.Unlock()
+				if fail {
+					return nil, errors.New("some-error")
+				}
+				return []net.IP{net.ParseIP("10.0.0.1")}, nil
+			}
+
+			balancer := clientpool.NewBalancer(
+				"doppler.com:8082",
+				clientpool.WithLookup(lookup),
+				clientpool.WithCache(time.Millisecond, time.Hour),
+			)
+			Expect(balancer.NextHostPort()).To(Equal("10.0.0.1:8082"))
+
+			mu.Lock()
+			fail = true
+			mu.Unlock()
+
+			Consistently(balancer.NextHostPort, 50*time.Millisecond).Should(Equal("10.0.0.1:8082"))
+		})
+
+		It("stops re-resolving once stopped", func() {
+			var mu sync.Mutex
+			var lookups int
+			lookup := func(string) ([]net.IP, error) {
+				mu.Lock()
+				defer mu.Unlock()
+				lookups++
+				return []net.IP{net.ParseIP("10.0.0.1")}, nil
+			}
+			count := func() int {
+				mu.Lock()
+				defer mu.Unlock()
+				return lookups
+			}
+
+			balancer := clientpool.NewBalancer(
+				"doppler.com:8082",
+				clientpool.WithLookup(lookup),
+				clientpool.WithCache(time.Millisecond, time.Hour),
+			)
+			Eventually(count).Should(BeNumerically(">", 1))
+
+			balancer.Stop()
+			time.Sleep(10 * time.Millisecond)
+			n := count()
+
+			Consistently(count, 50*time.Millisecond).Should(Equal(n))
+		})
+
+		It("discards cached addresses when the address is replaced", func() {
+			lookup := func(host string) ([]net.IP, error) {
+				if host == "other-doppler.com" {
+					return []net.IP{net.ParseIP("10.0.0.2")}, nil
+				}
+				return []net.IP{net.ParseIP("10.0.0.1")}, nil
+			}
+
+			balancer := clientpool.NewBalancer(
+				"doppler.com:8082",
+				clientpool.WithLookup(lookup),
+				clientpool.WithCache(time.Hour, time.Hour),
+			)
+			Expect(balancer.NextHostPort()).To(Equal("10.0.0.1:8082"))
+
+			balancer.SetAddr("other-doppler.com:9092")
+
+			Expect(balancer.NextHostPort()).To(Equal("10.0.0.2:9092"))
+		})
+
+		It("does not hold up other calls while resolving", func() {
+			started := make(chan struct{})
+			release := make(chan struct{})
+			lookup := func(host string) ([]net.IP, error) {
+				if host == "slow-doppler.com" {
+					close(started)
+					<-release
+				}
+				return []net.IP{net.ParseIP("10.0.0.1")}, nil
+			}
+
+			balancer := clientpool.NewBalancer(
+				"slow-doppler.com:8082",
+				clientpool.WithLookup(lookup),
+				clientpool.WithCache(time.Hour, time.Hour),
+			)
+			defer close(release)
+			go balancer.NextHostPort()
+			Eventually(started).Should(BeClosed())
+
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				balancer.SetAddr("doppler.com:8082")
+			}()
+
+			Eventually(done).Should(BeClosed())
+			Expect(balancer.NextHostPort()).To(Equal("10.0.0.1:8082"))
+		})
+	})
+
+	Describe("priorities", func() {
+		var (
+			fetcher   *SpyV2Fetcher
+			balancers []*clientpool.Balancer
+		)
+
+		BeforeEach(func() {
+			fetcher = &SpyV2Fetcher{}
+			balancers = []*clientpool.Balancer{
+				clientpool.NewBalancer(
+					"backup.com:8082",
+					clientpool.WithLookup(staticLookup("10.0.1.1")),
+					clientpool.WithPriority(1),
+				),
+				clientpool.NewBalancer(
+					"primary.com:8082",
+					clientpool.WithLookup(staticLookup("10.0.0.1", "10.0.0.2")),
+					clientpool.WithPriority(0),
+				),
+			}
+		})
+
+		It("connects to the highest priority group", func() {
+			connector := clientpool.MakeGRPCConnector(fetcher, balancers)
+
+			for i := 0; i < 10; i++ {
+				connector.Connect()
+				Expect(fetcher.lastAddr()).To(BeElementOf("10.0.0.1:8082", "10.0.0.2:8082"))
+			}
+		})
+
+		It("falls through to the next group when the higher one has no healthy members", func() {
+			tracker := clientpool.NewHealthTracker(
+				health.NewRegistry(),
+				clientpool.WithMinSamples(1),
+			)
+			tracker.Track("10.0.0.1:8082")(errors.New("some-error"))
+			tracker.Track("10.0.0.2:8082")(errors.New("some-error"))
+
+			connector := clientpool.MakeGRPCConnector(
+				fetcher,
+				balancers,
+				clientpool.WithHealthTracker(tracker),
+			)
+
+			_, _, err := connector.Connect()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(fetcher.lastAddr()).To(Equal("10.0.1.1:8082"))
+		})
+
+		It("forgets the health of dopplers that are no longer discovered", func() {
+			registry := health.NewRegistry()
+			tracker := clientpool.NewHealthTracker(registry)
+			tracker.Track("10.0.2.1:8082")(nil)
+			Expect(registry.State()).To(HaveKey("doppler_10.0.2.1:8082_latency_ms"))
+
+			connector := clientpool.MakeGRPCConnector(
+				fetcher,
+				balancers,
+				clientpool.WithHealthTracker(tracker),
+			)
+			_, _, err := connector.Connect()
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(registry.State()).ToNot(HaveKey("doppler_10.0.2.1:8082_latency_ms"))
+		})
+
+		It("stays in the higher group while it has a healthy member", func() {
+			tracker := clientpool.NewHealthTracker(
+				health.NewRegistry(),
+				clientpool.WithMinSamples(1),
+			)
+			tracker.Track("10.0.0.1:8082")(errors.New("some-error"))
+
+			connector := clientpool.MakeGRPCConnector(
+				fetcher,
+				balancers,
+				clientpool.WithHealthTracker(tracker),
+			)
+
+			for i := 0; i < 10; i++ {
+				connector.Connect()
+				Expect(fetcher.lastAddr()).To(Equal("10.0.0.2:8082"))
+			}
+		})
+	})
+})
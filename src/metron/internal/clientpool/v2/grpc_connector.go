@@ -4,7 +4,9 @@ import (
 	"errors"
 	"io"
 	"log"
+	"math/rand"
 	plumbing "plumbing/v2"
+	"sort"
 )
 
 type ClientFetcher interface {
@@ -14,25 +16,189 @@ type ClientFetcher interface {
 type GRPCConnector struct {
 	fetcher   ClientFetcher
 	balancers []*Balancer
+	tracker   *HealthTracker
 }
 
-func MakeGRPCConnector(fetcher ClientFetcher, balancers []*Balancer) GRPCConnector {
-	return GRPCConnector{
+// GRPCConnectorOption is a type that will manipulate a GRPCConnector
+type GRPCConnectorOption func(*GRPCConnector)
+
+// WithHealthTracker configures the GRPCConnector to connect to the
+// healthiest doppler and to report the outcome of every write to the
+// tracker.
+func WithHealthTracker(t *HealthTracker) GRPCConnectorOption {
+	return func(c *GRPCConnector) {
+		c.tracker = t
+	}
+}
+
+func MakeGRPCConnector(fetcher ClientFetcher, balancers []*Balancer, opts ...GRPCConnectorOption) GRPCConnector {
+	c := GRPCConnector{
 		fetcher:   fetcher,
 		balancers: balancers,
 	}
+
+	for _, o := range opts {
+		o(&c)
+	}
+
+	return c
 }
 
+// Connect connects to a doppler from the highest priority group of
+// resolved addresses that has a healthy member. If no group has a healthy
+// member the highest priority group is used.
 func (c GRPCConnector) Connect() (io.Closer, plumbing.DopplerIngress_BatchSenderClient, error) {
+	groups, complete := c.priorityGroups()
+	if len(groups) == 0 {
+		return nil, nil, errors.New("unable to lookup a log consumer")
+	}
+
+	// Forget the dopplers no longer discovered. A balancer that failed to
+	// resolve may still have healthy dopplers so nothing is forgotten.
+	if c.tracker != nil && complete {
+		c.tracker.Retain(hostPorts(groups))
+	}
+
+	hostPort, ok := "", false
+	for _, g := range groups {
+		if hostPort, ok = c.choose(g); ok {
+			break
+		}
+	}
+
+	if !ok {
+		hostPort = c.pick(groups[0])
+	}
+
+	if c.tracker == nil {
+		return c.fetcher.Fetch(hostPort)
+	}
+
+	done := c.tracker.Track(hostPort)
+	closer, client, err := c.fetcher.Fetch(hostPort)
+	done(err)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return closer, &trackedSender{
+		DopplerIngress_BatchSenderClient: client,
+		hostPort:                         hostPort,
+		tracker:                          c.tracker,
+	}, nil
+}
+
+// priorityGroups resolves every balancer and groups the endpoints by
+// priority, highest priority first. It reports whether every balancer
+// resolved.
+func (c GRPCConnector) priorityGroups() ([][]endpoint, bool) {
+	complete := true
+	var endpoints []endpoint
 	for _, balancer := range c.balancers {
-		hostPort, err := balancer.NextHostPort()
+		e, err := balancer.resolved()
 		if err != nil {
 			log.Printf("Failed to lookup hostport: %s", err)
+			complete = false
 			continue
 		}
+		endpoints = append(endpoints, e...)
+	}
 
-		return c.fetcher.Fetch(hostPort)
+	sort.SliceStable(endpoints, func(i, j int) bool {
+		if endpoints[i].priority != endpoints[j].priority {
+			return endpoints[i].priority < endpoints[j].priority
+		}
+		return endpoints[i].srvPriority < endpoints[j].srvPriority
+	})
+
+	var groups [][]endpoint
+	for i, e := range endpoints {
+		if i == 0 || e.priority != endpoints[i-1].priority || e.srvPriority != endpoints[i-1].srvPriority {
+			groups = append(groups, nil)
+		}
+		groups[len(groups)-1] = append(groups[len(groups)-1], e)
 	}
 
-	return nil, nil, errors.New("unable to lookup a log consumer")
+	return groups, complete
+}
+
+func hostPorts(groups [][]endpoint) []string {
+	var hostPorts []string
+	for _, g := range groups {
+		for _, e := range g {
+			hostPorts = append(hostPorts, e.hostPort)
+		}
+	}
+
+	return hostPorts
+}
+
+// choose returns a healthy endpoint from the group. It returns false if
+// every member of the group has been ejected.
+func (c GRPCConnector) choose(group []endpoint) (string, bool) {
+	if c.tracker == nil {
+		return c.pick(group), true
+	}
+
+	var healthy []endpoint
+	for _, e := range group {
+		if !c.tracker.Ejected(e.hostPort) {
+			healthy = append(healthy, e)
+		}
+	}
+
+	if len(healthy) == 0 {
+		return "", false
+	}
+
+	return c.tracker.Choose([]string{c.pick(healthy), c.pick(healthy)}), true
+}
+
+// pick returns a random endpoint from the group in proportion to its
+// weight. Endpoints with a weight of zero are only picked when every
+// endpoint has a weight of zero.
+func (c GRPCConnector) pick(group []endpoint) string {
+	var total int
+	for _, e := range group {
+		total += int(e.weight)
+	}
+
+	if total == 0 {
+		return group[rand.Intn(len(group))].hostPort
+	}
+
+	n := rand.Intn(total)
+	for _, e := range group {
+		n -= int(e.weight)
+		if n < 0 {
+			return e.hostPort
+		}
+	}
+
+	return group[len(group)-1].hostPort
+}
+
+// trackedSender reports the latency and outcome of every batch sent to a
+// doppler to a HealthTracker.
+type trackedSender struct {
+	plumbing.DopplerIngress_BatchSenderClient
+
+	hostPort string
+	tracker  *HealthTracker
+}
+
+func (s *trackedSender) Send(batch *plumbing.EnvelopeBatch) error {
+	done := s.tracker.Track(s.hostPort)
+	err := s.DopplerIngress_BatchSenderClient.Send(batch)
+	done(err)
+
+	return err
+}
+
+func (s *trackedSender) Score() float64 {
+	return s.tracker.Score(s.hostPort)
+}
+
+func (s *trackedSender) Ejected() bool {
+	return s.tracker.Ejected(s.hostPort)
 }
@@ -0,0 +1,358 @@
+package v2
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"metron/internal/health"
+	plumbing "plumbing/v2"
+	"sync"
+	"time"
+)
+
+const (
+	defaultMaxErrorRate        = 0.5
+	defaultLatencyOutlierRatio = 3.0
+	defaultEjectionDuration    = 30 * time.Second
+	defaultMinSamples          = 10
+
+	// decay is the weight given to each new sample in the moving averages.
+	decay = 0.1
+
+	// The health values of a doppler are named doppler_<host:port> followed
+	// by these suffixes.
+	errorRateSuffix = "_error_rate_percent"
+	latencySuffix   = "_latency_ms"
+	inFlightSuffix  = "_in_flight"
+	shedSuffix      = "_shed_percent"
+	ejectedSuffix   = "_ejected"
+)
+
+var valueSuffixes = []string{
+	errorRateSuffix,
+	latencySuffix,
+	inFlightSuffix,
+	shedSuffix,
+	ejectedSuffix,
+}
+
+// TrackerRegistry is a HealthRegistry whose values can be removed once a
+// doppler is no longer discovered.
+type TrackerRegistry interface {
+	HealthRegistry
+	UnregisterValue(name string)
+}
+
+// HealthTracker tracks the error rate, write latency, in-flight batches and
+// shed rate of each doppler. It is used to steer connections and batches
+// towards the healthiest dopplers. Dopplers that are outliers in error rate
+// or latency are ejected for a period of time.
+type HealthTracker struct {
+	registry            TrackerRegistry
+	maxErrorRate        float64
+	latencyOutlierRatio float64
+	ejectionDuration    time.Duration
+	minSamples          int64
+
+	mu       sync.Mutex
+	dopplers map[string]*dopplerHealth
+}
+
+type dopplerHealth struct {
+	errorRate    float64
+	latency      float64
+	samples      int64
+	inFlight     int64
+	shedRate     float64
+	acks         int64
+	ejectedUntil time.Time
+
+	errorRateValue *health.Value
+	latencyValue   *health.Value
+	inFlightValue  *health.Value
+	shedValue      *health.Value
+	ejectedValue   *health.Value
+}
+
+// HealthTrackerOption is a type that will manipulate a HealthTracker
+type HealthTrackerOption func(*HealthTracker)
+
+// WithMaxErrorRate sets the error rate, between 0 and 1, above which a
+// doppler is ejected.
+func WithMaxErrorRate(rate float64) HealthTrackerOption {
+	return func(t *HealthTracker) {
+		t.maxErrorRate = rate
+	}
+}
+
+// WithLatencyOutlierRatio sets how many times slower than the average of the
+// other dopplers a doppler must be before it is ejected.
+func WithLatencyOutlierRatio(ratio float64) HealthTrackerOption {
+	return func(t *HealthTracker) {
+		t.latencyOutlierRatio = ratio
+	}
+}
+
+// WithEjectionDuration sets how long an ejected doppler is avoided for.
+func WithEjectionDuration(d time.Duration) HealthTrackerOption {
+	return func(t *HealthTracker) {
+		t.ejectionDuration = d
+	}
+}
+
+// WithMinSamples sets the number of writes to a doppler that must be
+// observed before it can be ejected.
+func WithMinSamples(n int64) HealthTrackerOption {
+	return func(t *HealthTracker) {
+		t.minSamples = n
+	}
+}
+
+// NewHealthTracker returns a HealthTracker that reports the state of each
+// doppler to the given registry.
+func NewHealthTracker(r TrackerRegistry, opts ...HealthTrackerOption) *HealthTracker {
+	t := &HealthTracker{
+		registry:            r,
+		maxErrorRate:        defaultMaxErrorRate,
+		latencyOutlierRatio: defaultLatencyOutlierRatio,
+		ejectionDuration:    defaultEjectionDuration,
+		minSamples:          defaultMinSamples,
+		dopplers:            make(map[string]*dopplerHealth),
+	}
+
+	for _, o := range opts {
+		o(t)
+	}
+
+	return t
+}
+
+// Choose returns the healthiest of the given doppler addresses. Ejected
+// dopplers are only chosen if every doppler is ejected. To avoid every
+// metron converging on the same doppler, two random candidates are compared
+// rather than all of them.
+func (t *HealthTracker) Choose(hostPorts []string) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	var candidates []string
+	for _, hp := range hostPorts {
+		if !t.ejected(hp, now) {
+			candidates = append(candidates, hp)
+		}
+	}
+
+	if len(candidates) == 0 {
+		candidates = hostPorts
+	}
+
+	switch len(candidates) {
+	case 0:
+		return ""
+	case 1:
+		return candidates[0]
+	}
+
+	i := rand.Intn(len(candidates))
+	j := (i + 1 + rand.Intn(len(candidates)-1)) % len(candidates)
+	if t.score(candidates[j]) < t.score(candidates[i]) {
+		return candidates[j]
+	}
+
+	return candidates[i]
+}
+
+// Score returns the cost of writing to the doppler. Lower is healthier.
+func (t *HealthTracker) Score(hostPort string) float64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.ejected(hostPort, time.Now()) {
+		return math.Inf(1)
+	}
+
+	return t.score(hostPort)
+}
+
+// Ejected reports whether the doppler is currently ejected.
+func (t *HealthTracker) Ejected(hostPort string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.ejected(hostPort, time.Now())
+}
+
+// Track marks the start of a write to the doppler. The returned function
+// must be called with the result of the write.
+func (t *HealthTracker) Track(hostPort string) func(error) {
+	t.mu.Lock()
+	d := t.doppler(hostPort)
+	d.inFlight++
+	d.inFlightValue.Increment(1)
+	t.mu.Unlock()
+
+	start := time.Now()
+	return func(err error) {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+
+		d.inFlight--
+		d.inFlightValue.Decrement(1)
+		t.record(hostPort, d, time.Since(start), err)
+	}
+}
+
+// Ack records the share of envelopes the doppler reported shedding. A
+// doppler that sheds scores worse in proportion so that writes shift towards
+// the others. Shedding alone does not eject a doppler as every doppler
+// sheds when the whole cluster is overloaded.
+func (t *HealthTracker) Ack(hostPort string, ack *plumbing.BatchAck) {
+	if ack.GetAccepted() == 0 {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	d := t.doppler(hostPort)
+	d.acks++
+	d.shedRate = ewma(d.shedRate, float64(ack.GetShed())/float64(ack.GetAccepted()), d.acks)
+	d.shedValue.Set(int64(d.shedRate * 100))
+}
+
+// Retain forgets the dopplers that are not in hostPorts and removes their
+// health values. Dopplers with writes in flight are kept until the next
+// call.
+func (t *HealthTracker) Retain(hostPorts []string) {
+	keep := make(map[string]bool, len(hostPorts))
+	for _, hp := range hostPorts {
+		keep[hp] = true
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for hp, d := range t.dopplers {
+		if keep[hp] || d.inFlight > 0 {
+			continue
+		}
+
+		prefix := "doppler_" + hp
+		for _, suffix := range valueSuffixes {
+			t.registry.UnregisterValue(prefix + suffix)
+		}
+		delete(t.dopplers, hp)
+	}
+}
+
+func (t *HealthTracker) record(hostPort string, d *dopplerHealth, latency time.Duration, err error) {
+	var failed float64
+	if err != nil {
+		failed = 1
+	}
+
+	d.samples++
+	d.errorRate = ewma(d.errorRate, failed, d.samples)
+	if err == nil {
+		d.latency = ewma(d.latency, float64(latency), d.samples)
+	}
+
+	d.errorRateValue.Set(int64(d.errorRate * 100))
+	d.latencyValue.Set(int64(time.Duration(d.latency) / time.Millisecond))
+
+	if d.samples < t.minSamples || t.ejected(hostPort, time.Now()) {
+		return
+	}
+
+	if d.errorRate > t.maxErrorRate {
+		t.eject(hostPort, d, fmt.Sprintf("error rate %.2f", d.errorRate))
+		return
+	}
+
+	if avg, ok := t.averageLatency(hostPort); ok && d.latency > avg*t.latencyOutlierRatio {
+		t.eject(hostPort, d, fmt.Sprintf("latency %s", time.Duration(d.latency)))
+	}
+}
+
+func (t *HealthTracker) eject(hostPort string, d *dopplerHealth, reason string) {
+	log.Printf("ejecting doppler %s for %s: %s", hostPort, t.ejectionDuration, reason)
+
+	// Start with a clean slate once the ejection expires.
+	d.ejectedUntil = time.Now().Add(t.ejectionDuration)
+	d.errorRate = 0
+	d.latency = 0
+	d.samples = 0
+	d.ejectedValue.Set(1)
+}
+
+func (t *HealthTracker) ejected(hostPort string, now time.Time) bool {
+	d, ok := t.dopplers[hostPort]
+	if !ok || d.ejectedUntil.IsZero() {
+		return false
+	}
+
+	if now.Before(d.ejectedUntil) {
+		return true
+	}
+
+	d.ejectedUntil = time.Time{}
+	d.ejectedValue.Set(0)
+	return false
+}
+
+// averageLatency returns the average latency of the other dopplers with
+// enough samples to compare against.
+func (t *HealthTracker) averageLatency(hostPort string) (float64, bool) {
+	var total float64
+	var n int
+	for hp, d := range t.dopplers {
+		if hp == hostPort || d.samples < t.minSamples || d.latency == 0 {
+			continue
+		}
+		total += d.latency
+		n++
+	}
+
+	if n == 0 {
+		return 0, false
+	}
+
+	return total / float64(n), true
+}
+
+func (t *HealthTracker) score(hostPort string) float64 {
+	d, ok := t.dopplers[hostPort]
+	if !ok {
+		return 0
+	}
+
+	errorRate := math.Min(d.errorRate, 0.99)
+	shedRate := math.Min(d.shedRate, 0.99)
+	return (d.latency + float64(time.Millisecond)) * float64(1+d.inFlight) / (1 - errorRate) / (1 - shedRate)
+}
+
+func (t *HealthTracker) doppler(hostPort string) *dopplerHealth {
+	d, ok := t.dopplers[hostPort]
+	if !ok {
+		prefix := "doppler_" + hostPort
+		d = &dopplerHealth{
+			errorRateValue: t.registry.RegisterValue(prefix + errorRateSuffix),
+			latencyValue:   t.registry.RegisterValue(prefix + latencySuffix),
+			inFlightValue:  t.registry.RegisterValue(prefix + inFlightSuffix),
+			shedValue:      t.registry.RegisterValue(prefix + shedSuffix),
+			ejectedValue:   t.registry.RegisterValue(prefix + ejectedSuffix),
+		}
+		t.dopplers[hostPort] = d
+	}
+
+	return d
+}
+
+// ewma returns the exponentially weighted moving average. The first samples
+// are averaged evenly so that a single early sample does not dominate.
+func ewma(avg, sample float64, n int64) float64 {
+	weight := math.Max(decay, 1/float64(n))
+	return avg + weight*(sample-avg)
+}
@@ -0,0 +1,138 @@
+package v2_test
+
+import (
+	"errors"
+	"math"
+	"time"
+
+	clientpool "metron/internal/clientpool/v2"
+	"metron/internal/health"
+	plumbing "plumbing/v2"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("HealthTracker", func() {
+	var (
+		registry *health.Registry
+		tracker  *clientpool.HealthTracker
+	)
+
+	BeforeEach(func() {
+		registry = health.NewRegistry()
+		tracker = clientpool.NewHealthTracker(
+			registry,
+			clientpool.WithMinSamples(5),
+			clientpool.WithMaxErrorRate(0.5),
+			clientpool.WithEjectionDuration(time.Minute),
+		)
+	})
+
+	It("prefers dopplers with fewer errors", func() {
+		for i := 0; i < 4; i++ {
+			tracker.Track("10.0.0.1:8082")(errors.New("some-error"))
+			tracker.Track("10.0.0.2:8082")(nil)
+		}
+
+		for i := 0; i < 10; i++ {
+			Expect(tracker.Choose([]string{"10.0.0.1:8082", "10.0.0.2:8082"})).To(Equal("10.0.0.2:8082"))
+		}
+	})
+
+	It("prefers dopplers with fewer in-flight batches", func() {
+		tracker.Track("10.0.0.1:8082")
+		tracker.Track("10.0.0.1:8082")
+
+		Expect(tracker.Choose([]string{"10.0.0.1:8082", "10.0.0.2:8082"})).To(Equal("10.0.0.2:8082"))
+		Expect(registry.State()).To(HaveKeyWithValue("doppler_10.0.0.1:8082_in_flight", int64(2)))
+	})
+
+	It("prefers dopplers that shed fewer envelopes", func() {
+		tracker.Ack("10.0.0.1:8082", &plumbing.BatchAck{Batches: 1, Accepted: 100, Shed: 50})
+		tracker.Ack("10.0.0.2:8082", &plumbing.BatchAck{Batches: 1, Accepted: 100})
+
+		for i := 0; i < 10; i++ {
+			Expect(tracker.Choose([]string{"10.0.0.1:8082", "10.0.0.2:8082"})).To(Equal("10.0.0.2:8082"))
+		}
+		Expect(tracker.Ejected("10.0.0.1:8082")).To(BeFalse())
+		Expect(registry.State()).To(HaveKeyWithValue("doppler_10.0.0.1:8082_shed_percent", int64(50)))
+	})
+
+	It("ejects dopplers with a high error rate", func() {
+		for i := 0; i < 5; i++ {
+			tracker.Track("10.0.0.1:8082")(errors.New("some-error"))
+		}
+
+		Expect(tracker.Ejected("10.0.0.1:8082")).To(BeTrue())
+		Expect(tracker.Score("10.0.0.1:8082")).To(Equal(math.Inf(1)))
+		Expect(registry.State()).To(HaveKeyWithValue("doppler_10.0.0.1:8082_ejected", int64(1)))
+
+		for i := 0; i < 10; i++ {
+			Expect(tracker.Choose([]string{"10.0.0.1:8082", "10.0.0.2:8082"})).To(Equal("10.0.0.2:8082"))
+		}
+	})
+
+	It("does not eject dopplers before enough samples are seen", func() {
+		for i := 0; i < 4; i++ {
+			tracker.Track("10.0.0.1:8082")(errors.New("some-error"))
+		}
+
+		Expect(tracker.Ejected("10.0.0.1:8082")).To(BeFalse())
+		Expect(registry.State()).To(HaveKeyWithValue("doppler_10.0.0.1:8082_error_rate_percent", int64(100)))
+	})
+
+	It("chooses an ejected doppler if every doppler is ejected", func() {
+		for i := 0; i < 5; i++ {
+			tracker.Track("10.0.0.1:8082")(errors.New("some-error"))
+		}
+
+		Expect(tracker.Choose([]string{"10.0.0.1:8082"})).To(Equal("10.0.0.1:8082"))
+	})
+
+	It("returns ejected dopplers once the ejection expires", func() {
+		tracker = clientpool.NewHealthTracker(
+			registry,
+			clientpool.WithMinSamples(5),
+			clientpool.WithEjectionDuration(10*time.Millisecond),
+		)
+		for i := 0; i < 5; i++ {
+			tracker.Track("10.0.0.1:8082")(errors.New("some-error"))
+		}
+		Expect(tracker.Ejected("10.0.0.1:8082")).To(BeTrue())
+
+		Eventually(func() bool {
+			return tracker.Ejected("10.0.0.1:8082")
+		}).Should(BeFalse())
+		Expect(registry.State()).To(HaveKeyWithValue("doppler_10.0.0.1:8082_ejected", int64(0)))
+	})
+
+	It("forgets dopplers that are not retained", func() {
+		tracker.Track("10.0.0.1:8082")(nil)
+		tracker.Track("10.0.0.2:8082")(nil)
+		tracker.Track("10.0.0.3:8082")
+
+		tracker.Retain([]string{"10.0.0.1:8082"})
+
+		state := registry.State()
+		Expect(state).To(HaveKey("doppler_10.0.0.1:8082_latency_ms"))
+		Expect(state).ToNot(HaveKey("doppler_10.0.0.2:8082_latency_ms"))
+		Expect(state).To(HaveKeyWithValue("doppler_10.0.0.3:8082_in_flight", int64(1)))
+	})
+
+	It("ejects dopplers that are much slower than the others", func() {
+		tracker = clientpool.NewHealthTracker(
+			registry,
+			clientpool.WithMinSamples(1),
+			clientpool.WithLatencyOutlierRatio(2),
+		)
+		tracker.Track("10.0.0.2:8082")(nil)
+
+		done := tracker.Track("10.0.0.1:8082")
+		time.Sleep(20 * time.Millisecond)
+		done(nil)
+
+		Expect(tracker.Ejected("10.0.0.1:8082")).To(BeTrue())
+		Expect(tracker.Ejected("10.0.0.2:8082")).To(BeFalse())
+	})
+})
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"metricemitter"
 	"metron/internal/health"
 	plumbing "plumbing/v2"
 
@@ -15,10 +16,19 @@ type HealthRegistry interface {
 	RegisterValue(name string) *health.Value
 }
 
+// AckHandler receives the acknowledgements doppler sends back on an
+// AckedBatchSender stream.
+type AckHandler interface {
+	Ack(hostPort string, ack *plumbing.BatchAck)
+}
+
 type SenderFetcher struct {
 	opts        []grpc.DialOption
 	connValue   *health.Value
 	streamValue *health.Value
+
+	acks       AckHandler
+	shedMetric *metricemitter.CounterMetric
 }
 
 func NewSenderFetcher(r HealthRegistry, opts ...grpc.DialOption) *SenderFetcher {
@@ -29,6 +39,24 @@ func NewSenderFetcher(r HealthRegistry, opts ...grpc.DialOption) *SenderFetcher
 	}
 }
 
+// NewAckingSenderFetcher returns a SenderFetcher that opens AckedBatchSender
+// streams instead of BatchSender streams. The acks sent back by doppler are
+// passed to h. Every doppler connected to must support AckedBatchSender.
+func NewAckingSenderFetcher(
+	r HealthRegistry,
+	h AckHandler,
+	metricClient metricemitter.MetricClient,
+	opts ...grpc.DialOption,
+) *SenderFetcher {
+	f := NewSenderFetcher(r, opts...)
+	f.acks = h
+	f.shedMetric = metricClient.NewCounterMetric("doppler_shed",
+		metricemitter.WithVersion(2, 0),
+	)
+
+	return f
+}
+
 func (p *SenderFetcher) Fetch(addr string) (io.Closer, plumbing.DopplerIngress_BatchSenderClient, error) {
 	conn, err := grpc.Dial(addr, p.opts...)
 	if err != nil {
@@ -39,7 +67,12 @@ func (p *SenderFetcher) Fetch(addr string) (io.Closer, plumbing.DopplerIngress_B
 	client := plumbing.NewDopplerIngressClient(conn)
 	log.Printf("successfully connected to doppler %s", addr)
 
-	sender, err := client.BatchSender(context.Background())
+	var sender plumbing.DopplerIngress_BatchSenderClient
+	if p.acks != nil {
+		sender, err = p.ackedSender(client, addr)
+	} else {
+		sender, err = client.BatchSender(context.Background())
+	}
 	if err != nil {
 		conn.Close()
 		return nil, nil, fmt.Errorf("error establishing ingestor stream to %s: %s", addr, err)
@@ -56,6 +89,47 @@ func (p *SenderFetcher) Fetch(addr string) (io.Closer, plumbing.DopplerIngress_B
 	return closer, sender, err
 }
 
+func (p *SenderFetcher) ackedSender(
+	client plumbing.DopplerIngressClient,
+	addr string,
+) (plumbing.DopplerIngress_BatchSenderClient, error) {
+	sender, err := client.AckedBatchSender(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	go p.readAcks(addr, sender)
+
+	return ackedSender{sender}, nil
+}
+
+// readAcks passes the acks sent by doppler to the AckHandler until the
+// stream is closed.
+func (p *SenderFetcher) readAcks(addr string, s plumbing.DopplerIngress_AckedBatchSenderClient) {
+	for {
+		ack, err := s.Recv()
+		if err != nil {
+			return
+		}
+
+		// metric-documentation-v2: (loggregator.metron.doppler_shed) Number
+		// of v2 envelopes sent to doppler that doppler reported shedding
+		p.shedMetric.Increment(ack.GetShed())
+
+		p.acks.Ack(addr, ack)
+	}
+}
+
+// ackedSender lets an AckedBatchSender stream be written to like a
+// BatchSender stream.
+type ackedSender struct {
+	plumbing.DopplerIngress_AckedBatchSenderClient
+}
+
+func (s ackedSender) CloseAndRecv() (*plumbing.BatchSenderResponse, error) {
+	return &plumbing.BatchSenderResponse{}, s.CloseSend()
+}
+
 type decrementingCloser struct {
 	closer      io.Closer
 	connValue   *health.Value
@@ -3,6 +3,7 @@ package v2_test
 import (
 	"net"
 
+	"metricemitter/testhelper"
 	"metron/internal/clientpool/v2"
 	"metron/internal/health"
 	plumbing "plumbing/v2"
@@ -60,6 +61,35 @@ var _ = Describe("PusherFetcher", func() {
 		Expect(registry.GetValue("doppler_v2_streams")).To(Equal(int64(0)))
 	})
 
+	It("passes acks from an acked stream to the handler", func() {
+		server := newSpyIngestorServer()
+		Expect(server.Start()).To(Succeed())
+		defer server.Stop()
+
+		handler := newSpyAckHandler()
+		metricClient := testhelper.NewMetricClient()
+		fetcher := v2.NewAckingSenderFetcher(
+			newSpyRegistry(),
+			handler,
+			metricClient,
+			grpc.WithInsecure(),
+		)
+		closer, sender, err := fetcher.Fetch(server.addr)
+		Expect(err).ToNot(HaveOccurred())
+		defer closer.Close()
+
+		err = sender.Send(&plumbing.EnvelopeBatch{
+			Batch: []*plumbing.Envelope{{SourceId: "a"}, {SourceId: "b"}},
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		Eventually(server.batch).Should(Receive())
+		Eventually(handler.hostPorts).Should(Receive(Equal(server.addr)))
+		Eventually(func() uint64 {
+			return metricClient.GetDelta("doppler_shed")
+		}).Should(Equal(uint64(1)))
+	})
+
 	It("returns an error when the server is unavailable", func() {
 		fetcher := v2.NewSenderFetcher(newSpyRegistry(), grpc.WithInsecure())
 		_, _, err := fetcher.Fetch("localhost:1122")
@@ -127,6 +157,37 @@ func (s *SpyIngestorServer) Stop() {
 	s.server.Stop()
 }
 
+// AckedBatchSender acks every batch, reporting one envelope shed.
+func (s *SpyIngestorServer) AckedBatchSender(srv plumbing.DopplerIngress_AckedBatchSenderServer) error {
+	for {
+		b, err := srv.Recv()
+		if err != nil {
+			return nil
+		}
+
+		s.batch <- b
+		srv.Send(&plumbing.BatchAck{
+			Batches:  1,
+			Accepted: uint64(len(b.GetBatch())),
+			Shed:     1,
+		})
+	}
+}
+
+type SpyAckHandler struct {
+	hostPorts chan string
+}
+
+func newSpyAckHandler() *SpyAckHandler {
+	return &SpyAckHandler{
+		hostPorts: make(chan string, 100),
+	}
+}
+
+func (s *SpyAckHandler) Ack(hostPort string, ack *plumbing.BatchAck) {
+	s.hostPorts <- hostPort
+}
+
 func (s *SpyIngestorServer) Sender(srv plumbing.DopplerIngress_SenderServer) error {
 	return nil
 }
@@ -0,0 +1,441 @@
+package v2
+
+import (
+	"log"
+	"math"
+	"math/rand"
+	"metricemitter"
+	"sort"
+	"sync"
+	"time"
+
+	plumbing "plumbing/v2"
+)
+
+// maxTimerSamples bounds the durations kept per timer per interval. Beyond
+// it the percentiles are estimated from a uniform sample.
+const maxTimerSamples = 10000
+
+// AllSourceIDs may be given to WithTimerSummaries to summarize the timers
+// of every source_id.
+const AllSourceIDs = "*"
+
+// Aggregator is a Writer that reduces the number of gauge and timer
+// envelopes written. Within each interval, gauges with the same name and
+// tags are collapsed to the latest value and timers from the configured
+// source_ids are rolled up into summary gauges. Aggregated envelopes are
+// written at the end of the interval; all others are written immediately.
+type Aggregator struct {
+	writer   Writer
+	interval time.Duration
+
+	dedupGauges      bool
+	timerSourceIDs   map[string]bool
+	summarizeAll     bool
+	dedupedMetric    *metricemitter.CounterMetric
+	summarizedMetric *metricemitter.CounterMetric
+	droppedMetric    *metricemitter.CounterMetric
+
+	stop     chan struct{}
+	stopOnce sync.Once
+
+	// gauges and timers are bucketed by a hash of the source_id,
+	// instance_id, names and tags that identify them. Those whose hashes
+	// collide share a bucket and are told apart by comparing them.
+	mu        sync.Mutex
+	lastFlush time.Time
+	gauges    map[uint64][]*plumbing.Envelope
+	timers    map[uint64][]*timerSummary
+
+	// pending holds the aggregates of an interval that failed to be
+	// written. They are written again with the next batch.
+	pending []*plumbing.Envelope
+
+	// out and held are reused by each Write.
+	out  []*plumbing.Envelope
+	held []*plumbing.Envelope
+}
+
+// AggregatorOption is a type that will manipulate an Aggregator
+type AggregatorOption func(*Aggregator)
+
+// WithGaugeDeduplication collapses gauges with the same name and tags to
+// the latest value within each interval.
+func WithGaugeDeduplication() AggregatorOption {
+	return func(a *Aggregator) {
+		a.dedupGauges = true
+	}
+}
+
+// WithTimerSummaries rolls the timers of the given source_ids into
+// per-interval summary gauges.
+func WithTimerSummaries(sourceIDs ...string) AggregatorOption {
+	return func(a *Aggregator) {
+		for _, id := range sourceIDs {
+			if id == AllSourceIDs {
+				a.summarizeAll = true
+			}
+			a.timerSourceIDs[id] = true
+		}
+	}
+}
+
+// NewAggregator returns an Aggregator that writes to w and flushes
+// aggregated envelopes every interval.
+func NewAggregator(
+	w Writer,
+	interval time.Duration,
+	metricClient metricemitter.MetricClient,
+	opts ...AggregatorOption,
+) *Aggregator {
+	a := &Aggregator{
+		writer:         w,
+		interval:       interval,
+		timerSourceIDs: make(map[string]bool),
+		lastFlush:      time.Now(),
+		gauges:         make(map[uint64][]*plumbing.Envelope),
+		timers:         make(map[uint64][]*timerSummary),
+		stop:           make(chan struct{}),
+		dedupedMetric: metricClient.NewCounterMetric("deduplicated_gauges",
+			metricemitter.WithVersion(2, 0),
+		),
+		summarizedMetric: metricClient.NewCounterMetric("summarized_timers",
+			metricemitter.WithVersion(2, 0),
+		),
+		droppedMetric: metricClient.NewCounterMetric("dropped",
+			metricemitter.WithVersion(2, 0),
+			metricemitter.WithTags(map[string]string{
+				"direction": "egress",
+				"source":    "aggregator",
+			}),
+		),
+	}
+
+	for _, o := range opts {
+		o(a)
+	}
+
+	go a.flushOnInterval()
+
+	return a
+}
+
+// Write holds back gauges and timers to be aggregated and writes the
+// remaining envelopes, along with the aggregates of any elapsed interval.
+// If the write fails nothing is aggregated, as the batch is retried or
+// spilled, and the aggregates are written again with the next batch.
+func (a *Aggregator) Write(msgs []*plumbing.Envelope) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	out, held := a.out[:0], a.held[:0]
+	defer func() {
+		a.out, a.held = clearEnvelopes(out), clearEnvelopes(held)
+	}()
+
+	for _, e := range msgs {
+		if a.holds(e) {
+			held = append(held, e)
+			continue
+		}
+		out = append(out, e)
+	}
+
+	if len(a.pending) == 0 && time.Since(a.lastFlush) >= a.interval {
+		a.pending = a.drain()
+	}
+	out = append(out, a.pending...)
+
+	if len(out) > 0 {
+		if err := a.writer.Write(out); err != nil {
+			return err
+		}
+	}
+	a.pending = nil
+
+	for _, e := range held {
+		if e.GetGauge() != nil {
+			a.addGauge(e)
+			continue
+		}
+		a.addTimer(e)
+	}
+
+	return nil
+}
+
+// clearEnvelopes empties the slice so that it does not keep the envelopes
+// alive.
+func clearEnvelopes(s []*plumbing.Envelope) []*plumbing.Envelope {
+	for i := range s {
+		s[i] = nil
+	}
+
+	return s[:0]
+}
+
+// Release passes the written batch on to the wrapped Writer if it is a
+// ReleasingWriter. Held gauges and timers are not counters and so are not
+// changed.
+func (a *Aggregator) Release(msgs []*plumbing.Envelope) {
+	if r, ok := a.writer.(ReleasingWriter); ok {
+		r.Release(msgs)
+	}
+}
+
+// holds reports whether the envelope is aggregated rather than written
+// immediately.
+func (a *Aggregator) holds(e *plumbing.Envelope) bool {
+	if e.GetGauge() != nil {
+		return a.dedupGauges
+	}
+
+	return e.GetTimer() != nil && a.summarizes(e.GetSourceId())
+}
+
+func (a *Aggregator) flushOnInterval() {
+	ticker := time.NewTicker(a.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-a.stop:
+			return
+		case <-ticker.C:
+		}
+
+		a.mu.Lock()
+		if len(a.pending) == 0 && time.Since(a.lastFlush) >= a.interval {
+			a.pending = a.drain()
+		}
+		a.writePending()
+		a.mu.Unlock()
+	}
+}
+
+// Stop stops the interval flushes and writes the aggregates held so far.
+// It returns the number of aggregated envelopes written and dropped.
+func (a *Aggregator) Stop() (written, dropped uint64) {
+	a.stopOnce.Do(func() {
+		close(a.stop)
+	})
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.pending = append(a.pending, a.drain()...)
+	return a.writePending()
+}
+
+// writePending writes the pending aggregates, dropping them if the write
+// fails. It must be called with a.mu held.
+func (a *Aggregator) writePending() (written, dropped uint64) {
+	if len(a.pending) == 0 {
+		return 0, 0
+	}
+
+	n := uint64(len(a.pending))
+	err := a.writer.Write(a.pending)
+	a.pending = nil
+	if err != nil {
+		// metric-documentation-v2: (loggregator.metron.dropped) Number of
+		// aggregated gauges and timer summaries dropped when failing to
+		// write them
+		a.droppedMetric.Increment(n)
+		log.Printf("failed to write aggregated envelopes: %s", err)
+		return 0, n
+	}
+
+	return n, 0
+}
+
+func (a *Aggregator) summarizes(sourceID string) bool {
+	return a.summarizeAll || a.timerSourceIDs[sourceID]
+}
+
+func (a *Aggregator) addGauge(e *plumbing.Envelope) {
+	h := gaugeHash(e)
+	bucket := a.gauges[h]
+	for i, g := range bucket {
+		if sameGauge(g, e) {
+			// metric-documentation-v2: (loggregator.metron.deduplicated_gauges)
+			// Number of gauge envelopes replaced by a later value within an
+			// aggregation interval
+			a.dedupedMetric.Increment(1)
+			bucket[i] = e
+			return
+		}
+	}
+	a.gauges[h] = append(bucket, e)
+}
+
+func (a *Aggregator) addTimer(e *plumbing.Envelope) {
+	// metric-documentation-v2: (loggregator.metron.summarized_timers)
+	// Number of timer envelopes rolled into summary gauges
+	a.summarizedMetric.Increment(1)
+
+	t := e.GetTimer()
+	h := timerHash(e)
+	for _, s := range a.timers[h] {
+		if s.matches(e) {
+			s.add(t.GetStop() - t.GetStart())
+			return
+		}
+	}
+
+	s := &timerSummary{
+		sourceID:   e.GetSourceId(),
+		instanceID: e.GetInstanceId(),
+		name:       t.GetName(),
+		tags:       copyTags(e.GetTags()),
+	}
+	s.add(t.GetStop() - t.GetStart())
+	a.timers[h] = append(a.timers[h], s)
+}
+
+// drain returns the aggregated envelopes and starts a new interval.
+func (a *Aggregator) drain() []*plumbing.Envelope {
+	a.lastFlush = time.Now()
+	if len(a.gauges) == 0 && len(a.timers) == 0 {
+		return nil
+	}
+
+	batch := make([]*plumbing.Envelope, 0, len(a.gauges)+len(a.timers))
+	for _, bucket := range a.gauges {
+		batch = append(batch, bucket...)
+	}
+
+	now := time.Now().UnixNano()
+	for _, bucket := range a.timers {
+		for _, s := range bucket {
+			batch = append(batch, s.envelope(now))
+		}
+	}
+
+	a.gauges = make(map[uint64][]*plumbing.Envelope)
+	a.timers = make(map[uint64][]*timerSummary)
+
+	return batch
+}
+
+type timerSummary struct {
+	sourceID   string
+	instanceID string
+	name       string
+	tags       map[string]*plumbing.Value
+
+	count     uint64
+	min, max  int64
+	durations []int64
+}
+
+// matches reports whether the timer envelope belongs to the summary.
+func (s *timerSummary) matches(e *plumbing.Envelope) bool {
+	return s.sourceID == e.GetSourceId() &&
+		s.instanceID == e.GetInstanceId() &&
+		s.name == e.GetTimer().GetName() &&
+		equalTags(s.tags, e.GetTags())
+}
+
+func (s *timerSummary) add(d int64) {
+	s.count++
+	if s.count == 1 || d < s.min {
+		s.min = d
+	}
+	if s.count == 1 || d > s.max {
+		s.max = d
+	}
+
+	if len(s.durations) < maxTimerSamples {
+		s.durations = append(s.durations, d)
+		return
+	}
+
+	// Reservoir sampling keeps a uniform sample of every duration seen.
+	if i := rand.Int63n(int64(s.count)); i < maxTimerSamples {
+		s.durations[i] = d
+	}
+}
+
+func (s *timerSummary) envelope(timestamp int64) *plumbing.Envelope {
+	sort.Sort(int64s(s.durations))
+
+	return &plumbing.Envelope{
+		SourceId:   s.sourceID,
+		InstanceId: s.instanceID,
+		Timestamp:  timestamp,
+		Tags:       s.tags,
+		Message: &plumbing.Envelope_Gauge{
+			Gauge: &plumbing.Gauge{
+				Metrics: map[string]*plumbing.GaugeValue{
+					s.name + "_count": {Unit: "count", Value: float64(s.count)},
+					s.name + "_min":   {Unit: "nanoseconds", Value: float64(s.min)},
+					s.name + "_max":   {Unit: "nanoseconds", Value: float64(s.max)},
+					s.name + "_p50":   {Unit: "nanoseconds", Value: s.percentile(0.50)},
+					s.name + "_p95":   {Unit: "nanoseconds", Value: s.percentile(0.95)},
+					s.name + "_p99":   {Unit: "nanoseconds", Value: s.percentile(0.99)},
+				},
+			},
+		},
+	}
+}
+
+// percentile uses the nearest-rank method on the sorted durations.
+func (s *timerSummary) percentile(p float64) float64 {
+	rank := int(math.Ceil(p*float64(len(s.durations)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+
+	return float64(s.durations[rank])
+}
+
+// gaugeHash hashes the source_id, instance_id, metric names and tags of a
+// gauge without allocating. The hashes of the names are mixed and summed
+// so that they do not need to be sorted.
+func gaugeHash(e *plumbing.Envelope) uint64 {
+	var names uint64
+	for name := range e.GetGauge().GetMetrics() {
+		names += mix(hashString(fnvOffset64, name))
+	}
+
+	return identityHash(e, names)
+}
+
+func timerHash(e *plumbing.Envelope) uint64 {
+	return identityHash(e, hashString(fnvOffset64, e.GetTimer().GetName()))
+}
+
+// identityHash combines h with the source_id, instance_id and tags of the
+// envelope.
+func identityHash(e *plumbing.Envelope, h uint64) uint64 {
+	h = hashUint64(h, hashTags(e.GetTags()))
+	h = hashString(hashByte(h, 0), e.GetSourceId())
+	return hashString(hashByte(h, 0), e.GetInstanceId())
+}
+
+// sameGauge reports whether the gauges have the same source_id,
+// instance_id, metric names and tags.
+func sameGauge(a, b *plumbing.Envelope) bool {
+	if a.GetSourceId() != b.GetSourceId() || a.GetInstanceId() != b.GetInstanceId() {
+		return false
+	}
+
+	am, bm := a.GetGauge().GetMetrics(), b.GetGauge().GetMetrics()
+	if len(am) != len(bm) {
+		return false
+	}
+	for name := range am {
+		if _, ok := bm[name]; !ok {
+			return false
+		}
+	}
+
+	return equalTags(a.GetTags(), b.GetTags())
+}
+
+type int64s []int64
+
+func (a int64s) Len() int           { return len(a) }
+func (a int64s) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a int64s) Less(i, j int) bool { return a[i] < a[j] }
@@ -0,0 +1,264 @@
+package v2_test
+
+import (
+	"errors"
+	"metricemitter/testhelper"
+	egress "metron/internal/egress/v2"
+	plumbing "plumbing/v2"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Aggregator", func() {
+	var (
+		writer *mockWriter
+		spy    *testhelper.SpyMetricClient
+	)
+
+	BeforeEach(func() {
+		writer = newMockWriter()
+		close(writer.WriteOutput.Ret0)
+		spy = testhelper.NewMetricClient()
+	})
+
+	It("writes other envelopes immediately", func() {
+		aggregator := egress.NewAggregator(
+			copyingWriter{writer},
+			time.Hour,
+			spy,
+			egress.WithGaugeDeduplication(),
+			egress.WithTimerSummaries(egress.AllSourceIDs),
+		)
+		log := logEnvelope("some-message")
+
+		Expect(aggregator.Write([]*plumbing.Envelope{log})).To(Succeed())
+
+		Expect(writer.WriteInput.Msg).To(Receive(Equal([]*plumbing.Envelope{log})))
+	})
+
+	Describe("gauges", func() {
+		It("collapses repeated gauges to the latest value", func() {
+			aggregator := egress.NewAggregator(
+				copyingWriter{writer},
+				50*time.Millisecond,
+				spy,
+				egress.WithGaugeDeduplication(),
+			)
+
+			aggregator.Write([]*plumbing.Envelope{
+				gaugeEnvelope("some-id", "cpu", 1),
+				gaugeEnvelope("some-id", "cpu", 2),
+			})
+			aggregator.Write([]*plumbing.Envelope{
+				gaugeEnvelope("some-id", "cpu", 3),
+				gaugeEnvelope("other-id", "cpu", 4),
+			})
+
+			var batch []*plumbing.Envelope
+			Eventually(writer.WriteInput.Msg).Should(Receive(&batch))
+			Expect(batch).To(HaveLen(2))
+
+			values := map[string]float64{}
+			for _, e := range batch {
+				values[e.GetSourceId()] = e.GetGauge().GetMetrics()["cpu"].GetValue()
+			}
+			Expect(values).To(Equal(map[string]float64{
+				"some-id":  3,
+				"other-id": 4,
+			}))
+			Expect(spy.GetDelta("deduplicated_gauges")).To(Equal(uint64(2)))
+		})
+
+		It("keeps gauges with different tags apart", func() {
+			aggregator := egress.NewAggregator(
+				copyingWriter{writer},
+				50*time.Millisecond,
+				spy,
+				egress.WithGaugeDeduplication(),
+			)
+
+			a := gaugeEnvelope("some-id", "cpu", 1)
+			a.Tags = map[string]*plumbing.Value{
+				"index": {Data: &plumbing.Value_Text{Text: "0"}},
+			}
+			b := gaugeEnvelope("some-id", "cpu", 2)
+			b.Tags = map[string]*plumbing.Value{
+				"index": {Data: &plumbing.Value_Text{Text: "1"}},
+			}
+			aggregator.Write([]*plumbing.Envelope{a, b})
+
+			Eventually(writer.WriteInput.Msg).Should(Receive(HaveLen(2)))
+		})
+
+		It("keeps gauges whose tags hash the same apart", func() {
+			aggregator := egress.NewAggregator(
+				copyingWriter{writer},
+				50*time.Millisecond,
+				spy,
+				egress.WithGaugeDeduplication(),
+			)
+
+			a := gaugeEnvelope("some-id", "cpu", 1)
+			a.Tags = collidingTags[0]
+			b := gaugeEnvelope("some-id", "cpu", 2)
+			b.Tags = collidingTags[1]
+			aggregator.Write([]*plumbing.Envelope{a, b})
+
+			Eventually(writer.WriteInput.Msg).Should(Receive(HaveLen(2)))
+			Expect(spy.GetDelta("deduplicated_gauges")).To(BeZero())
+		})
+
+		It("passes gauges through when deduplication is disabled", func() {
+			aggregator := egress.NewAggregator(copyingWriter{writer}, time.Hour, spy)
+			gauge := gaugeEnvelope("some-id", "cpu", 1)
+
+			aggregator.Write([]*plumbing.Envelope{gauge})
+
+			Expect(writer.WriteInput.Msg).To(Receive(Equal([]*plumbing.Envelope{gauge})))
+		})
+	})
+
+	Describe("timers", func() {
+		It("rolls timers into summary gauges", func() {
+			aggregator := egress.NewAggregator(
+				copyingWriter{writer},
+				50*time.Millisecond,
+				spy,
+				egress.WithTimerSummaries("router"),
+			)
+
+			var timers []*plumbing.Envelope
+			for i := int64(1); i <= 100; i++ {
+				timers = append(timers, durationEnvelope("router", "http", 0, i))
+			}
+			aggregator.Write(timers)
+
+			var batch []*plumbing.Envelope
+			Eventually(writer.WriteInput.Msg).Should(Receive(&batch))
+			Expect(batch).To(HaveLen(1))
+			Expect(batch[0].GetSourceId()).To(Equal("router"))
+
+			metrics := batch[0].GetGauge().GetMetrics()
+			Expect(metrics["http_count"].GetValue()).To(Equal(100.0))
+			Expect(metrics["http_min"].GetValue()).To(Equal(1.0))
+			Expect(metrics["http_max"].GetValue()).To(Equal(100.0))
+			Expect(metrics["http_p50"].GetValue()).To(Equal(50.0))
+			Expect(metrics["http_p95"].GetValue()).To(Equal(95.0))
+			Expect(metrics["http_p99"].GetValue()).To(Equal(99.0))
+			Expect(spy.GetDelta("summarized_timers")).To(Equal(uint64(100)))
+		})
+
+		It("passes through timers from other source ids", func() {
+			aggregator := egress.NewAggregator(
+				copyingWriter{writer},
+				time.Hour,
+				spy,
+				egress.WithTimerSummaries("router"),
+			)
+			timer := durationEnvelope("other", "http", 0, 10)
+
+			aggregator.Write([]*plumbing.Envelope{timer})
+
+			Expect(writer.WriteInput.Msg).To(Receive(Equal([]*plumbing.Envelope{timer})))
+		})
+	})
+
+	Describe("Stop()", func() {
+		It("writes the aggregates held so far", func() {
+			aggregator := egress.NewAggregator(
+				copyingWriter{writer},
+				time.Hour,
+				spy,
+				egress.WithGaugeDeduplication(),
+				egress.WithTimerSummaries(egress.AllSourceIDs),
+			)
+			aggregator.Write([]*plumbing.Envelope{
+				gaugeEnvelope("some-id", "cpu", 1),
+				durationEnvelope("some-id", "http", 0, 10),
+			})
+
+			written, dropped := aggregator.Stop()
+			Expect(written).To(Equal(uint64(2)))
+			Expect(dropped).To(BeZero())
+			Expect(writer.WriteInput.Msg).To(Receive(HaveLen(2)))
+		})
+
+		It("counts aggregates that fail to be written as dropped", func() {
+			aggregator := egress.NewAggregator(
+				&failingWriter{},
+				time.Hour,
+				spy,
+				egress.WithGaugeDeduplication(),
+			)
+			aggregator.Write([]*plumbing.Envelope{
+				gaugeEnvelope("some-id", "cpu", 1),
+				gaugeEnvelope("other-id", "cpu", 1),
+			})
+
+			written, dropped := aggregator.Stop()
+			Expect(written).To(BeZero())
+			Expect(dropped).To(Equal(uint64(2)))
+			Expect(spy.GetDelta("dropped")).To(Equal(uint64(2)))
+		})
+	})
+
+	It("does not aggregate a batch that fails to be written", func() {
+		writer := newMockWriter()
+		writer.WriteOutput.Ret0 <- errors.New("some-error")
+		close(writer.WriteOutput.Ret0)
+		aggregator := egress.NewAggregator(
+			copyingWriter{writer},
+			50*time.Millisecond,
+			spy,
+			egress.WithTimerSummaries("router"),
+		)
+
+		batch := []*plumbing.Envelope{logEnvelope("some-message")}
+		for i := int64(1); i <= 10; i++ {
+			batch = append(batch, durationEnvelope("router", "http", 0, i))
+		}
+		Expect(aggregator.Write(batch)).ToNot(Succeed())
+		Expect(aggregator.Write(batch)).To(Succeed())
+
+		Expect(writer.WriteInput.Msg).To(Receive(HaveLen(1)))
+		Expect(writer.WriteInput.Msg).To(Receive(HaveLen(1)))
+
+		var summary []*plumbing.Envelope
+		Eventually(writer.WriteInput.Msg).Should(Receive(&summary))
+		Expect(summary).To(HaveLen(1))
+		Expect(summary[0].GetGauge().GetMetrics()["http_count"].GetValue()).To(Equal(10.0))
+		Expect(spy.GetDelta("summarized_timers")).To(Equal(uint64(10)))
+	})
+})
+
+// collidingTags are tags that the egress package hashes the same: each
+// tag hashes its key, a separator, the type of its value and the value, so
+// both hash the bytes "a\x00tb\x00tc".
+var collidingTags = []map[string]*plumbing.Value{
+	{"a": {Data: &plumbing.Value_Text{Text: "b\x00tc"}}},
+	{"a\x00tb": {Data: &plumbing.Value_Text{Text: "c"}}},
+}
+
+func gaugeEnvelope(sourceID, name string, value float64) *plumbing.Envelope {
+	return &plumbing.Envelope{
+		SourceId: sourceID,
+		Message: &plumbing.Envelope_Gauge{
+			Gauge: &plumbing.Gauge{
+				Metrics: map[string]*plumbing.GaugeValue{
+					name: {Unit: "percentage", Value: value},
+				},
+			},
+		},
+	}
+}
+
+func durationEnvelope(sourceID, name string, start, stop int64) *plumbing.Envelope {
+	return &plumbing.Envelope{
+		SourceId: sourceID,
+		Message: &plumbing.Envelope_Timer{
+			Timer: &plumbing.Timer{Name: name, Start: start, Stop: stop},
+		},
+	}
+}
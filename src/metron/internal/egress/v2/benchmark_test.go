@@ -0,0 +1,199 @@
+package v2_test
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"metricemitter/testhelper"
+	egress "metron/internal/egress/v2"
+	plumbing "plumbing/v2"
+)
+
+// The benchmarks mirror the scenario of tools/benchmark/metron: counter
+// envelopes with one of ten names from the metron-benchmark origin. Run
+// them with -benchmem, or compare allocs/op, to catch allocations creeping
+// back into the v2 egress path.
+
+var benchmarkTags = map[string]string{
+	"deployment": "cf",
+	"job":        "router",
+	"index":      "0",
+	"ip":         "10.0.0.1",
+}
+
+func BenchmarkTransponderCounters(b *testing.B) {
+	b.ReportAllocs()
+
+	nexter := newBenchmarkNexter(b.N)
+	writer := newCountingWriter(b.N)
+	tx := egress.NewTransponder(
+		nexter,
+		egress.NewCounterAggregator(writer),
+		benchmarkTags,
+		100,
+		10*time.Millisecond,
+		testhelper.NewMetricClient(),
+		egress.WithMaxBatchBytes(1024*1024),
+	)
+
+	b.ResetTimer()
+	go tx.Start()
+	<-writer.done
+	b.StopTimer()
+
+	tx.Stop(context.Background())
+}
+
+func BenchmarkCounterAggregator(b *testing.B) {
+	b.ReportAllocs()
+
+	batch := benchmarkCounters(100)
+	deltas := counterValues(batch)
+	for _, e := range batch {
+		for k, v := range benchmarkTags {
+			e.Tags[k] = &plumbing.Value{
+				Data: &plumbing.Value_Text{Text: v},
+			}
+		}
+	}
+	aggregator := egress.NewCounterAggregator(newCountingWriter(b.N))
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		j := i % len(batch)
+		batch[j].GetCounter().Value = deltas[j]
+		aggregator.Write(batch[j : j+1])
+		aggregator.Release(batch[j : j+1])
+	}
+}
+
+func BenchmarkAggregatorGauges(b *testing.B) {
+	b.ReportAllocs()
+
+	batch := make([]*plumbing.Envelope, 10)
+	for i := range batch {
+		batch[i] = gaugeEnvelope("metron-benchmark", fmt.Sprintf("some-name-%d", i), 1)
+		batch[i].Tags = make(map[string]*plumbing.Value)
+		for k, v := range benchmarkTags {
+			batch[i].Tags[k] = &plumbing.Value{
+				Data: &plumbing.Value_Text{Text: v},
+			}
+		}
+	}
+	aggregator := egress.NewAggregator(
+		newCountingWriter(b.N),
+		time.Hour,
+		testhelper.NewMetricClient(),
+		egress.WithGaugeDeduplication(),
+	)
+	aggregator.Write(batch)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		j := i % len(batch)
+		aggregator.Write(batch[j : j+1])
+	}
+	b.StopTimer()
+
+	aggregator.Stop()
+}
+
+func benchmarkCounters(n int) []*plumbing.Envelope {
+	envelopes := make([]*plumbing.Envelope, n)
+	for i := range envelopes {
+		envelopes[i] = &plumbing.Envelope{
+			Timestamp: time.Now().UnixNano(),
+			Tags: map[string]*plumbing.Value{
+				"origin": {
+					Data: &plumbing.Value_Text{Text: "metron-benchmark"},
+				},
+			},
+			Message: &plumbing.Envelope_Counter{
+				Counter: &plumbing.Counter{
+					Name: fmt.Sprintf("some-name-%d", i%10),
+					Value: &plumbing.Counter_Delta{
+						Delta: uint64(i),
+					},
+				},
+			},
+		}
+	}
+
+	return envelopes
+}
+
+// counterValues returns the values of the counters so that they can be
+// restored once the CounterAggregator has replaced them with totals.
+func counterValues(envelopes []*plumbing.Envelope) []*plumbing.Counter_Delta {
+	values := make([]*plumbing.Counter_Delta, len(envelopes))
+	for i, e := range envelopes {
+		values[i] = e.GetCounter().Value.(*plumbing.Counter_Delta)
+	}
+
+	return values
+}
+
+// benchmarkNexter returns n envelopes, cycling through a fixed set. The
+// tags and totals added to an envelope are removed before it is returned
+// again so that every envelope is processed afresh.
+type benchmarkNexter struct {
+	remaining int
+	envelopes []*plumbing.Envelope
+	deltas    []*plumbing.Counter_Delta
+	i         int
+}
+
+func newBenchmarkNexter(n int) *benchmarkNexter {
+	envelopes := benchmarkCounters(1000)
+
+	return &benchmarkNexter{
+		remaining: n,
+		envelopes: envelopes,
+		deltas:    counterValues(envelopes),
+	}
+}
+
+func (n *benchmarkNexter) TryNext() (*plumbing.Envelope, bool) {
+	if n.remaining == 0 {
+		return nil, false
+	}
+	n.remaining--
+
+	j := n.i % len(n.envelopes)
+	n.i++
+
+	e := n.envelopes[j]
+	e.GetCounter().Value = n.deltas[j]
+	for k := range benchmarkTags {
+		delete(e.Tags, k)
+	}
+
+	return e, true
+}
+
+// countingWriter discards batches and closes done once n envelopes have
+// been written.
+type countingWriter struct {
+	n       int64
+	written int64
+	done    chan struct{}
+}
+
+func newCountingWriter(n int) *countingWriter {
+	return &countingWriter{
+		n:    int64(n),
+		done: make(chan struct{}),
+	}
+}
+
+func (w *countingWriter) Write(msgs []*plumbing.Envelope) error {
+	written := atomic.AddInt64(&w.written, int64(len(msgs)))
+	if written >= w.n && written-int64(len(msgs)) < w.n {
+		close(w.done)
+	}
+
+	return nil
+}
@@ -1,80 +1,236 @@
 package v2
 
 import (
-	"crypto/sha1"
-	"fmt"
-	"io"
-	"sort"
+	"math"
+	"sync"
 
 	plumbing "plumbing/v2"
 )
 
+const maxCounterTotals = 10000
+
+// counterTotalPool holds the values that replace counter deltas. Release
+// returns them once the envelopes holding them have been written.
+var counterTotalPool = sync.Pool{
+	New: func() interface{} {
+		return new(plumbing.Counter_Total)
+	},
+}
+
+// counterID buckets counters by name and a hash of their tags. Counters
+// whose tags collide share a bucket and are told apart by their tags.
 type counterID struct {
 	name     string
-	tagsHash string
+	tagsHash uint64
+}
+
+// counterTotal is the running total of a counter. next is the following
+// counter in the same bucket.
+type counterTotal struct {
+	tags  map[string]*plumbing.Value
+	total uint64
+	next  *counterTotal
 }
 
 type CounterAggregator struct {
 	writer        Writer
-	counterTotals map[counterID]uint64
+	counterTotals map[counterID]*counterTotal
+	size          int
 }
 
 func NewCounterAggregator(w Writer) *CounterAggregator {
 	return &CounterAggregator{
 		writer:        w,
-		counterTotals: make(map[counterID]uint64),
+		counterTotals: make(map[counterID]*counterTotal),
 	}
 }
 
 func (ca *CounterAggregator) Write(msgs []*plumbing.Envelope) error {
 	for i := range msgs {
-		if msgs[i].GetCounter() != nil {
-			if len(ca.counterTotals) > 10000 {
-				ca.resetTotals()
-			}
+		c := msgs[i].GetCounter()
+		if c == nil {
+			continue
+		}
 
-			id := counterID{
-				name:     msgs[i].GetCounter().Name,
-				tagsHash: hashTags(msgs[i].GetTags()),
-			}
+		if ca.size > maxCounterTotals {
+			ca.resetTotals()
+		}
 
-			ca.counterTotals[id] = ca.counterTotals[id] + msgs[i].GetCounter().GetDelta()
+		ct := ca.counterTotal(c.Name, msgs[i].GetTags())
+		ct.total += c.GetDelta()
 
-			msgs[i].GetCounter().Value = &plumbing.Counter_Total{
-				Total: ca.counterTotals[id],
-			}
+		if t, ok := c.Value.(*plumbing.Counter_Total); ok {
+			t.Total = ct.total
+			continue
 		}
+		t := counterTotalPool.Get().(*plumbing.Counter_Total)
+		t.Total = ct.total
+		c.Value = t
 	}
 
 	return ca.writer.Write(msgs)
 }
 
+// Release returns the totals of written counters to a pool to be reused by
+// later writes, leaving the counters without a value. The envelopes must
+// not be read or written again.
+func (ca *CounterAggregator) Release(msgs []*plumbing.Envelope) {
+	for _, e := range msgs {
+		c := e.GetCounter()
+		if c == nil {
+			continue
+		}
+
+		if t, ok := c.Value.(*plumbing.Counter_Total); ok {
+			c.Value = nil
+			counterTotalPool.Put(t)
+		}
+	}
+}
+
+// counterTotal returns the running total of the counter with the given name
+// and tags, adding one if there is none.
+func (ca *CounterAggregator) counterTotal(name string, tags map[string]*plumbing.Value) *counterTotal {
+	id := counterID{
+		name:     name,
+		tagsHash: hashTags(tags),
+	}
+
+	head := ca.counterTotals[id]
+	for ct := head; ct != nil; ct = ct.next {
+		if equalTags(ct.tags, tags) {
+			return ct
+		}
+	}
+
+	ct := &counterTotal{
+		tags: copyTags(tags),
+		next: head,
+	}
+	ca.counterTotals[id] = ct
+	ca.size++
+
+	return ct
+}
+
 func (ca *CounterAggregator) resetTotals() {
-	ca.counterTotals = make(map[counterID]uint64)
+	ca.counterTotals = make(map[counterID]*counterTotal)
+	ca.size = 0
 }
 
-func hashTags(tags map[string]*plumbing.Value) string {
-	hash := ""
-	elements := []mapElement{}
+// copyTags copies the tags so that later changes to the envelope's tags do
+// not change the counter they identify.
+func copyTags(tags map[string]*plumbing.Value) map[string]*plumbing.Value {
+	c := make(map[string]*plumbing.Value, len(tags))
 	for k, v := range tags {
-		elements = append(elements, mapElement{k, v.String()})
+		c[k] = copyValue(v)
 	}
-	sort.Sort(byKey(elements))
-	for _, element := range elements {
-		kHash, vHash := sha1.New(), sha1.New()
-		io.WriteString(kHash, element.k)
-		io.WriteString(vHash, element.v)
-		hash += fmt.Sprintf("%x%x", kHash.Sum(nil), vHash.Sum(nil))
+	return c
+}
+
+func copyValue(v *plumbing.Value) *plumbing.Value {
+	switch d := v.GetData().(type) {
+	case *plumbing.Value_Text:
+		return &plumbing.Value{Data: &plumbing.Value_Text{Text: d.Text}}
+	case *plumbing.Value_Integer:
+		return &plumbing.Value{Data: &plumbing.Value_Integer{Integer: d.Integer}}
+	case *plumbing.Value_Decimal:
+		return &plumbing.Value{Data: &plumbing.Value_Decimal{Decimal: d.Decimal}}
+	default:
+		return &plumbing.Value{}
 	}
-	return hash
 }
 
-type byKey []mapElement
+func equalTags(a, b map[string]*plumbing.Value) bool {
+	if len(a) != len(b) {
+		return false
+	}
 
-func (a byKey) Len() int           { return len(a) }
-func (a byKey) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
-func (a byKey) Less(i, j int) bool { return a[i].k < a[j].k }
+	for k, av := range a {
+		bv, ok := b[k]
+		if !ok || !equalValues(av, bv) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func equalValues(a, b *plumbing.Value) bool {
+	switch d := a.GetData().(type) {
+	case *plumbing.Value_Text:
+		bd, ok := b.GetData().(*plumbing.Value_Text)
+		return ok && d.Text == bd.Text
+	case *plumbing.Value_Integer:
+		bd, ok := b.GetData().(*plumbing.Value_Integer)
+		return ok && d.Integer == bd.Integer
+	case *plumbing.Value_Decimal:
+		bd, ok := b.GetData().(*plumbing.Value_Decimal)
+		return ok && math.Float64bits(d.Decimal) == math.Float64bits(bd.Decimal)
+	default:
+		return b.GetData() == nil
+	}
+}
+
+const (
+	fnvOffset64 = 14695981039346656037
+	fnvPrime64  = 1099511628211
+)
+
+// hashTags returns a hash of the tags that does not depend on the order
+// they are iterated in. Each tag is hashed with FNV-1a and the hashes are
+// mixed and summed, so the tags do not need to be sorted and nothing is
+// allocated.
+func hashTags(tags map[string]*plumbing.Value) uint64 {
+	var sum uint64
+	for k, v := range tags {
+		h := hashString(fnvOffset64, k)
+		h = hashByte(h, 0)
+		sum += mix(hashValue(h, v))
+	}
+	return sum
+}
+
+// hashValue hashes the type of the value as well as the value itself so
+// that, for example, the text "1" and the integer 1 differ.
+func hashValue(h uint64, v *plumbing.Value) uint64 {
+	switch d := v.GetData().(type) {
+	case *plumbing.Value_Text:
+		return hashString(hashByte(h, 't'), d.Text)
+	case *plumbing.Value_Integer:
+		return hashUint64(hashByte(h, 'i'), uint64(d.Integer))
+	case *plumbing.Value_Decimal:
+		return hashUint64(hashByte(h, 'd'), math.Float64bits(d.Decimal))
+	default:
+		return h
+	}
+}
+
+func hashString(h uint64, s string) uint64 {
+	for i := 0; i < len(s); i++ {
+		h = hashByte(h, s[i])
+	}
+	return h
+}
+
+func hashUint64(h, n uint64) uint64 {
+	for i := uint(0); i < 64; i += 8 {
+		h = hashByte(h, byte(n>>i))
+	}
+	return h
+}
+
+func hashByte(h uint64, b byte) uint64 {
+	return (h ^ uint64(b)) * fnvPrime64
+}
 
-type mapElement struct {
-	k, v string
+// mix spreads the bits of each tag's hash before they are summed, so that
+// similar tags do not cancel each other out.
+func mix(h uint64) uint64 {
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
 }
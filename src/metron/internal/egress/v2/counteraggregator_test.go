@@ -94,6 +94,63 @@ var _ = Describe("Counteraggregator", func() {
 		Expect(receivedEnvelope[0].GetCounter().GetTotal()).To(Equal(uint64(20)))
 	})
 
+	It("is unaffected by changes to the tags of written envelopes", func() {
+		mockWriter := newMockWriter()
+		close(mockWriter.WriteOutput.Ret0)
+
+		aggregator := egress.NewCounterAggregator(mockWriter)
+		first := buildCounterEnvelope(10, "name-1", "origin-1")
+		aggregator.Write(first)
+		first[0].Tags["origin"] = &plumbing.Value{
+			Data: &plumbing.Value_Text{Text: "origin-2"},
+		}
+		aggregator.Write(buildCounterEnvelope(15, "name-1", "origin-1"))
+
+		var receivedEnvelope []*plumbing.Envelope
+		Expect(mockWriter.WriteInput.Msg).To(Receive(&receivedEnvelope))
+		Expect(mockWriter.WriteInput.Msg).To(Receive(&receivedEnvelope))
+		Expect(receivedEnvelope).To(HaveLen(1))
+		Expect(receivedEnvelope[0].GetCounter().GetTotal()).To(Equal(uint64(25)))
+	})
+
+	It("keeps separate totals for counters whose tags hash the same", func() {
+		mockWriter := newMockWriter()
+		close(mockWriter.WriteOutput.Ret0)
+
+		aggregator := egress.NewCounterAggregator(mockWriter)
+		for i, delta := range []uint64{10, 15, 20} {
+			e := buildCounterEnvelope(delta, "name-1", "")
+			e[0].Tags = collidingTags[i%2]
+			aggregator.Write(e)
+		}
+
+		var receivedEnvelope []*plumbing.Envelope
+		Expect(mockWriter.WriteInput.Msg).To(Receive(&receivedEnvelope))
+		Expect(receivedEnvelope[0].GetCounter().GetTotal()).To(Equal(uint64(10)))
+		Expect(mockWriter.WriteInput.Msg).To(Receive(&receivedEnvelope))
+		Expect(receivedEnvelope[0].GetCounter().GetTotal()).To(Equal(uint64(15)))
+		Expect(mockWriter.WriteInput.Msg).To(Receive(&receivedEnvelope))
+		Expect(receivedEnvelope[0].GetCounter().GetTotal()).To(Equal(uint64(30)))
+	})
+
+	It("reuses the totals of released counters", func() {
+		mockWriter := newMockWriter()
+		close(mockWriter.WriteOutput.Ret0)
+
+		aggregator := egress.NewCounterAggregator(mockWriter)
+		first := buildCounterEnvelope(10, "name-1", "origin-1")
+		Expect(aggregator.Write(first)).To(Succeed())
+		aggregator.Release(first)
+		Expect(first[0].GetCounter().GetValue()).To(BeNil())
+
+		aggregator.Write(buildCounterEnvelope(15, "name-1", "origin-1"))
+
+		var receivedEnvelope []*plumbing.Envelope
+		Expect(mockWriter.WriteInput.Msg).To(Receive(&receivedEnvelope))
+		Expect(mockWriter.WriteInput.Msg).To(Receive(&receivedEnvelope))
+		Expect(receivedEnvelope[0].GetCounter().GetTotal()).To(Equal(uint64(25)))
+	})
+
 	It("calculations are unaffected for counter envelopes with total set", func() {
 		mockWriter := newMockWriter()
 		close(mockWriter.WriteOutput.Ret0)
@@ -0,0 +1,52 @@
+package v2
+
+import (
+	"metricemitter"
+	"strconv"
+)
+
+// histogram counts observations in cumulative buckets. Each bucket is a
+// counter metric tagged with its upper bound as le, as in a Prometheus
+// histogram. The last bucket is unbounded and counts every observation.
+type histogram struct {
+	bounds  []float64
+	buckets []*metricemitter.CounterMetric
+}
+
+func newHistogram(
+	metricClient metricemitter.MetricClient,
+	name string,
+	tags map[string]string,
+	bounds ...float64,
+) *histogram {
+	h := &histogram{bounds: bounds}
+
+	les := make([]string, 0, len(bounds)+1)
+	for _, b := range bounds {
+		les = append(les, strconv.FormatFloat(b, 'f', -1, 64))
+	}
+	les = append(les, "+Inf")
+
+	for _, le := range les {
+		bucketTags := map[string]string{"le": le}
+		for k, v := range tags {
+			bucketTags[k] = v
+		}
+
+		h.buckets = append(h.buckets, metricClient.NewCounterMetric(name,
+			metricemitter.WithVersion(2, 0),
+			metricemitter.WithTags(bucketTags),
+		))
+	}
+
+	return h
+}
+
+func (h *histogram) observe(v float64) {
+	for i, b := range h.bounds {
+		if v <= b {
+			h.buckets[i].Increment(1)
+		}
+	}
+	h.buckets[len(h.buckets)-1].Increment(1)
+}
@@ -0,0 +1,274 @@
+package v2
+
+import (
+	"fmt"
+	"metricemitter"
+	plumbing "plumbing/v2"
+	"regexp"
+	"strconv"
+)
+
+// Rule actions understood by the Pipeline.
+const (
+	ActionDrop      = "drop"
+	ActionAddTag    = "add_tag"
+	ActionRenameTag = "rename_tag"
+	ActionDeleteTag = "delete_tag"
+	ActionRedact    = "redact"
+)
+
+const defaultRedaction = "[REDACTED]"
+
+// RuleConfig describes a single rule of a Pipeline. Every non-empty match
+// field must match an envelope for the rule's action to be applied.
+type RuleConfig struct {
+	Name string
+
+	SourceID     string
+	EnvelopeType string
+	TagName      string
+	TagPattern   string
+
+	Action      string
+	Tag         string
+	Value       string
+	NewTag      string
+	Pattern     string
+	Replacement string
+}
+
+// matcher matches envelopes by source_id, type and tag. Empty fields match
+// every envelope.
+type matcher struct {
+	sourceID     string
+	envelopeType string
+	tagName      string
+	tagPattern   *regexp.Regexp
+}
+
+type rule struct {
+	matcher
+
+	apply      func(*plumbing.Envelope) bool
+	hitsMetric *metricemitter.CounterMetric
+}
+
+// Pipeline is an ordered list of rules that filter and rewrite envelopes
+// before they are batched for egress.
+type Pipeline struct {
+	rules []*rule
+}
+
+// NewPipeline builds a Pipeline from the given rule configs. It returns an
+// error for unknown actions or invalid patterns.
+func NewPipeline(configs []RuleConfig, metricClient metricemitter.MetricClient) (*Pipeline, error) {
+	p := &Pipeline{}
+	for i, c := range configs {
+		name := c.Name
+		if name == "" {
+			name = strconv.Itoa(i)
+		}
+
+		r, err := newRule(c)
+		if err != nil {
+			return nil, fmt.Errorf("invalid envelope rule %s: %s", name, err)
+		}
+
+		r.hitsMetric = metricClient.NewCounterMetric("envelope_rule_hits",
+			metricemitter.WithVersion(2, 0),
+			metricemitter.WithTags(map[string]string{
+				"rule":   name,
+				"action": c.Action,
+			}),
+		)
+		p.rules = append(p.rules, r)
+	}
+
+	return p, nil
+}
+
+// Process runs the envelope through every rule in order. It returns false
+// if the envelope should be dropped.
+func (p *Pipeline) Process(e *plumbing.Envelope) bool {
+	for _, r := range p.rules {
+		if !r.matches(e) {
+			continue
+		}
+
+		// metric-documentation-v2: (loggregator.metron.envelope_rule_hits)
+		// Number of envelopes matched by an egress envelope rule
+		r.hitsMetric.Increment(1)
+
+		if !r.apply(e) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func newMatcher(sourceID, envelopeType, tagName, tagPattern string) (matcher, error) {
+	m := matcher{
+		sourceID:     sourceID,
+		envelopeType: envelopeType,
+		tagName:      tagName,
+	}
+
+	switch envelopeType {
+	case "", "log", "counter", "gauge", "timer":
+	default:
+		return matcher{}, fmt.Errorf("unknown envelope type %q", envelopeType)
+	}
+
+	if tagPattern != "" {
+		if tagName == "" {
+			return matcher{}, fmt.Errorf("TagPattern requires TagName")
+		}
+
+		re, err := regexp.Compile(tagPattern)
+		if err != nil {
+			return matcher{}, err
+		}
+		m.tagPattern = re
+	}
+
+	return m, nil
+}
+
+func newRule(c RuleConfig) (*rule, error) {
+	m, err := newMatcher(c.SourceID, c.EnvelopeType, c.TagName, c.TagPattern)
+	if err != nil {
+		return nil, err
+	}
+	r := &rule{matcher: m}
+
+	switch c.Action {
+	case ActionDrop:
+		r.apply = func(*plumbing.Envelope) bool { return false }
+	case ActionAddTag:
+		if c.Tag == "" {
+			return nil, fmt.Errorf("%s requires Tag", c.Action)
+		}
+		r.apply = addTag(c.Tag, c.Value)
+	case ActionRenameTag:
+		if c.Tag == "" || c.NewTag == "" {
+			return nil, fmt.Errorf("%s requires Tag and NewTag", c.Action)
+		}
+		r.apply = renameTag(c.Tag, c.NewTag)
+	case ActionDeleteTag:
+		if c.Tag == "" {
+			return nil, fmt.Errorf("%s requires Tag", c.Action)
+		}
+		r.apply = deleteTag(c.Tag)
+	case ActionRedact:
+		if c.Pattern == "" {
+			return nil, fmt.Errorf("%s requires Pattern", c.Action)
+		}
+		re, err := regexp.Compile(c.Pattern)
+		if err != nil {
+			return nil, err
+		}
+		replacement := c.Replacement
+		if replacement == "" {
+			replacement = defaultRedaction
+		}
+		r.apply = redact(re, []byte(replacement))
+	default:
+		return nil, fmt.Errorf("unknown action %q", c.Action)
+	}
+
+	return r, nil
+}
+
+func (m matcher) matches(e *plumbing.Envelope) bool {
+	if m.sourceID != "" && m.sourceID != e.GetSourceId() {
+		return false
+	}
+
+	if m.envelopeType != "" && m.envelopeType != envelopeType(e) {
+		return false
+	}
+
+	if m.tagName != "" {
+		v, ok := e.GetTags()[m.tagName]
+		if !ok {
+			return false
+		}
+
+		if m.tagPattern != nil && !m.tagPattern.MatchString(valueString(v)) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func addTag(name, value string) func(*plumbing.Envelope) bool {
+	v := &plumbing.Value{
+		Data: &plumbing.Value_Text{
+			Text: value,
+		},
+	}
+
+	return func(e *plumbing.Envelope) bool {
+		if e.Tags == nil {
+			e.Tags = make(map[string]*plumbing.Value)
+		}
+		e.Tags[name] = v
+		return true
+	}
+}
+
+func renameTag(name, newName string) func(*plumbing.Envelope) bool {
+	return func(e *plumbing.Envelope) bool {
+		if v, ok := e.Tags[name]; ok {
+			delete(e.Tags, name)
+			e.Tags[newName] = v
+		}
+		return true
+	}
+}
+
+func deleteTag(name string) func(*plumbing.Envelope) bool {
+	return func(e *plumbing.Envelope) bool {
+		delete(e.Tags, name)
+		return true
+	}
+}
+
+func redact(re *regexp.Regexp, replacement []byte) func(*plumbing.Envelope) bool {
+	return func(e *plumbing.Envelope) bool {
+		if l := e.GetLog(); l != nil {
+			l.Payload = re.ReplaceAll(l.Payload, replacement)
+		}
+		return true
+	}
+}
+
+func envelopeType(e *plumbing.Envelope) string {
+	switch e.GetMessage().(type) {
+	case *plumbing.Envelope_Log:
+		return "log"
+	case *plumbing.Envelope_Counter:
+		return "counter"
+	case *plumbing.Envelope_Gauge:
+		return "gauge"
+	case *plumbing.Envelope_Timer:
+		return "timer"
+	default:
+		return ""
+	}
+}
+
+func valueString(v *plumbing.Value) string {
+	switch d := v.GetData().(type) {
+	case *plumbing.Value_Text:
+		return d.Text
+	case *plumbing.Value_Integer:
+		return strconv.FormatInt(d.Integer, 10)
+	case *plumbing.Value_Decimal:
+		return strconv.FormatFloat(d.Decimal, 'f', -1, 64)
+	default:
+		return ""
+	}
+}
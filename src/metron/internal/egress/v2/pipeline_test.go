@@ -0,0 +1,173 @@
+package v2_test
+
+import (
+	"metricemitter/testhelper"
+	egress "metron/internal/egress/v2"
+	v2 "plumbing/v2"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Pipeline", func() {
+	var spyMetrics *testhelper.SpyMetricClient
+
+	BeforeEach(func() {
+		spyMetrics = testhelper.NewMetricClient()
+	})
+
+	buildPipeline := func(rules ...egress.RuleConfig) *egress.Pipeline {
+		p, err := egress.NewPipeline(rules, spyMetrics)
+		Expect(err).ToNot(HaveOccurred())
+		return p
+	}
+
+	Describe("drop", func() {
+		It("drops envelopes by source_id", func() {
+			p := buildPipeline(egress.RuleConfig{
+				SourceID: "noisy",
+				Action:   egress.ActionDrop,
+			})
+
+			Expect(p.Process(&v2.Envelope{SourceId: "noisy"})).To(BeFalse())
+			Expect(p.Process(&v2.Envelope{SourceId: "quiet"})).To(BeTrue())
+			Expect(spyMetrics.GetDelta("envelope_rule_hits")).To(Equal(uint64(1)))
+		})
+
+		It("drops envelopes by envelope type", func() {
+			p := buildPipeline(egress.RuleConfig{
+				EnvelopeType: "timer",
+				Action:       egress.ActionDrop,
+			})
+
+			Expect(p.Process(timerEnvelope())).To(BeFalse())
+			Expect(p.Process(logEnvelope("some-log"))).To(BeTrue())
+		})
+
+		It("drops envelopes by tag pattern", func() {
+			p := buildPipeline(egress.RuleConfig{
+				TagName:    "job",
+				TagPattern: "^diego-",
+				Action:     egress.ActionDrop,
+			})
+
+			Expect(p.Process(taggedEnvelope("job", "diego-cell"))).To(BeFalse())
+			Expect(p.Process(taggedEnvelope("job", "router"))).To(BeTrue())
+			Expect(p.Process(&v2.Envelope{})).To(BeTrue())
+		})
+	})
+
+	Describe("tags", func() {
+		It("adds tags", func() {
+			p := buildPipeline(egress.RuleConfig{
+				Action: egress.ActionAddTag,
+				Tag:    "team",
+				Value:  "payments",
+			})
+
+			e := &v2.Envelope{}
+			Expect(p.Process(e)).To(BeTrue())
+			Expect(e.Tags["team"].GetText()).To(Equal("payments"))
+		})
+
+		It("renames tags", func() {
+			p := buildPipeline(egress.RuleConfig{
+				Action: egress.ActionRenameTag,
+				Tag:    "job",
+				NewTag: "component",
+			})
+
+			e := taggedEnvelope("job", "router")
+			Expect(p.Process(e)).To(BeTrue())
+			Expect(e.Tags).ToNot(HaveKey("job"))
+			Expect(e.Tags["component"].GetText()).To(Equal("router"))
+		})
+
+		It("deletes tags", func() {
+			p := buildPipeline(egress.RuleConfig{
+				Action: egress.ActionDeleteTag,
+				Tag:    "job",
+			})
+
+			e := taggedEnvelope("job", "router")
+			Expect(p.Process(e)).To(BeTrue())
+			Expect(e.Tags).ToNot(HaveKey("job"))
+		})
+	})
+
+	Describe("redact", func() {
+		It("replaces matches in log payloads", func() {
+			p := buildPipeline(egress.RuleConfig{
+				Action:  egress.ActionRedact,
+				Pattern: `Bearer [A-Za-z0-9\-._~+/]+=*`,
+			})
+
+			e := logEnvelope("Authorization: Bearer abc.def-123 sent")
+			Expect(p.Process(e)).To(BeTrue())
+			Expect(string(e.GetLog().Payload)).To(Equal("Authorization: [REDACTED] sent"))
+		})
+
+		It("uses the configured replacement", func() {
+			p := buildPipeline(egress.RuleConfig{
+				Action:      egress.ActionRedact,
+				Pattern:     `\d{4}-\d{4}-\d{4}-\d{4}`,
+				Replacement: "xxxx",
+			})
+
+			e := logEnvelope("card 1234-5678-9012-3456")
+			Expect(p.Process(e)).To(BeTrue())
+			Expect(string(e.GetLog().Payload)).To(Equal("card xxxx"))
+		})
+	})
+
+	It("applies rules in order", func() {
+		p := buildPipeline(
+			egress.RuleConfig{
+				Action: egress.ActionRenameTag,
+				Tag:    "job",
+				NewTag: "component",
+			},
+			egress.RuleConfig{
+				TagName: "job",
+				Action:  egress.ActionDrop,
+			},
+		)
+
+		Expect(p.Process(taggedEnvelope("job", "router"))).To(BeTrue())
+	})
+
+	It("rejects invalid rules", func() {
+		_, err := egress.NewPipeline([]egress.RuleConfig{{Action: "explode"}}, spyMetrics)
+		Expect(err).To(HaveOccurred())
+
+		_, err = egress.NewPipeline([]egress.RuleConfig{{Action: egress.ActionRedact, Pattern: "("}}, spyMetrics)
+		Expect(err).To(HaveOccurred())
+
+		_, err = egress.NewPipeline([]egress.RuleConfig{{EnvelopeType: "event", Action: egress.ActionDrop}}, spyMetrics)
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+func logEnvelope(payload string) *v2.Envelope {
+	return &v2.Envelope{
+		Message: &v2.Envelope_Log{
+			Log: &v2.Log{Payload: []byte(payload)},
+		},
+	}
+}
+
+func timerEnvelope() *v2.Envelope {
+	return &v2.Envelope{
+		Message: &v2.Envelope_Timer{
+			Timer: &v2.Timer{Name: "some-timer"},
+		},
+	}
+}
+
+func taggedEnvelope(name, value string) *v2.Envelope {
+	return &v2.Envelope{
+		Tags: map[string]*v2.Value{
+			name: {Data: &v2.Value_Text{Text: value}},
+		},
+	}
+}
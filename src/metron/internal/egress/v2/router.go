@@ -0,0 +1,108 @@
+package v2
+
+import (
+	"fmt"
+	"metricemitter"
+	plumbing "plumbing/v2"
+	"strconv"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// Setter receives the envelopes routed to a pool.
+type Setter interface {
+	Set(e *plumbing.Envelope)
+}
+
+// RouteConfig sends the envelopes matching it to the named pool. Every
+// non-empty match field must match an envelope. When Copy is set matching
+// envelopes are also sent to the default pool.
+type RouteConfig struct {
+	Pool string
+
+	SourceID     string
+	EnvelopeType string
+	TagName      string
+	TagPattern   string
+
+	Copy bool
+}
+
+type route struct {
+	matcher
+
+	setter       Setter
+	copy         bool
+	routedMetric *metricemitter.CounterMetric
+}
+
+// Router is a Setter that sends each envelope to the pool of the first
+// route it matches, or to the default pool when it matches none. Each pool
+// is expected to be drained by its own Transponder so that a slow pool does
+// not hold up the others.
+type Router struct {
+	routes   []*route
+	fallback Setter
+}
+
+// NewRouter builds a Router from the given route configs. Envelopes that
+// match no route are set on fallback. It returns an error for routes to
+// unknown pools or with invalid patterns.
+func NewRouter(
+	configs []RouteConfig,
+	pools map[string]Setter,
+	fallback Setter,
+	metricClient metricemitter.MetricClient,
+) (*Router, error) {
+	r := &Router{fallback: fallback}
+	for i, c := range configs {
+		setter, ok := pools[c.Pool]
+		if !ok {
+			return nil, fmt.Errorf("route %d: unknown pool %q", i, c.Pool)
+		}
+
+		m, err := newMatcher(c.SourceID, c.EnvelopeType, c.TagName, c.TagPattern)
+		if err != nil {
+			return nil, fmt.Errorf("route %d: %s", i, err)
+		}
+
+		r.routes = append(r.routes, &route{
+			matcher: m,
+			setter:  setter,
+			copy:    c.Copy,
+			routedMetric: metricClient.NewCounterMetric("routed",
+				metricemitter.WithVersion(2, 0),
+				metricemitter.WithTags(map[string]string{
+					"route": strconv.Itoa(i),
+					"pool":  c.Pool,
+				}),
+			),
+		})
+	}
+
+	return r, nil
+}
+
+// Set sends the envelope to the pool of the first route it matches. Copied
+// envelopes are cloned so that each pool may modify its own.
+func (r *Router) Set(e *plumbing.Envelope) {
+	for _, rt := range r.routes {
+		if !rt.matches(e) {
+			continue
+		}
+
+		// metric-documentation-v2: (loggregator.metron.routed) Number of
+		// envelopes sent to an egress pool by a route
+		rt.routedMetric.Increment(1)
+
+		if !rt.copy {
+			rt.setter.Set(e)
+			return
+		}
+
+		rt.setter.Set(proto.Clone(e).(*plumbing.Envelope))
+		break
+	}
+
+	r.fallback.Set(e)
+}
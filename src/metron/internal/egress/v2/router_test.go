@@ -0,0 +1,117 @@
+package v2_test
+
+import (
+	"metricemitter/testhelper"
+	egress "metron/internal/egress/v2"
+	v2 "plumbing/v2"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Router", func() {
+	var (
+		spyMetrics *testhelper.SpyMetricClient
+		ops        *spySetter
+		tenant     *spySetter
+		fallback   *spySetter
+	)
+
+	BeforeEach(func() {
+		spyMetrics = testhelper.NewMetricClient()
+		ops = newSpySetter()
+		tenant = newSpySetter()
+		fallback = newSpySetter()
+	})
+
+	buildRouter := func(routes ...egress.RouteConfig) *egress.Router {
+		r, err := egress.NewRouter(routes, map[string]egress.Setter{
+			"ops":    ops,
+			"tenant": tenant,
+		}, fallback, spyMetrics)
+		Expect(err).ToNot(HaveOccurred())
+		return r
+	}
+
+	It("sends envelopes to the pool of the first matching route", func() {
+		r := buildRouter(
+			egress.RouteConfig{Pool: "ops", EnvelopeType: "gauge"},
+			egress.RouteConfig{Pool: "tenant", TagName: "app_id"},
+		)
+
+		gauge := &v2.Envelope{
+			Message: &v2.Envelope_Gauge{Gauge: &v2.Gauge{}},
+			Tags:    map[string]*v2.Value{"app_id": textValue("some-app")},
+		}
+		log := &v2.Envelope{
+			Message: &v2.Envelope_Log{Log: &v2.Log{}},
+			Tags:    map[string]*v2.Value{"app_id": textValue("some-app")},
+		}
+		r.Set(gauge)
+		r.Set(log)
+
+		Expect(ops.envelopes).To(ConsistOf(gauge))
+		Expect(tenant.envelopes).To(ConsistOf(log))
+		Expect(fallback.envelopes).To(BeEmpty())
+		Expect(spyMetrics.GetDelta("routed")).To(Equal(uint64(1)))
+	})
+
+	It("sends envelopes that match no route to the default pool", func() {
+		r := buildRouter(egress.RouteConfig{Pool: "ops", SourceID: "router"})
+
+		e := &v2.Envelope{SourceId: "app"}
+		r.Set(e)
+
+		Expect(ops.envelopes).To(BeEmpty())
+		Expect(fallback.envelopes).To(ConsistOf(e))
+	})
+
+	It("sends a copy to the route's pool in copy mode", func() {
+		r := buildRouter(egress.RouteConfig{Pool: "ops", SourceID: "router", Copy: true})
+
+		e := &v2.Envelope{SourceId: "router"}
+		r.Set(e)
+
+		Expect(fallback.envelopes).To(HaveLen(1))
+		Expect(fallback.envelopes[0]).To(BeIdenticalTo(e))
+		Expect(ops.envelopes).To(HaveLen(1))
+		Expect(ops.envelopes[0]).ToNot(BeIdenticalTo(e))
+		Expect(ops.envelopes[0].GetSourceId()).To(Equal("router"))
+	})
+
+	It("returns an error for routes to unknown pools", func() {
+		_, err := egress.NewRouter(
+			[]egress.RouteConfig{{Pool: "unknown"}},
+			nil,
+			fallback,
+			spyMetrics,
+		)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("returns an error for invalid routes", func() {
+		_, err := egress.NewRouter(
+			[]egress.RouteConfig{{Pool: "ops", EnvelopeType: "unknown"}},
+			map[string]egress.Setter{"ops": ops},
+			fallback,
+			spyMetrics,
+		)
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+type spySetter struct {
+	envelopes []*v2.Envelope
+}
+
+func newSpySetter() *spySetter {
+	return &spySetter{}
+}
+
+func (s *spySetter) Set(e *v2.Envelope) {
+	s.envelopes = append(s.envelopes, e)
+}
+
+func textValue(s string) *v2.Value {
+	return &v2.Value{Data: &v2.Value_Text{Text: s}}
+}
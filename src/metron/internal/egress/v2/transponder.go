@@ -1,28 +1,181 @@
 package v2
 
 import (
+	"context"
 	"log"
 	"metricemitter"
 	plumbing "plumbing/v2"
+	"reflect"
+	"sync/atomic"
 	"time"
+	"unicode/utf8"
+
+	"github.com/golang/protobuf/proto"
 )
 
 type Nexter interface {
 	TryNext() (*plumbing.Envelope, bool)
 }
 
+// WaitingNexter is a Nexter that can block until an envelope is available.
+// Transponders given a WaitingNexter do not need to poll for envelopes.
+type WaitingNexter interface {
+	Nexter
+	Next(ctx context.Context) (*plumbing.Envelope, bool)
+}
+
+// Writer writes batches of envelopes. The Transponder reuses the slice for
+// its next batch, so Write must not retain it once it returns. The envelopes
+// themselves may be retained.
 type Writer interface {
 	Write(msgs []*plumbing.Envelope) error
 }
 
+// StoppingWriter is a Writer that holds envelopes back. The Transponder
+// stops it once its own batches are flushed so that it writes them.
+type StoppingWriter interface {
+	Writer
+	Stop() (written, dropped uint64)
+}
+
+// ReleasingWriter is a Writer that reuses parts of the envelopes it has
+// written. The Transponder releases each batch once it has been written and
+// is no longer needed.
+type ReleasingWriter interface {
+	Writer
+	Release(msgs []*plumbing.Envelope)
+}
+
+// SpillBuffer stores batches that could not be written so that they can be
+// replayed once the writer recovers. Like Write, Spill must not retain the
+// batch. Replay is called after every successful write, so it should only
+// write a bounded number of batches each time.
+type SpillBuffer interface {
+	Spill(batch []*plumbing.Envelope) error
+	Replay(write func([]*plumbing.Envelope) error) error
+}
+
+// TagSource provides tags for the envelopes of a source_id. The returned
+// map is not modified. Returning the same map for as long as the tags of a
+// source_id are unchanged lets the Transponder reuse their values.
+type TagSource interface {
+	Tags(sourceID string) map[string]string
+}
+
+// maxCachedSourceIDs bounds the number of source_ids whose tag values are
+// cached.
+const maxCachedSourceIDs = 10000
+
+// tagValue is a tag whose value is shared by every envelope it is added to.
+// Values added by the Transponder must therefore never be modified.
+type tagValue struct {
+	key   string
+	value *plumbing.Value
+}
+
+// sourceTags are the tag values of a source_id, cached for as long as the
+// TagSource returns the same map.
+type sourceTags struct {
+	src    map[string]string
+	values []tagValue
+}
+
 type Transponder struct {
+	// batchInterval is a time.Duration and unsent the number of envelopes in
+	// the batch being written. Both are accessed atomically and kept first
+	// for 64-bit alignment.
+	batchInterval int64
+	unsent        int64
+
 	nexter        Nexter
 	writer        Writer
-	tags          map[string]string
+	tags          atomic.Value // []tagValue
+	tagSource     TagSource
+	sourceTags    map[string]sourceTags
 	batchSize     int
-	batchInterval time.Duration
+	maxBatchBytes int
+	spillBuffer   SpillBuffer
+	pipeline      *Pipeline
+	tee           Writer
+	metricTags    map[string]string
 	droppedMetric *metricemitter.CounterMetric
 	egressMetric  *metricemitter.CounterMetric
+
+	oversizedMetric *metricemitter.CounterMetric
+	batchBytesHist  *histogram
+	batchFillHist   *histogram
+
+	ctx     context.Context
+	cancel  context.CancelFunc
+	done    chan struct{}
+	pending []*plumbing.Envelope
+
+	// teed is the number of envelopes at the front of the in-progress batch
+	// already written to the tee.
+	teed int
+
+	// batchBytes is the encoded size of the in-progress batch. carry is an
+	// envelope that would have taken the batch over maxBatchBytes and starts
+	// the next batch instead.
+	batchBytes int
+	carry      *plumbing.Envelope
+	carryBytes int
+}
+
+// TransponderOption is a type that will manipulate a Transponder
+type TransponderOption func(*Transponder)
+
+// WithSpillBuffer configures the Transponder to spill batches that fail to
+// write into the given SpillBuffer instead of dropping them.
+func WithSpillBuffer(s SpillBuffer) TransponderOption {
+	return func(t *Transponder) {
+		t.spillBuffer = s
+	}
+}
+
+// WithPipeline configures the Transponder to run every envelope through the
+// given Pipeline before it is batched.
+func WithPipeline(p *Pipeline) TransponderOption {
+	return func(t *Transponder) {
+		t.pipeline = p
+	}
+}
+
+// WithTagSource configures the Transponder to add the tags provided by s
+// for each envelope's source_id. They take precedence over the static tags
+// but not over tags already on the envelope.
+func WithTagSource(s TagSource) TransponderOption {
+	return func(t *Transponder) {
+		t.tagSource = s
+	}
+}
+
+// WithTee configures the Transponder to also write every batch to w before
+// writing it to its Writer. Each envelope is written to w once, even when
+// writing to the Writer is retried. Errors from w are logged and ignored, so
+// w should not block.
+func WithTee(w Writer) TransponderOption {
+	return func(t *Transponder) {
+		t.tee = w
+	}
+}
+
+// WithMaxBatchBytes limits the encoded size of each batch. A batch is
+// written early rather than exceed it. Log envelopes too large for a batch
+// of their own have their payload truncated and other envelopes that are too
+// large are written alone. Batches are not limited by size by default.
+func WithMaxBatchBytes(n int) TransponderOption {
+	return func(t *Transponder) {
+		t.maxBatchBytes = n
+	}
+}
+
+// WithMetricTags adds the given tags to the Transponder's metrics. It is
+// used to tell apart Transponders writing to different destinations.
+func WithMetricTags(tags map[string]string) TransponderOption {
+	return func(t *Transponder) {
+		t.metricTags = tags
+	}
 }
 
 func NewTransponder(
@@ -32,63 +185,439 @@ func NewTransponder(
 	batchSize int,
 	batchInterval time.Duration,
 	metricClient metricemitter.MetricClient,
+	opts ...TransponderOption,
 ) *Transponder {
-	droppedMetric := metricClient.NewCounterMetric("dropped",
+	ctx, cancel := context.WithCancel(context.Background())
+	t := &Transponder{
+		ctx:           ctx,
+		cancel:        cancel,
+		done:          make(chan struct{}),
+		nexter:        n,
+		writer:        w,
+		batchSize:     batchSize,
+		batchInterval: int64(batchInterval),
+		sourceTags:    make(map[string]sourceTags),
+	}
+	t.SetTags(tags)
+
+	for _, o := range opts {
+		o(t)
+	}
+
+	droppedTags := map[string]string{"direction": "egress"}
+	for k, v := range t.metricTags {
+		droppedTags[k] = v
+	}
+	t.droppedMetric = metricClient.NewCounterMetric("dropped",
 		metricemitter.WithVersion(2, 0),
-		metricemitter.WithTags(map[string]string{"direction": "egress"}),
+		metricemitter.WithTags(droppedTags),
 	)
-
-	egressMetric := metricClient.NewCounterMetric("egress",
+	t.egressMetric = metricClient.NewCounterMetric("egress",
+		metricemitter.WithVersion(2, 0),
+		metricemitter.WithTags(t.metricTags),
+	)
+	t.oversizedMetric = metricClient.NewCounterMetric("oversized",
 		metricemitter.WithVersion(2, 0),
+		metricemitter.WithTags(t.metricTags),
+	)
+	t.batchBytesHist = newHistogram(metricClient, "batch_bytes", t.metricTags,
+		1<<10, 4<<10, 16<<10, 64<<10, 256<<10, 1<<20, 4<<20,
+	)
+	t.batchFillHist = newHistogram(metricClient, "batch_fill_percent", t.metricTags,
+		10, 25, 50, 75, 90, 100,
 	)
 
-	return &Transponder{
-		nexter:        n,
-		writer:        w,
-		tags:          tags,
-		batchSize:     batchSize,
-		batchInterval: batchInterval,
-		droppedMetric: droppedMetric,
-		egressMetric:  egressMetric,
-	}
+	return t
+}
+
+// SetTags replaces the static tags added to envelopes that do not already
+// have them. It is safe to call while the Transponder is running.
+func (t *Transponder) SetTags(tags map[string]string) {
+	t.tags.Store(toTagValues(tags))
+}
+
+// SetBatchInterval replaces the longest time envelopes wait to be written
+// when the batch is not full. It is safe to call while the Transponder is
+// running.
+func (t *Transponder) SetBatchInterval(d time.Duration) {
+	atomic.StoreInt64(&t.batchInterval, int64(d))
 }
 
 func (t *Transponder) Start() {
-	var batch []*plumbing.Envelope
+	defer close(t.done)
+
+	batch := make([]*plumbing.Envelope, 0, t.batchSize)
 	lastSent := time.Now()
 
-	for {
-		envelope, ok := t.nexter.TryNext()
-		if !ok && !t.batchReady(batch, lastSent) {
-			time.Sleep(10 * time.Millisecond)
-			continue
-		}
+	for t.ctx.Err() == nil {
+		if t.carry == nil {
+			envelope, ok := t.nexter.TryNext()
+			if !ok && !t.batchReady(batch, lastSent) {
+				envelope, ok = t.wait(batch, lastSent)
+				if !ok {
+					continue
+				}
+			}
 
-		if ok {
-			t.addTags(envelope)
-			batch = append(batch, envelope)
+			if ok && t.process(envelope) {
+				batch = t.add(batch, envelope)
+			}
 		}
 
 		if !t.batchReady(batch, lastSent) {
 			continue
 		}
 
+		t.teeBatch(batch)
+		atomic.StoreInt64(&t.unsent, int64(len(batch)))
 		err := t.writer.Write(batch)
+		if err != nil && t.spill(batch) {
+			batch = t.next(batch)
+			lastSent = time.Now()
+			continue
+		}
+
 		if err != nil {
 			// metric-documentation-v2: (loggregator.metron.dropped) Number of messages
 			// dropped when failing to write to Dopplers v2 API
 			t.droppedMetric.Increment(uint64(len(batch)))
 			log.Printf("v2 egress dropped: %s", err)
+			batch = t.next(batch)
+			lastSent = time.Now()
 			continue
 		}
 
 		// metric-documentation-v2: (loggregator.metron.egress)
 		// Number of messages written to Doppler's v2 API
 		t.egressMetric.Increment(uint64(len(batch)))
+		t.observe(batch)
+		t.release(batch)
 
-		batch = nil
+		batch = t.next(batch)
 		lastSent = time.Now()
+
+		t.replay()
+	}
+
+	t.pending = batch
+}
+
+// Stop stops the Transponder reading envelopes and flushes its in-progress
+// batch along with the envelopes remaining in the Nexter. Flushing gives up
+// once ctx is done. It returns the number of envelopes flushed and the
+// number abandoned. Spilled envelopes count as flushed as they are replayed
+// on the next start. If ctx is done before the Transponder stops, the batch
+// it was writing is counted as abandoned. A StoppingWriter is stopped once
+// the batches are flushed. The envelopes it held were counted as flushed
+// when written to it, so only those it drops are added as abandoned.
+func (t *Transponder) Stop(ctx context.Context) (flushed, abandoned uint64) {
+	t.cancel()
+
+	select {
+	case <-t.done:
+	case <-ctx.Done():
+		log.Print("timed out waiting for v2 transponder to stop")
+		abandoned = uint64(atomic.LoadInt64(&t.unsent))
+		return flushed, abandoned
+	}
+
+	if w, ok := t.writer.(StoppingWriter); ok {
+		defer func() {
+			_, dropped := w.Stop()
+			abandoned += dropped
+		}()
+	}
+
+	batch := t.pending
+	for {
+		for !t.full(batch) {
+			envelope, ok := t.nexter.TryNext()
+			if !ok {
+				break
+			}
+
+			if t.process(envelope) {
+				batch = t.add(batch, envelope)
+			}
+		}
+
+		if len(batch) == 0 {
+			return flushed, abandoned
+		}
+
+		t.teeBatch(batch)
+		if !t.flush(ctx, batch) {
+			abandoned += uint64(len(batch)) + t.discard()
+			if t.carry != nil {
+				abandoned++
+			}
+			return flushed, abandoned
+		}
+
+		flushed += uint64(len(batch))
+		batch = t.next(batch)
+	}
+}
+
+// flush writes the batch, retrying until ctx is done. Batches that cannot be
+// written are spilled if possible.
+func (t *Transponder) flush(ctx context.Context, batch []*plumbing.Envelope) bool {
+	for {
+		err := t.writer.Write(batch)
+		if err == nil {
+			t.egressMetric.Increment(uint64(len(batch)))
+			t.observe(batch)
+			t.release(batch)
+			return true
+		}
+
+		if t.spill(batch) {
+			return true
+		}
+
+		select {
+		case <-ctx.Done():
+			log.Printf("failed to flush v2 batch: %s", err)
+			return false
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+// release hands a written batch back to the Writer if it is a
+// ReleasingWriter.
+func (t *Transponder) release(batch []*plumbing.Envelope) {
+	if r, ok := t.writer.(ReleasingWriter); ok {
+		r.Release(batch)
+	}
+}
+
+// discard empties the Nexter and returns the number of envelopes discarded.
+func (t *Transponder) discard() uint64 {
+	var n uint64
+	for {
+		if _, ok := t.nexter.TryNext(); !ok {
+			return n
+		}
+		n++
+	}
+}
+
+// teeBatch writes the envelopes of the batch that have not yet been written
+// to the tee.
+func (t *Transponder) teeBatch(batch []*plumbing.Envelope) {
+	if t.tee == nil || t.teed >= len(batch) {
+		return
+	}
+
+	if err := t.tee.Write(batch[t.teed:]); err != nil {
+		log.Printf("failed to write v2 batch to tee: %s", err)
+	}
+	t.teed = len(batch)
+}
+
+func (t *Transponder) process(e *plumbing.Envelope) bool {
+	t.addTags(e)
+	return t.pipeline == nil || t.pipeline.Process(e)
+}
+
+// wait blocks until an envelope is available or the pending batch is due to
+// be flushed. Nexters that cannot block are polled.
+func (t *Transponder) wait(batch []*plumbing.Envelope, lastSent time.Time) (*plumbing.Envelope, bool) {
+	w, ok := t.nexter.(WaitingNexter)
+	if !ok {
+		time.Sleep(10 * time.Millisecond)
+		return nil, false
+	}
+
+	if len(batch) == 0 {
+		return w.Next(t.ctx)
+	}
+
+	ctx, cancel := context.WithDeadline(t.ctx, lastSent.Add(t.interval()))
+	defer cancel()
+
+	return w.Next(ctx)
+}
+
+func (t *Transponder) spill(batch []*plumbing.Envelope) bool {
+	if t.spillBuffer == nil {
+		return false
+	}
+
+	if err := t.spillBuffer.Spill(batch); err != nil {
+		log.Printf("failed to spill v2 batch: %s", err)
+		return false
+	}
+
+	return true
+}
+
+func (t *Transponder) replay() {
+	if t.spillBuffer == nil {
+		return
+	}
+
+	err := t.spillBuffer.Replay(func(batch []*plumbing.Envelope) error {
+		if err := t.writer.Write(batch); err != nil {
+			return err
+		}
+
+		// metric-documentation-v2: (loggregator.metron.egress)
+		// Number of messages written to Doppler's v2 API
+		t.egressMetric.Increment(uint64(len(batch)))
+		return nil
+	})
+	if err != nil {
+		log.Printf("failed to replay spilled v2 batches: %s", err)
+	}
+}
+
+// add appends the envelope to the batch unless it would take the batch over
+// maxBatchBytes, in which case it is carried over to the next batch.
+func (t *Transponder) add(batch []*plumbing.Envelope, e *plumbing.Envelope) []*plumbing.Envelope {
+	n := t.size(e)
+	if t.maxBatchBytes > 0 && len(batch) > 0 && t.batchBytes+n > t.maxBatchBytes {
+		t.carry = e
+		t.carryBytes = n
+		return batch
+	}
+
+	t.batchBytes += n
+	return append(batch, e)
+}
+
+// next empties the written batch for reuse and adds the envelope carried
+// over from it, if any.
+func (t *Transponder) next(batch []*plumbing.Envelope) []*plumbing.Envelope {
+	t.teed = 0
+	t.batchBytes = 0
+	atomic.StoreInt64(&t.unsent, 0)
+	batch = batch[:0]
+
+	if t.carry == nil {
+		return batch
+	}
+
+	batch = append(batch, t.carry)
+	t.batchBytes = t.carryBytes
+	t.carry = nil
+
+	return batch
+}
+
+// size returns the encoded size of the envelope within a batch. Log
+// envelopes too large for a batch of their own have their payload truncated
+// to fit and are tagged with truncated=true.
+func (t *Transponder) size(e *plumbing.Envelope) int {
+	if t.maxBatchBytes <= 0 {
+		return 0
+	}
+
+	n := batchEntrySize(e)
+	if n <= t.maxBatchBytes {
+		return n
+	}
+
+	// metric-documentation-v2: (loggregator.metron.oversized) Number of v2
+	// envelopes larger than the batch size limit. Logs are truncated to fit
+	// and other envelopes are written in a batch of their own.
+	t.oversizedMetric.Increment(1)
+
+	l := e.GetLog()
+	if l == nil {
+		return n
+	}
+
+	_, tagged := e.Tags["truncated"]
+	e.Tags["truncated"] = truncatedValue
+
+	excess := batchEntrySize(e) - t.maxBatchBytes
+	if excess >= len(l.Payload) {
+		if !tagged {
+			delete(e.Tags, "truncated")
+		}
+		return n
+	}
+
+	// Avoid splitting a multi-byte character.
+	cut := len(l.Payload) - excess
+	for cut > 0 && !utf8.RuneStart(l.Payload[cut]) {
+		cut--
+	}
+	l.Payload = l.Payload[:cut]
+
+	return batchEntrySize(e)
+}
+
+var truncatedValue = &plumbing.Value{
+	Data: &plumbing.Value_Text{Text: "true"},
+}
+
+// batchEntrySize returns the encoded size of the envelope as a field of an
+// EnvelopeBatch. The tags are sized here rather than by proto.Size, which
+// allocates when sizing maps.
+func batchEntrySize(e *plumbing.Envelope) int {
+	tags := e.Tags
+	e.Tags = nil
+	n := proto.Size(e)
+	e.Tags = tags
+
+	for k, v := range tags {
+		n += fieldSize(fieldSize(len(k)) + fieldSize(valueSize(v)))
 	}
+
+	return fieldSize(n)
+}
+
+// valueSize returns the encoded size of a tag value.
+func valueSize(v *plumbing.Value) int {
+	switch d := v.GetData().(type) {
+	case *plumbing.Value_Text:
+		return fieldSize(len(d.Text))
+	case *plumbing.Value_Integer:
+		return 1 + proto.SizeVarint(uint64(d.Integer))
+	case *plumbing.Value_Decimal:
+		return 1 + 8
+	default:
+		return 0
+	}
+}
+
+// fieldSize returns the encoded size of a length delimited field with a
+// field number below 16.
+func fieldSize(n int) int {
+	return 1 + proto.SizeVarint(uint64(n)) + n
+}
+
+// observe records the size of a written batch and how close it came to the
+// batch limits.
+func (t *Transponder) observe(batch []*plumbing.Envelope) {
+	fill := float64(len(batch)) / float64(t.batchSize)
+
+	if t.maxBatchBytes > 0 {
+		// metric-documentation-v2: (loggregator.metron.batch_bytes)
+		// Histogram of the encoded size of v2 batches written to Doppler
+		t.batchBytesHist.observe(float64(t.batchBytes))
+
+		if f := float64(t.batchBytes) / float64(t.maxBatchBytes); f > fill {
+			fill = f
+		}
+	}
+
+	// metric-documentation-v2: (loggregator.metron.batch_fill_percent)
+	// Histogram of how full v2 batches written to Doppler are, relative to
+	// the closer of the envelope count and byte size limits
+	t.batchFillHist.observe(fill * 100)
+}
+
+// full reports whether no more envelopes can be added to the batch.
+func (t *Transponder) full(batch []*plumbing.Envelope) bool {
+	if t.carry != nil || len(batch) >= t.batchSize {
+		return true
+	}
+
+	return t.maxBatchBytes > 0 && t.batchBytes >= t.maxBatchBytes
 }
 
 func (t *Transponder) batchReady(batch []*plumbing.Envelope, lastSent time.Time) bool {
@@ -96,20 +625,73 @@ func (t *Transponder) batchReady(batch []*plumbing.Envelope, lastSent time.Time)
 		return false
 	}
 
-	return len(batch) >= t.batchSize || time.Since(lastSent) >= t.batchInterval
+	return t.full(batch) || time.Since(lastSent) >= t.interval()
 }
 
+func (t *Transponder) interval() time.Duration {
+	return time.Duration(atomic.LoadInt64(&t.batchInterval))
+}
+
+// addTags adds the tag source and static tags missing from the envelope.
+// Their values are shared rather than allocated for each envelope.
 func (t *Transponder) addTags(e *plumbing.Envelope) {
+	var source []tagValue
+	if t.tagSource != nil {
+		source = t.sourceTagValues(e.GetSourceId())
+	}
+	static := t.tags.Load().([]tagValue)
+
 	if e.Tags == nil {
-		e.Tags = make(map[string]*plumbing.Value)
+		e.Tags = make(map[string]*plumbing.Value, len(source)+len(static))
+	}
+	setMissingTags(e, source)
+	setMissingTags(e, static)
+}
+
+// sourceTagValues returns the tag values for the source_id, converting the
+// tags from the TagSource only when it returns a different map than last
+// time.
+func (t *Transponder) sourceTagValues(sourceID string) []tagValue {
+	tags := t.tagSource.Tags(sourceID)
+	if len(tags) == 0 {
+		return nil
+	}
+
+	c, ok := t.sourceTags[sourceID]
+	if ok && reflect.ValueOf(c.src).Pointer() == reflect.ValueOf(tags).Pointer() {
+		return c.values
 	}
-	for k, v := range t.tags {
-		if _, ok := e.Tags[k]; !ok {
-			e.Tags[k] = &plumbing.Value{
+
+	if len(t.sourceTags) >= maxCachedSourceIDs {
+		t.sourceTags = make(map[string]sourceTags)
+	}
+
+	c = sourceTags{src: tags, values: toTagValues(tags)}
+	t.sourceTags[sourceID] = c
+
+	return c.values
+}
+
+func setMissingTags(e *plumbing.Envelope, tags []tagValue) {
+	for _, tv := range tags {
+		if _, ok := e.Tags[tv.key]; !ok {
+			e.Tags[tv.key] = tv.value
+		}
+	}
+}
+
+func toTagValues(tags map[string]string) []tagValue {
+	values := make([]tagValue, 0, len(tags))
+	for k, v := range tags {
+		values = append(values, tagValue{
+			key: k,
+			value: &plumbing.Value{
 				Data: &plumbing.Value_Text{
 					Text: v,
 				},
-			}
-		}
+			},
+		})
 	}
+
+	return values
 }
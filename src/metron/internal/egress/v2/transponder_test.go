@@ -1,10 +1,17 @@
 package v2_test
 
 import (
+	"context"
+	"diodes"
+	"errors"
 	"metricemitter/testhelper"
 	egress "metron/internal/egress/v2"
 	v2 "plumbing/v2"
+	"strings"
 	"time"
+	"unicode/utf8"
+
+	"github.com/golang/protobuf/proto"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -19,7 +26,7 @@ var _ = Describe("Transponder", func() {
 		writer := newMockWriter()
 		close(writer.WriteOutput.Ret0)
 
-		tx := egress.NewTransponder(nexter, writer, nil, 1, time.Nanosecond, testhelper.NewMetricClient())
+		tx := egress.NewTransponder(nexter, copyingWriter{writer}, nil, 1, time.Nanosecond, testhelper.NewMetricClient())
 		go tx.Start()
 
 		Eventually(nexter.TryNextCalled).Should(Receive())
@@ -38,7 +45,7 @@ var _ = Describe("Transponder", func() {
 				nexter.TryNextOutput.Ret1 <- true
 			}
 
-			tx := egress.NewTransponder(nexter, writer, nil, 5, time.Minute, testhelper.NewMetricClient())
+			tx := egress.NewTransponder(nexter, copyingWriter{writer}, nil, 5, time.Minute, testhelper.NewMetricClient())
 			go tx.Start()
 
 			var batch []*v2.Envelope
@@ -57,7 +64,7 @@ var _ = Describe("Transponder", func() {
 			close(nexter.TryNextOutput.Ret0)
 			close(nexter.TryNextOutput.Ret1)
 
-			tx := egress.NewTransponder(nexter, writer, nil, 5, time.Millisecond, testhelper.NewMetricClient())
+			tx := egress.NewTransponder(nexter, copyingWriter{writer}, nil, 5, time.Millisecond, testhelper.NewMetricClient())
 			go tx.Start()
 
 			var batch []*v2.Envelope
@@ -65,6 +72,42 @@ var _ = Describe("Transponder", func() {
 			Expect(batch).To(HaveLen(1))
 		})
 
+		It("releases batches once they are written", func() {
+			buffer := diodes.NewWaitingManyToOneEnvelopeV2(100, nil)
+			writer := newMockWriter()
+			close(writer.WriteOutput.Ret0)
+			releaser := &releasingWriter{
+				Writer:   copyingWriter{writer},
+				released: make(chan []*v2.Envelope, 100),
+			}
+
+			tx := egress.NewTransponder(buffer, releaser, nil, 1, time.Minute, testhelper.NewMetricClient())
+			go tx.Start()
+			defer tx.Stop(context.Background())
+
+			e := &v2.Envelope{SourceId: "uuid"}
+			buffer.Set(e)
+
+			Eventually(releaser.released).Should(Receive(Equal([]*v2.Envelope{e})))
+		})
+
+		It("uses the batch interval set while running", func() {
+			nexter := newMockNexter()
+			writer := newMockWriter()
+			close(writer.WriteOutput.Ret0)
+
+			tx := egress.NewTransponder(nexter, copyingWriter{writer}, nil, 5, time.Hour, testhelper.NewMetricClient())
+			tx.SetBatchInterval(time.Millisecond)
+			go tx.Start()
+
+			nexter.TryNextOutput.Ret0 <- &v2.Envelope{SourceId: "uuid"}
+			nexter.TryNextOutput.Ret1 <- true
+			close(nexter.TryNextOutput.Ret0)
+			close(nexter.TryNextOutput.Ret1)
+
+			Eventually(writer.WriteInput.Msg).Should(Receive(HaveLen(1)))
+		})
+
 		It("emits egress metric", func() {
 			envelope := &v2.Envelope{SourceId: "uuid"}
 			nexter := newMockNexter()
@@ -77,7 +120,7 @@ var _ = Describe("Transponder", func() {
 			}
 
 			spy := testhelper.NewMetricClient()
-			tx := egress.NewTransponder(nexter, writer, nil, 5, time.Minute, spy)
+			tx := egress.NewTransponder(nexter, copyingWriter{writer}, nil, 5, time.Minute, spy)
 			go tx.Start()
 
 			f := func() uint64 {
@@ -86,6 +129,277 @@ var _ = Describe("Transponder", func() {
 
 			Eventually(f).Should(Equal(uint64(5)))
 		})
+
+		It("drops batches that fail to write", func() {
+			buffer := diodes.NewWaitingManyToOneEnvelopeV2(100, nil)
+			buffer.Set(&v2.Envelope{SourceId: "uuid"})
+			buffer.Set(&v2.Envelope{SourceId: "uuid"})
+
+			spy := testhelper.NewMetricClient()
+			tx := egress.NewTransponder(buffer, &failingWriter{}, nil, 1, time.Minute, spy)
+			go tx.Start()
+			defer tx.Stop(context.Background())
+
+			f := func() uint64 {
+				return spy.GetDelta("dropped")
+			}
+
+			Eventually(f).Should(Equal(uint64(2)))
+			Consistently(f, 50*time.Millisecond).Should(Equal(uint64(2)))
+		})
+	})
+
+	Describe("byte limit", func() {
+		logEnvelope := func(payload string) *v2.Envelope {
+			return &v2.Envelope{
+				SourceId: "uuid",
+				Message: &v2.Envelope_Log{
+					Log: &v2.Log{Payload: []byte(payload)},
+				},
+			}
+		}
+
+		It("writes a batch early rather than exceed the limit", func() {
+			buffer := diodes.NewWaitingManyToOneEnvelopeV2(5, nil)
+			writer := newMockWriter()
+			close(writer.WriteOutput.Ret0)
+
+			for i := 0; i < 3; i++ {
+				buffer.Set(logEnvelope(strings.Repeat("x", 400)))
+			}
+
+			tx := egress.NewTransponder(buffer, copyingWriter{writer}, nil, 100, time.Minute, testhelper.NewMetricClient(),
+				egress.WithMaxBatchBytes(1000),
+			)
+			go tx.Start()
+
+			var batch []*v2.Envelope
+			Eventually(writer.WriteInput.Msg).Should(Receive(&batch))
+			Expect(batch).To(HaveLen(2))
+			Expect(proto.Size(&v2.EnvelopeBatch{Batch: batch})).To(BeNumerically("<=", 1000))
+		})
+
+		It("truncates logs too large for a batch of their own", func() {
+			buffer := diodes.NewWaitingManyToOneEnvelopeV2(5, nil)
+			writer := newMockWriter()
+			close(writer.WriteOutput.Ret0)
+
+			buffer.Set(logEnvelope(strings.Repeat("é", 1000)))
+
+			spy := testhelper.NewMetricClient()
+			tx := egress.NewTransponder(buffer, copyingWriter{writer}, nil, 100, time.Minute, spy,
+				egress.WithMaxBatchBytes(500),
+			)
+			go tx.Start()
+
+			var batch []*v2.Envelope
+			Eventually(writer.WriteInput.Msg).Should(Receive(&batch))
+			Expect(batch).To(HaveLen(1))
+			Expect(proto.Size(&v2.EnvelopeBatch{Batch: batch})).To(BeNumerically("<=", 500))
+			Expect(utf8.Valid(batch[0].GetLog().GetPayload())).To(BeTrue())
+			Expect(batch[0].GetTags()["truncated"].GetText()).To(Equal("true"))
+			Expect(spy.GetDelta("oversized")).To(Equal(uint64(1)))
+		})
+
+		It("writes other oversized envelopes in a batch of their own", func() {
+			buffer := diodes.NewWaitingManyToOneEnvelopeV2(5, nil)
+			writer := newMockWriter()
+			close(writer.WriteOutput.Ret0)
+
+			small := &v2.Envelope{SourceId: "small"}
+			big := &v2.Envelope{
+				SourceId: "big",
+				Message: &v2.Envelope_Counter{
+					Counter: &v2.Counter{Name: strings.Repeat("x", 1000)},
+				},
+			}
+			buffer.Set(small)
+			buffer.Set(big)
+
+			tx := egress.NewTransponder(buffer, copyingWriter{writer}, nil, 100, time.Minute, testhelper.NewMetricClient(),
+				egress.WithMaxBatchBytes(500),
+			)
+			go tx.Start()
+
+			Eventually(writer.WriteInput.Msg).Should(Receive(Equal([]*v2.Envelope{small})))
+			Eventually(writer.WriteInput.Msg).Should(Receive(Equal([]*v2.Envelope{big})))
+		})
+
+		It("records the size and fill of written batches", func() {
+			buffer := diodes.NewWaitingManyToOneEnvelopeV2(5, nil)
+			writer := newMockWriter()
+			close(writer.WriteOutput.Ret0)
+
+			buffer.Set(&v2.Envelope{SourceId: "uuid"})
+			buffer.Set(&v2.Envelope{SourceId: "uuid"})
+
+			spy := testhelper.NewMetricClient()
+			tx := egress.NewTransponder(buffer, copyingWriter{writer}, nil, 2, time.Minute, spy,
+				egress.WithMaxBatchBytes(1000),
+			)
+			go tx.Start()
+
+			Eventually(writer.WriteInput.Msg).Should(Receive())
+			Eventually(func() uint64 {
+				return spy.GetDelta("batch_fill_percent")
+			}).Should(Equal(uint64(1)))
+			Expect(spy.GetDelta("batch_bytes")).To(Equal(uint64(1)))
+		})
+	})
+
+	Describe("waiting nexter", func() {
+		It("writes envelopes set after it started waiting", func() {
+			buffer := diodes.NewWaitingManyToOneEnvelopeV2(5, nil)
+			writer := newMockWriter()
+			close(writer.WriteOutput.Ret0)
+
+			tx := egress.NewTransponder(buffer, copyingWriter{writer}, nil, 1, time.Minute, testhelper.NewMetricClient())
+			go tx.Start()
+
+			Consistently(writer.WriteInput.Msg, 50*time.Millisecond).ShouldNot(Receive())
+
+			envelope := &v2.Envelope{SourceId: "uuid"}
+			buffer.Set(envelope)
+			Eventually(writer.WriteInput.Msg).Should(Receive(Equal([]*v2.Envelope{envelope})))
+		})
+
+		It("emits a partial batch once the batch interval has been reached", func() {
+			buffer := diodes.NewWaitingManyToOneEnvelopeV2(5, nil)
+			writer := newMockWriter()
+			close(writer.WriteOutput.Ret0)
+
+			buffer.Set(&v2.Envelope{SourceId: "uuid"})
+
+			tx := egress.NewTransponder(buffer, copyingWriter{writer}, nil, 5, 10*time.Millisecond, testhelper.NewMetricClient())
+			go tx.Start()
+
+			var batch []*v2.Envelope
+			Eventually(writer.WriteInput.Msg).Should(Receive(&batch))
+			Expect(batch).To(HaveLen(1))
+		})
+	})
+
+	Describe("Stop()", func() {
+		It("flushes the in-progress batch and the buffered envelopes", func() {
+			buffer := diodes.NewWaitingManyToOneEnvelopeV2(100, nil)
+			writer := newMockWriter()
+			close(writer.WriteOutput.Ret0)
+
+			tx := egress.NewTransponder(buffer, copyingWriter{writer}, nil, 2, time.Minute, testhelper.NewMetricClient())
+			go tx.Start()
+
+			buffer.Set(&v2.Envelope{SourceId: "in-progress"})
+			Consistently(writer.WriteInput.Msg, 50*time.Millisecond).ShouldNot(Receive())
+
+			flushed, abandoned := tx.Stop(context.Background())
+			Expect(flushed).To(Equal(uint64(1)))
+			Expect(abandoned).To(BeZero())
+			Expect(writer.WriteInput.Msg).To(Receive(HaveLen(1)))
+		})
+
+		It("stops a StoppingWriter once the batches are flushed", func() {
+			buffer := diodes.NewWaitingManyToOneEnvelopeV2(100, nil)
+			writer := newMockWriter()
+			close(writer.WriteOutput.Ret0)
+			aggregator := egress.NewAggregator(
+				copyingWriter{writer},
+				time.Hour,
+				testhelper.NewMetricClient(),
+				egress.WithGaugeDeduplication(),
+			)
+
+			tx := egress.NewTransponder(buffer, aggregator, nil, 2, time.Minute, testhelper.NewMetricClient())
+			go tx.Start()
+			buffer.Set(gaugeEnvelope("some-id", "cpu", 1))
+
+			flushed, abandoned := tx.Stop(context.Background())
+			Expect(flushed).To(Equal(uint64(1)))
+			Expect(abandoned).To(BeZero())
+
+			var batch []*v2.Envelope
+			Expect(writer.WriteInput.Msg).To(Receive(&batch))
+			Expect(batch).To(HaveLen(1))
+			Expect(batch[0].GetGauge()).ToNot(BeNil())
+		})
+
+		It("counts the envelopes a StoppingWriter drops as abandoned", func() {
+			buffer := diodes.NewWaitingManyToOneEnvelopeV2(100, nil)
+			aggregator := egress.NewAggregator(
+				&failingWriter{},
+				time.Hour,
+				testhelper.NewMetricClient(),
+				egress.WithGaugeDeduplication(),
+			)
+
+			tx := egress.NewTransponder(buffer, aggregator, nil, 2, time.Minute, testhelper.NewMetricClient())
+			go tx.Start()
+			buffer.Set(gaugeEnvelope("some-id", "cpu", 1))
+
+			flushed, abandoned := tx.Stop(context.Background())
+			Expect(flushed).To(Equal(uint64(1)))
+			Expect(abandoned).To(Equal(uint64(1)))
+		})
+
+		It("abandons envelopes that cannot be written before the deadline", func() {
+			buffer := diodes.NewWaitingManyToOneEnvelopeV2(100, nil)
+			writer := &failingWriter{}
+
+			tx := egress.NewTransponder(buffer, copyingWriter{writer}, nil, 5, time.Minute, testhelper.NewMetricClient())
+			go tx.Start()
+			for i := 0; i < 3; i++ {
+				buffer.Set(&v2.Envelope{SourceId: "uuid"})
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			flushed, abandoned := tx.Stop(ctx)
+
+			Expect(flushed).To(BeZero())
+			Expect(abandoned).To(Equal(uint64(3)))
+		})
+
+		It("abandons the batch being written when it does not stop in time", func() {
+			buffer := diodes.NewWaitingManyToOneEnvelopeV2(100, nil)
+			writer := newMockWriter()
+			defer close(writer.WriteOutput.Ret0)
+
+			tx := egress.NewTransponder(buffer, copyingWriter{writer}, nil, 2, time.Minute, testhelper.NewMetricClient())
+			go tx.Start()
+			buffer.Set(&v2.Envelope{SourceId: "uuid"})
+			buffer.Set(&v2.Envelope{SourceId: "uuid"})
+			Eventually(writer.WriteInput.Msg).Should(Receive())
+
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			flushed, abandoned := tx.Stop(ctx)
+
+			Expect(flushed).To(BeZero())
+			Expect(abandoned).To(Equal(uint64(2)))
+		})
+
+		It("counts spilled envelopes as flushed", func() {
+			buffer := diodes.NewWaitingManyToOneEnvelopeV2(100, nil)
+			writer := newMockWriter()
+			writer.WriteOutput.Ret0 <- errors.New("some-error")
+			spy := newSpySpillBuffer()
+
+			tx := egress.NewTransponder(
+				buffer,
+				copyingWriter{writer},
+				nil,
+				5,
+				time.Minute,
+				testhelper.NewMetricClient(),
+				egress.WithSpillBuffer(spy),
+			)
+			go tx.Start()
+			buffer.Set(&v2.Envelope{SourceId: "uuid"})
+
+			flushed, abandoned := tx.Stop(context.Background())
+			Expect(flushed).To(Equal(uint64(1)))
+			Expect(abandoned).To(BeZero())
+			Expect(spy.spilled).To(Receive(HaveLen(1)))
+		})
 	})
 
 	Describe("tagging", func() {
@@ -101,7 +415,7 @@ var _ = Describe("Transponder", func() {
 			writer := newMockWriter()
 			close(writer.WriteOutput.Ret0)
 
-			tx := egress.NewTransponder(nexter, writer, tags, 1, time.Nanosecond, testhelper.NewMetricClient())
+			tx := egress.NewTransponder(nexter, copyingWriter{writer}, tags, 1, time.Nanosecond, testhelper.NewMetricClient())
 
 			go tx.Start()
 
@@ -135,7 +449,7 @@ var _ = Describe("Transponder", func() {
 			writer := newMockWriter()
 			close(writer.WriteOutput.Ret0)
 
-			tx := egress.NewTransponder(nexter, writer, tags, 1, time.Nanosecond, testhelper.NewMetricClient())
+			tx := egress.NewTransponder(nexter, copyingWriter{writer}, tags, 1, time.Nanosecond, testhelper.NewMetricClient())
 
 			go tx.Start()
 
@@ -147,5 +461,320 @@ var _ = Describe("Transponder", func() {
 
 			Expect(output[0].Tags["existing-tag"].GetText()).To(Equal("existing-value"))
 		})
+
+		It("adds the tags set while running", func() {
+			nexter := newMockNexter()
+			writer := newMockWriter()
+			close(writer.WriteOutput.Ret0)
+
+			tx := egress.NewTransponder(
+				nexter,
+				copyingWriter{writer},
+				map[string]string{"tag-one": "value-one"},
+				1,
+				time.Nanosecond,
+				testhelper.NewMetricClient(),
+			)
+			tx.SetTags(map[string]string{"tag-two": "value-two"})
+			go tx.Start()
+
+			nexter.TryNextOutput.Ret0 <- &v2.Envelope{SourceId: "uuid"}
+			nexter.TryNextOutput.Ret1 <- true
+
+			var output []*v2.Envelope
+			Eventually(writer.WriteInput.Msg).Should(Receive(&output))
+			Expect(output[0].Tags).ToNot(HaveKey("tag-one"))
+			Expect(output[0].Tags["tag-two"].GetText()).To(Equal("value-two"))
+		})
+
+		It("adds tags from the tag source in favor of the given tags", func() {
+			tags := map[string]string{
+				"team":   "platform",
+				"region": "us-east",
+			}
+			input := &v2.Envelope{SourceId: "payments-api"}
+			nexter := newMockNexter()
+			nexter.TryNextOutput.Ret0 <- input
+			nexter.TryNextOutput.Ret1 <- true
+			writer := newMockWriter()
+			close(writer.WriteOutput.Ret0)
+
+			tx := egress.NewTransponder(
+				nexter,
+				copyingWriter{writer},
+				tags,
+				1,
+				time.Nanosecond,
+				testhelper.NewMetricClient(),
+				egress.WithTagSource(staticTagSource{
+					"payments-api": {"team": "payments"},
+				}),
+			)
+
+			go tx.Start()
+
+			var output []*v2.Envelope
+			Eventually(writer.WriteInput.Msg).Should(Receive(&output))
+			Expect(output).To(HaveLen(1))
+
+			Expect(output[0].Tags["team"].GetText()).To(Equal("payments"))
+			Expect(output[0].Tags["region"].GetText()).To(Equal("us-east"))
+		})
+
+		It("uses new tags when the tag source changes", func() {
+			nexter := newMockNexter()
+			for i := 0; i < 2; i++ {
+				nexter.TryNextOutput.Ret0 <- &v2.Envelope{SourceId: "payments-api"}
+				nexter.TryNextOutput.Ret1 <- true
+			}
+			writer := newMockWriter()
+			close(writer.WriteOutput.Ret0)
+
+			tagSource := make(sequenceTagSource, 2)
+			tagSource <- map[string]string{"team": "payments"}
+			tagSource <- map[string]string{"team": "billing"}
+
+			tx := egress.NewTransponder(
+				nexter,
+				copyingWriter{writer},
+				nil,
+				1,
+				time.Nanosecond,
+				testhelper.NewMetricClient(),
+				egress.WithTagSource(tagSource),
+			)
+			go tx.Start()
+
+			var output []*v2.Envelope
+			Eventually(writer.WriteInput.Msg).Should(Receive(&output))
+			Expect(output[0].Tags["team"].GetText()).To(Equal("payments"))
+
+			Eventually(writer.WriteInput.Msg).Should(Receive(&output))
+			Expect(output[0].Tags["team"].GetText()).To(Equal("billing"))
+		})
+	})
+
+	Describe("pipeline", func() {
+		It("drops envelopes rejected by the pipeline", func() {
+			dropped := &v2.Envelope{SourceId: "dropped"}
+			kept := &v2.Envelope{SourceId: "kept"}
+			nexter := newMockNexter()
+			nexter.TryNextOutput.Ret0 <- dropped
+			nexter.TryNextOutput.Ret1 <- true
+			nexter.TryNextOutput.Ret0 <- kept
+			nexter.TryNextOutput.Ret1 <- true
+			writer := newMockWriter()
+			close(writer.WriteOutput.Ret0)
+
+			pipeline, err := egress.NewPipeline([]egress.RuleConfig{
+				{SourceID: "dropped", Action: egress.ActionDrop},
+			}, testhelper.NewMetricClient())
+			Expect(err).ToNot(HaveOccurred())
+
+			tx := egress.NewTransponder(
+				nexter,
+				copyingWriter{writer},
+				nil,
+				1,
+				time.Nanosecond,
+				testhelper.NewMetricClient(),
+				egress.WithPipeline(pipeline),
+			)
+			go tx.Start()
+
+			Eventually(writer.WriteInput.Msg).Should(Receive(Equal([]*v2.Envelope{kept})))
+		})
+	})
+
+	Describe("tee", func() {
+		It("writes each envelope to the tee once", func() {
+			buffer := diodes.NewWaitingManyToOneEnvelopeV2(100, nil)
+			tee := newSpyTee()
+
+			tx := egress.NewTransponder(
+				buffer,
+				&failingWriter{},
+				nil,
+				2,
+				time.Millisecond,
+				testhelper.NewMetricClient(),
+				egress.WithTee(tee),
+			)
+			go tx.Start()
+			buffer.Set(&v2.Envelope{SourceId: "first"})
+			Eventually(tee.batches).Should(Receive(HaveLen(1)))
+
+			buffer.Set(&v2.Envelope{SourceId: "second"})
+			var batch []*v2.Envelope
+			Eventually(tee.batches).Should(Receive(&batch))
+			Expect(batch).To(HaveLen(1))
+			Expect(batch[0].SourceId).To(Equal("second"))
+
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			tx.Stop(ctx)
+			Expect(tee.batches).ToNot(Receive())
+		})
+	})
+
+	Describe("spilling", func() {
+		It("spills batches that fail to write", func() {
+			envelope := &v2.Envelope{SourceId: "uuid"}
+			nexter := newMockNexter()
+			nexter.TryNextOutput.Ret0 <- envelope
+			nexter.TryNextOutput.Ret1 <- true
+			writer := newMockWriter()
+			writer.WriteOutput.Ret0 <- errors.New("some-error")
+			spy := newSpySpillBuffer()
+
+			tx := egress.NewTransponder(
+				nexter,
+				copyingWriter{writer},
+				nil,
+				1,
+				time.Nanosecond,
+				testhelper.NewMetricClient(),
+				egress.WithSpillBuffer(spy),
+			)
+			go tx.Start()
+
+			Eventually(spy.spilled).Should(Receive(Equal([]*v2.Envelope{envelope})))
+		})
+
+		It("replays spilled batches after a successful write", func() {
+			envelope := &v2.Envelope{SourceId: "uuid"}
+			nexter := newMockNexter()
+			nexter.TryNextOutput.Ret0 <- envelope
+			nexter.TryNextOutput.Ret1 <- true
+			writer := newMockWriter()
+			close(writer.WriteOutput.Ret0)
+			spy := newSpySpillBuffer()
+
+			tx := egress.NewTransponder(
+				nexter,
+				copyingWriter{writer},
+				nil,
+				1,
+				time.Nanosecond,
+				testhelper.NewMetricClient(),
+				egress.WithSpillBuffer(spy),
+			)
+			go tx.Start()
+
+			Eventually(spy.replayed).Should(Receive())
+		})
+
+		It("counts replayed envelopes as egress", func() {
+			nexter := newMockNexter()
+			nexter.TryNextOutput.Ret0 <- &v2.Envelope{SourceId: "uuid"}
+			nexter.TryNextOutput.Ret1 <- true
+			writer := newMockWriter()
+			close(writer.WriteOutput.Ret0)
+			spy := newSpySpillBuffer()
+			spy.pending = [][]*v2.Envelope{{{SourceId: "spilled"}, {SourceId: "spilled"}}}
+			metricClient := testhelper.NewMetricClient()
+
+			tx := egress.NewTransponder(
+				nexter,
+				copyingWriter{writer},
+				nil,
+				1,
+				time.Nanosecond,
+				metricClient,
+				egress.WithSpillBuffer(spy),
+			)
+			go tx.Start()
+
+			Eventually(spy.replayed).Should(Receive())
+			Eventually(writer.WriteInput.Msg).Should(Receive(HaveLen(2)))
+			Eventually(func() uint64 {
+				return metricClient.GetDelta("egress")
+			}).Should(Equal(uint64(3)))
+		})
 	})
 })
+
+type spySpillBuffer struct {
+	spilled  chan []*v2.Envelope
+	replayed chan bool
+
+	// pending are written by the first call to Replay.
+	pending [][]*v2.Envelope
+}
+
+func newSpySpillBuffer() *spySpillBuffer {
+	return &spySpillBuffer{
+		spilled:  make(chan []*v2.Envelope, 100),
+		replayed: make(chan bool, 100),
+	}
+}
+
+func (s *spySpillBuffer) Spill(batch []*v2.Envelope) error {
+	s.spilled <- append([]*v2.Envelope(nil), batch...)
+	return nil
+}
+
+func (s *spySpillBuffer) Replay(write func([]*v2.Envelope) error) error {
+	for _, batch := range s.pending {
+		if err := write(batch); err != nil {
+			return err
+		}
+	}
+	s.pending = nil
+
+	s.replayed <- true
+	return nil
+}
+
+type spyTee struct {
+	batches chan []*v2.Envelope
+}
+
+func newSpyTee() *spyTee {
+	return &spyTee{
+		batches: make(chan []*v2.Envelope, 100),
+	}
+}
+
+func (s *spyTee) Write(batch []*v2.Envelope) error {
+	s.batches <- append([]*v2.Envelope(nil), batch...)
+	return nil
+}
+
+// copyingWriter copies each batch before writing it, as the Transponder
+// reuses the batch once Write returns.
+type copyingWriter struct {
+	egress.Writer
+}
+
+func (w copyingWriter) Write(batch []*v2.Envelope) error {
+	return w.Writer.Write(append([]*v2.Envelope(nil), batch...))
+}
+
+type releasingWriter struct {
+	egress.Writer
+	released chan []*v2.Envelope
+}
+
+func (w *releasingWriter) Release(batch []*v2.Envelope) {
+	w.released <- append([]*v2.Envelope(nil), batch...)
+}
+
+type failingWriter struct{}
+
+func (w *failingWriter) Write([]*v2.Envelope) error {
+	return errors.New("some-error")
+}
+
+type staticTagSource map[string]map[string]string
+
+func (s staticTagSource) Tags(sourceID string) map[string]string {
+	return s[sourceID]
+}
+
+// sequenceTagSource returns the next map it is given for every source_id.
+type sequenceTagSource chan map[string]string
+
+func (s sequenceTagSource) Tags(sourceID string) map[string]string {
+	return <-s
+}
@@ -28,11 +28,22 @@ func (r *Registry) RegisterValue(name string) *Value {
 	return v
 }
 
+// UnregisterValue removes the value from the registry's state.
+func (r *Registry) UnregisterValue(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.values, name)
+}
+
 func (r *Registry) State() map[string]int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
 	state := make(map[string]int64)
 
 	for k, v := range r.values {
-		state[k] = v.number
+		state[k] = v.Number()
 	}
 
 	return state
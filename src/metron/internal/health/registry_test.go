@@ -35,6 +35,18 @@ var _ = Describe("Registry", func() {
 		// Expect no data race to occur
 	})
 
+	It("removes unregistered values from its state", func() {
+		registry := health.NewRegistry()
+
+		registry.RegisterValue("any_value")
+		registry.RegisterValue("other_value")
+		registry.UnregisterValue("any_value")
+
+		Expect(registry.State()).To(Equal(map[string]int64{
+			"other_value": 0,
+		}))
+	})
+
 	It("returns its current state", func() {
 		registry := health.NewRegistry()
 
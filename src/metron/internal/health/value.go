@@ -14,6 +14,10 @@ func (v *Value) Decrement(delta int64) {
 	v.Increment(-delta)
 }
 
+func (v *Value) Set(n int64) {
+	atomic.StoreInt64(&v.number, n)
+}
+
 func (v *Value) Number() int64 {
 	return atomic.LoadInt64(&v.number)
 }
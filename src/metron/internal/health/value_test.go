@@ -23,4 +23,13 @@ var _ = Describe("Value", func() {
 
 		Expect(value.Number()).To(Equal(int64(-3)))
 	})
+
+	It("sets the value", func() {
+		value := &health.Value{}
+		value.Increment(3)
+
+		value.Set(7)
+
+		Expect(value.Number()).To(Equal(int64(7)))
+	})
 })
@@ -0,0 +1,399 @@
+// Package httpingress receives v2 envelopes as JSON over HTTP.
+package httpingress
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"metricemitter"
+	"mime"
+	"net"
+	"net/http"
+	"time"
+
+	v2 "plumbing/v2"
+
+	"github.com/golang/protobuf/jsonpb"
+)
+
+// Path is the path envelopes are posted to.
+const Path = "/v2/envelopes"
+
+var (
+	// errTooLarge is returned when reading more than the maximum body size.
+	errTooLarge = errors.New("request body too large")
+
+	errNoMessage = errors.New("envelope has no log, counter, gauge or timer")
+)
+
+// DataSetter is the destination of the envelopes received by a Server.
+type DataSetter interface {
+	Set(e *v2.Envelope)
+}
+
+// Accepter is a DataSetter that reports whether it kept an envelope.
+// Envelopes it does not keep are counted as dropped.
+type Accepter interface {
+	DataSetter
+	Accept(e *v2.Envelope) bool
+}
+
+// Authorizer restricts the source_ids a client may use based on the
+// certificates it presented. The returned function reports whether an
+// envelope should be kept and may rewrite or tag it.
+type Authorizer interface {
+	ForCertificates(certs []*x509.Certificate) func(e *v2.Envelope) bool
+}
+
+// Response is the body of every response to a post of envelopes. Envelopes
+// that are not accepted are dropped.
+type Response struct {
+	Accepted int    `json:"accepted"`
+	Dropped  int    `json:"dropped"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Server receives envelopes posted to Path as the JSON form of a
+// plumbing/v2 Envelope or EnvelopeBatch, or as newline delimited Envelopes
+// when the content type is application/x-ndjson.
+//
+// It responds with 202 when at least one envelope was accepted, 400 when
+// none were, and 413 when the body exceeds the maximum size. The body of
+// every response is a Response.
+type Server struct {
+	setter       DataSetter
+	authorizer   Authorizer
+	maxBodyBytes int64
+	tlsConfig    *tls.Config
+	unmarshaler  jsonpb.Unmarshaler
+
+	listener net.Listener
+	server   *http.Server
+
+	receivedMetric *metricemitter.CounterMetric
+	invalidMetric  *metricemitter.CounterMetric
+}
+
+// ServerOption is a type that will manipulate a Server
+type ServerOption func(*Server)
+
+// WithMaxBodyBytes sets the largest request body accepted. It defaults to
+// 1MB. Newline delimited envelopes are limited to the same size each.
+func WithMaxBodyBytes(n int64) ServerOption {
+	return func(s *Server) {
+		s.maxBodyBytes = n
+	}
+}
+
+// WithTLSConfig serves HTTPS with the given config. Clients are required to
+// present a certificate when the config requires one.
+func WithTLSConfig(c *tls.Config) ServerOption {
+	return func(s *Server) {
+		s.tlsConfig = c
+	}
+}
+
+// WithAuthorizer drops, rewrites or tags the envelopes of clients that may
+// not use their source_id. Clients that do not present a certificate are
+// authorized as senders without one.
+func WithAuthorizer(a Authorizer) ServerOption {
+	return func(s *Server) {
+		s.authorizer = a
+	}
+}
+
+// NewServer listens for HTTP requests on the given address.
+func NewServer(
+	addr string,
+	setter DataSetter,
+	metricClient metricemitter.MetricClient,
+	opts ...ServerOption,
+) (*Server, error) {
+	s := &Server{
+		setter:       setter,
+		maxBodyBytes: 1024 * 1024,
+		unmarshaler:  jsonpb.Unmarshaler{AllowUnknownFields: true},
+		receivedMetric: metricClient.NewCounterMetric("http_received",
+			metricemitter.WithVersion(2, 0),
+		),
+		invalidMetric: metricClient.NewCounterMetric("http_invalid",
+			metricemitter.WithVersion(2, 0),
+		),
+	}
+
+	for _, o := range opts {
+		o(s)
+	}
+
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.tlsConfig != nil {
+		lis = tls.NewListener(lis, s.tlsConfig)
+	}
+	s.listener = lis
+
+	mux := http.NewServeMux()
+	mux.Handle(Path, s)
+	s.server = &http.Server{
+		Handler:      mux,
+		ReadTimeout:  30 * time.Second,
+		WriteTimeout: 30 * time.Second,
+	}
+
+	return s, nil
+}
+
+// Addr returns the address the server is listening on.
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// Start serves requests until the server is stopped.
+func (s *Server) Start() {
+	err := s.server.Serve(s.listener)
+	log.Printf("http ingress stopped: %s", err)
+}
+
+// Stop closes the listener and any open connections.
+func (s *Server) Stop() {
+	s.server.Close()
+}
+
+// ServeHTTP sets the envelopes in the request body on the DataSetter.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		s.respond(w, http.StatusMethodNotAllowed, Response{Error: "only POST is supported"})
+		return
+	}
+
+	body := &limitedReader{r: r.Body, n: s.maxBodyBytes}
+
+	var (
+		resp Response
+		err  error
+	)
+	set := s.set(r)
+	switch contentType(r) {
+	case "application/x-ndjson":
+		resp, err = s.readNDJSON(body, set)
+	case "application/json", "":
+		resp, err = s.readJSON(body, set)
+	default:
+		s.respond(w, http.StatusUnsupportedMediaType, Response{
+			Error: "content type must be application/json or application/x-ndjson",
+		})
+		return
+	}
+
+	// metric-documentation-v2: (loggregator.metron.http_received) Number of
+	// envelopes received over the HTTP ingress
+	s.receivedMetric.Increment(uint64(resp.Accepted))
+
+	if err != nil {
+		resp.Error = err.Error()
+		if isTooLarge(err) {
+			s.respond(w, http.StatusRequestEntityTooLarge, resp)
+			return
+		}
+	}
+
+	if resp.Accepted == 0 {
+		s.respond(w, http.StatusBadRequest, resp)
+		return
+	}
+
+	s.respond(w, http.StatusAccepted, resp)
+}
+
+// set returns a function that hands an envelope of the request to the
+// DataSetter if the client may use its source_id. It reports whether the
+// envelope was kept.
+func (s *Server) set(r *http.Request) func(e *v2.Envelope) bool {
+	authorize := func(*v2.Envelope) bool { return true }
+	if s.authorizer != nil {
+		var certs []*x509.Certificate
+		if r.TLS != nil {
+			certs = r.TLS.PeerCertificates
+		}
+		authorize = s.authorizer.ForCertificates(certs)
+	}
+
+	return func(e *v2.Envelope) bool {
+		if !authorize(e) {
+			return false
+		}
+
+		if a, ok := s.setter.(Accepter); ok {
+			return a.Accept(e)
+		}
+
+		s.setter.Set(e)
+		return true
+	}
+}
+
+// readJSON reads a single Envelope or an EnvelopeBatch.
+func (s *Server) readJSON(body io.Reader, set func(*v2.Envelope) bool) (Response, error) {
+	data, err := readAll(body)
+	if err != nil {
+		return Response{}, err
+	}
+
+	var resp Response
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		s.invalid(&resp)
+		return resp, err
+	}
+
+	batch, ok := fields["batch"]
+	if !ok {
+		return resp, s.setJSON(data, set, &resp)
+	}
+
+	var envelopes []json.RawMessage
+	if err := json.Unmarshal(batch, &envelopes); err != nil {
+		s.invalid(&resp)
+		return resp, err
+	}
+
+	var lastErr error
+	for _, data := range envelopes {
+		if err := s.setJSON(data, set, &resp); err != nil {
+			lastErr = err
+		}
+	}
+
+	return resp, lastErr
+}
+
+// readNDJSON reads an Envelope from each line. Blank lines are ignored.
+func (s *Server) readNDJSON(body io.Reader, set func(*v2.Envelope) bool) (Response, error) {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 64*1024), int(s.maxBodyBytes))
+
+	var (
+		resp    Response
+		lastErr error
+	)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		if err := s.setJSON(line, set, &resp); err != nil {
+			lastErr = err
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return resp, err
+	}
+
+	return resp, lastErr
+}
+
+// setJSON parses a JSON envelope and sets it, counting it in resp as
+// accepted or dropped. Envelopes that cannot be parsed or have no message
+// are dropped, as are those that set does not keep. Envelopes without a
+// timestamp are given the current time.
+func (s *Server) setJSON(data []byte, set func(*v2.Envelope) bool, resp *Response) error {
+	var e v2.Envelope
+	if err := s.unmarshaler.Unmarshal(bytes.NewReader(data), &e); err != nil {
+		s.invalid(resp)
+		return err
+	}
+
+	if e.GetMessage() == nil {
+		s.invalid(resp)
+		return errNoMessage
+	}
+
+	if e.Timestamp == 0 {
+		e.Timestamp = time.Now().UnixNano()
+	}
+
+	if !set(&e) {
+		resp.Dropped++
+		return nil
+	}
+	resp.Accepted++
+
+	return nil
+}
+
+// invalid counts an envelope that could not be parsed as dropped.
+func (s *Server) invalid(resp *Response) {
+	resp.Dropped++
+	// metric-documentation-v2: (loggregator.metron.http_invalid) Number of
+	// envelopes posted to the HTTP ingress that could not be parsed or had
+	// no message
+	s.invalidMetric.Increment(1)
+}
+
+func (s *Server) respond(w http.ResponseWriter, code int, resp Response) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.Printf("failed to write http ingress response: %s", err)
+	}
+}
+
+func contentType(r *http.Request) string {
+	t, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return ""
+	}
+
+	return t
+}
+
+func readAll(r io.Reader) ([]byte, error) {
+	var buf bytes.Buffer
+	if _, err := buf.ReadFrom(r); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// isTooLarge reports whether err was caused by a body or line exceeding
+// the maximum size.
+func isTooLarge(err error) bool {
+	return err == errTooLarge || err == bufio.ErrTooLong
+}
+
+// limitedReader reads up to n bytes from r and returns errTooLarge if r
+// has more.
+type limitedReader struct {
+	r io.Reader
+	n int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.n <= 0 {
+		var b [1]byte
+		if n, _ := l.r.Read(b[:]); n > 0 {
+			return 0, errTooLarge
+		}
+		return 0, io.EOF
+	}
+
+	if int64(len(p)) > l.n {
+		p = p[:l.n]
+	}
+	n, err := l.r.Read(p)
+	l.n -= int64(n)
+
+	return n, err
+}
@@ -0,0 +1,229 @@
+package httpingress_test
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"metricemitter/testhelper"
+	"net/http"
+	"strings"
+
+	"metron/internal/httpingress"
+	v2 "plumbing/v2"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Server", func() {
+	var (
+		setter *spySetter
+		spy    *testhelper.SpyMetricClient
+		server *httpingress.Server
+	)
+
+	BeforeEach(func() {
+		setter = newSpySetter()
+		spy = testhelper.NewMetricClient()
+
+		var err error
+		server, err = httpingress.NewServer(
+			"127.0.0.1:0",
+			setter,
+			spy,
+			httpingress.WithMaxBodyBytes(1024),
+		)
+		Expect(err).ToNot(HaveOccurred())
+		go server.Start()
+	})
+
+	AfterEach(func() {
+		server.Stop()
+	})
+
+	post := func(contentType, body string) (int, httpingress.Response) {
+		url := fmt.Sprintf("http://%s%s", server.Addr(), httpingress.Path)
+		resp, err := http.Post(url, contentType, strings.NewReader(body))
+		Expect(err).ToNot(HaveOccurred())
+		defer resp.Body.Close()
+
+		var r httpingress.Response
+		Expect(json.NewDecoder(resp.Body).Decode(&r)).To(Succeed())
+
+		return resp.StatusCode, r
+	}
+
+	It("accepts a single envelope", func() {
+		code, resp := post("application/json", `{
+			"source_id": "cron",
+			"timestamp": "1500000000000000000",
+			"tags": {"job": {"text": "backup"}},
+			"gauge": {"metrics": {"duration": {"unit": "s", "value": 12.5}}}
+		}`)
+
+		Expect(code).To(Equal(http.StatusAccepted))
+		Expect(resp).To(Equal(httpingress.Response{Accepted: 1}))
+
+		var e *v2.Envelope
+		Expect(setter.envelopes).To(Receive(&e))
+		Expect(e.GetSourceId()).To(Equal("cron"))
+		Expect(e.GetTimestamp()).To(Equal(int64(1500000000000000000)))
+		Expect(e.GetTags()["job"].GetText()).To(Equal("backup"))
+		Expect(e.GetGauge().GetMetrics()["duration"].GetValue()).To(Equal(12.5))
+		Expect(spy.GetDelta("http_received")).To(Equal(uint64(1)))
+	})
+
+	It("accepts a batch of envelopes", func() {
+		code, resp := post("application/json", `{"batch": [
+			{"sourceId": "a", "counter": {"name": "requests", "delta": "1"}},
+			{"sourceId": "b", "timer": {"name": "http", "start": "1", "stop": "2"}}
+		]}`)
+
+		Expect(code).To(Equal(http.StatusAccepted))
+		Expect(resp).To(Equal(httpingress.Response{Accepted: 2}))
+
+		var e *v2.Envelope
+		Expect(setter.envelopes).To(Receive(&e))
+		Expect(e.GetCounter().GetDelta()).To(Equal(uint64(1)))
+		Expect(setter.envelopes).To(Receive(&e))
+		Expect(e.GetTimer().GetStop()).To(Equal(int64(2)))
+	})
+
+	It("accepts newline delimited envelopes and drops invalid lines", func() {
+		body := `{"source_id": "a", "log": {"payload": "aGVsbG8="}}
+
+not json
+{"source_id": "b"}
+{"source_id": "c", "counter": {"name": "c", "total": "5"}}
+`
+		code, resp := post("application/x-ndjson", body)
+
+		Expect(code).To(Equal(http.StatusAccepted))
+		Expect(resp.Accepted).To(Equal(2))
+		Expect(resp.Dropped).To(Equal(2))
+		Expect(resp.Error).ToNot(BeEmpty())
+
+		var e *v2.Envelope
+		Expect(setter.envelopes).To(Receive(&e))
+		Expect(e.GetLog().GetPayload()).To(Equal([]byte("hello")))
+		Expect(e.GetTimestamp()).ToNot(BeZero())
+		Expect(setter.envelopes).To(Receive(&e))
+		Expect(e.GetSourceId()).To(Equal("c"))
+		Expect(spy.GetDelta("http_invalid")).To(Equal(uint64(2)))
+	})
+
+	It("responds with 400 when no envelopes are accepted", func() {
+		code, resp := post("application/json", `{"source_id": "a"}`)
+
+		Expect(code).To(Equal(http.StatusBadRequest))
+		Expect(resp.Dropped).To(Equal(1))
+		Expect(setter.envelopes).ToNot(Receive())
+	})
+
+	It("responds with 413 when the body is too large", func() {
+		body := fmt.Sprintf(`{"source_id": "%s", "counter": {"name": "c"}}`, strings.Repeat("a", 1024))
+		code, resp := post("application/json", body)
+
+		Expect(code).To(Equal(http.StatusRequestEntityTooLarge))
+		Expect(resp.Accepted).To(Equal(0))
+		Expect(setter.envelopes).ToNot(Receive())
+	})
+
+	It("counts envelopes the data setter does not keep as dropped", func() {
+		server.Stop()
+		var err error
+		server, err = httpingress.NewServer(
+			"127.0.0.1:0",
+			&rejectingSetter{},
+			spy,
+		)
+		Expect(err).ToNot(HaveOccurred())
+		go server.Start()
+
+		code, resp := post("application/json", `{"source_id": "a", "counter": {"name": "c"}}`)
+
+		Expect(code).To(Equal(http.StatusBadRequest))
+		Expect(resp).To(Equal(httpingress.Response{Dropped: 1}))
+		Expect(spy.GetDelta("http_received")).To(BeZero())
+		Expect(spy.GetDelta("http_invalid")).To(BeZero())
+	})
+
+	It("drops envelopes the authorizer does not allow", func() {
+		server.Stop()
+		authorizer := &spyAuthorizer{allowed: "a"}
+		var err error
+		server, err = httpingress.NewServer(
+			"127.0.0.1:0",
+			setter,
+			spy,
+			httpingress.WithAuthorizer(authorizer),
+		)
+		Expect(err).ToNot(HaveOccurred())
+		go server.Start()
+
+		code, resp := post("application/json", `{"batch": [
+			{"source_id": "a", "counter": {"name": "c"}},
+			{"source_id": "b", "counter": {"name": "c"}}
+		]}`)
+
+		Expect(code).To(Equal(http.StatusAccepted))
+		Expect(resp).To(Equal(httpingress.Response{Accepted: 1, Dropped: 1}))
+		Expect(authorizer.called).To(BeTrue())
+		Expect(authorizer.certs).To(BeEmpty())
+
+		var e *v2.Envelope
+		Expect(setter.envelopes).To(Receive(&e))
+		Expect(e.GetSourceId()).To(Equal("a"))
+		Expect(setter.envelopes).ToNot(Receive())
+	})
+
+	It("responds with 405 to methods other than POST", func() {
+		url := fmt.Sprintf("http://%s%s", server.Addr(), httpingress.Path)
+		resp, err := http.Get(url)
+		Expect(err).ToNot(HaveOccurred())
+		resp.Body.Close()
+
+		Expect(resp.StatusCode).To(Equal(http.StatusMethodNotAllowed))
+	})
+
+	It("responds with 415 to other content types", func() {
+		code, _ := post("text/plain", "hello")
+
+		Expect(code).To(Equal(http.StatusUnsupportedMediaType))
+	})
+})
+
+type spySetter struct {
+	envelopes chan *v2.Envelope
+}
+
+func newSpySetter() *spySetter {
+	return &spySetter{envelopes: make(chan *v2.Envelope, 100)}
+}
+
+func (s *spySetter) Set(e *v2.Envelope) {
+	s.envelopes <- e
+}
+
+type rejectingSetter struct{}
+
+func (s *rejectingSetter) Set(e *v2.Envelope) {}
+
+func (s *rejectingSetter) Accept(e *v2.Envelope) bool {
+	return false
+}
+
+type spyAuthorizer struct {
+	allowed string
+	called  bool
+	certs   []*x509.Certificate
+}
+
+func (a *spyAuthorizer) ForCertificates(certs []*x509.Certificate) func(e *v2.Envelope) bool {
+	a.called = true
+	a.certs = certs
+
+	return func(e *v2.Envelope) bool {
+		return e.GetSourceId() == a.allowed
+	}
+}
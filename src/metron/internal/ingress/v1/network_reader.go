@@ -1,6 +1,7 @@
 package v1
 
 import (
+	"context"
 	"log"
 	"net"
 
@@ -19,8 +20,14 @@ type NetworkReader struct {
 	connection net.PacketConn
 	writer     ByteArrayWriter
 
-	contextName string
-	buffer      *diodes.OneToOne
+	contextName              string
+	receivedMessageCountName string
+	receivedByteCountName    string
+	buffer                   *diodes.WaitingOneToOne
+
+	writeCtx    context.Context
+	stopWriting context.CancelFunc
+	writingDone chan struct{}
 }
 
 func New(address string, name string, writer ByteArrayWriter) (*NetworkReader, error) {
@@ -30,11 +37,17 @@ func New(address string, name string, writer ByteArrayWriter) (*NetworkReader, e
 	}
 	log.Printf("Listening on %s", address)
 
+	ctx, cancel := context.WithCancel(context.Background())
 	return &NetworkReader{
-		connection:  connection,
-		contextName: name,
-		writer:      writer,
-		buffer: diodes.NewOneToOne(10000, gendiodes.AlertFunc(func(missed int) {
+		connection:               connection,
+		contextName:              name,
+		receivedMessageCountName: name + ".receivedMessageCount",
+		receivedByteCountName:    name + ".receivedByteCount",
+		writer:                   writer,
+		writeCtx:                 ctx,
+		stopWriting:              cancel,
+		writingDone:              make(chan struct{}),
+		buffer: diodes.NewWaitingOneToOne(10000, gendiodes.AlertFunc(func(missed int) {
 			log.Printf("network reader dropped messages %d", missed)
 			// metric-documentation-v1: (udp.receiveErrorCount) Number of dropped messages
 			// inbound to Metron over the v1 (UDP) API
@@ -59,21 +72,57 @@ func (nr *NetworkReader) StartReading() {
 }
 
 func (nr *NetworkReader) StartWriting() {
-	receivedMessageCountName := nr.contextName + ".receivedMessageCount"
-	receivedByteCountName := nr.contextName + ".receivedByteCount"
+	defer close(nr.writingDone)
 
 	for {
-		data := nr.buffer.Next()
-		// metric-documentation-v1: (dropsondeAgentListener.receivedMessageCount) Number of
-		// received messages inbound to Metron over the v1 (UDP) API
-		metrics.BatchIncrementCounter(receivedMessageCountName)
-		// metric-documentation-v1: (dropsondeAgentListener.receivedByteCount) Number of
-		// received bytes inbound to Metron over the v1 (UDP) API
-		metrics.BatchAddCounter(receivedByteCountName, uint64(len(data)))
-		nr.writer.Write(data)
+		data, ok := nr.buffer.Next(nr.writeCtx)
+		if !ok {
+			return
+		}
+		nr.write(data)
 	}
 }
 
 func (nr *NetworkReader) Stop() {
 	nr.connection.Close()
 }
+
+// Shutdown stops reading from the network and writes the messages remaining
+// in the buffer until ctx is done. It returns the number of messages written
+// and the number abandoned.
+func (nr *NetworkReader) Shutdown(ctx context.Context) (flushed, abandoned uint64) {
+	nr.Stop()
+	nr.stopWriting()
+
+	select {
+	case <-nr.writingDone:
+	case <-ctx.Done():
+		log.Print("timed out waiting for network reader to stop writing")
+		return 0, 0
+	}
+
+	for {
+		data, ok := nr.buffer.TryNext()
+		if !ok {
+			return flushed, abandoned
+		}
+
+		if ctx.Err() != nil {
+			abandoned++
+			continue
+		}
+
+		nr.write(data)
+		flushed++
+	}
+}
+
+func (nr *NetworkReader) write(data []byte) {
+	// metric-documentation-v1: (dropsondeAgentListener.receivedMessageCount) Number of
+	// received messages inbound to Metron over the v1 (UDP) API
+	metrics.BatchIncrementCounter(nr.receivedMessageCountName)
+	// metric-documentation-v1: (dropsondeAgentListener.receivedByteCount) Number of
+	// received bytes inbound to Metron over the v1 (UDP) API
+	metrics.BatchAddCounter(nr.receivedByteCountName, uint64(len(data)))
+	nr.writer.Write(data)
+}
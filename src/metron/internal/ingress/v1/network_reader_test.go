@@ -1,9 +1,11 @@
 package v1_test
 
 import (
+	"context"
 	"net"
 	"strconv"
 	"sync"
+	"time"
 
 	ingress "metron/internal/ingress/v1"
 
@@ -83,6 +85,26 @@ var _ = Describe("NetworkReader", func() {
 			data := string(writer.Data()[0])
 			Expect(data).To(Equal(expectedData))
 		})
+
+		It("stops reading and writing on shutdown", func() {
+			connection, err := net.Dial("udp", address)
+			Expect(err).NotTo(HaveOccurred())
+
+			f := func() int {
+				_, err = connection.Write([]byte("Some Data"))
+				Expect(err).NotTo(HaveOccurred())
+
+				return len(writer.Data())
+			}
+			Eventually(f).ShouldNot(BeZero())
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			_, abandoned := reader.Shutdown(ctx)
+
+			Expect(abandoned).To(BeZero())
+			Eventually(readerStopped).Should(BeClosed())
+		})
 	})
 })
 
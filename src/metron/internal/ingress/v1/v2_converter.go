@@ -0,0 +1,39 @@
+package v1
+
+import (
+	"plumbing/conversion"
+	v2 "plumbing/v2"
+
+	"github.com/cloudfoundry/sonde-go/events"
+)
+
+// V2Setter is the destination of envelopes converted by a V2Converter.
+type V2Setter interface {
+	Set(e *v2.Envelope)
+}
+
+// V2Converter is an EnvelopeWriter that converts v1 envelopes to v2 and
+// hands them to the v2 egress pipeline. Counter events are converted to
+// counter deltas so that their totals are accumulated by v2 egress.
+type V2Converter struct {
+	setter V2Setter
+}
+
+// NewV2Converter returns a V2Converter that sets converted envelopes on
+// setter.
+func NewV2Converter(setter V2Setter) *V2Converter {
+	return &V2Converter{setter: setter}
+}
+
+// Write converts the envelope to v2 and sets it.
+func (c *V2Converter) Write(e *events.Envelope) {
+	v2e := conversion.ToV2(e)
+
+	if e.GetEventType() == events.Envelope_CounterEvent {
+		v2e.GetCounter().Value = &v2.Counter_Delta{
+			Delta: e.GetCounterEvent().GetDelta(),
+		}
+	}
+
+	c.setter.Set(v2e)
+}
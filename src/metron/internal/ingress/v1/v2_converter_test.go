@@ -0,0 +1,74 @@
+package v1_test
+
+import (
+	ingress "metron/internal/ingress/v1"
+	v2 "plumbing/v2"
+
+	"github.com/cloudfoundry/sonde-go/events"
+	"github.com/gogo/protobuf/proto"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("V2Converter", func() {
+	var (
+		setter    *spyV2Setter
+		converter *ingress.V2Converter
+	)
+
+	BeforeEach(func() {
+		setter = &spyV2Setter{}
+		converter = ingress.NewV2Converter(setter)
+	})
+
+	It("converts envelopes to v2 preserving the v1 tags", func() {
+		converter.Write(&events.Envelope{
+			Origin:     proto.String("some-origin"),
+			EventType:  events.Envelope_ValueMetric.Enum(),
+			Timestamp:  proto.Int64(99),
+			Deployment: proto.String("some-deployment"),
+			Job:        proto.String("some-job"),
+			Index:      proto.String("some-index"),
+			Ip:         proto.String("some-ip"),
+			ValueMetric: &events.ValueMetric{
+				Name:  proto.String("some-metric"),
+				Value: proto.Float64(2),
+				Unit:  proto.String("ms"),
+			},
+		})
+
+		Expect(setter.envelopes).To(HaveLen(1))
+		e := setter.envelopes[0]
+		Expect(e.GetTimestamp()).To(Equal(int64(99)))
+		Expect(e.GetGauge().GetMetrics()["some-metric"].GetValue()).To(Equal(2.0))
+		Expect(e.GetTags()["deployment"].GetText()).To(Equal("some-deployment"))
+		Expect(e.GetTags()["job"].GetText()).To(Equal("some-job"))
+		Expect(e.GetTags()["index"].GetText()).To(Equal("some-index"))
+		Expect(e.GetTags()["ip"].GetText()).To(Equal("some-ip"))
+		Expect(e.GetTags()["origin"].GetText()).To(Equal("some-origin"))
+	})
+
+	It("converts counter events to deltas", func() {
+		converter.Write(&events.Envelope{
+			Origin:    proto.String("some-origin"),
+			EventType: events.Envelope_CounterEvent.Enum(),
+			CounterEvent: &events.CounterEvent{
+				Name:  proto.String("some-counter"),
+				Delta: proto.Uint64(5),
+			},
+		})
+
+		Expect(setter.envelopes).To(HaveLen(1))
+		Expect(setter.envelopes[0].GetCounter().GetName()).To(Equal("some-counter"))
+		Expect(setter.envelopes[0].GetCounter().GetDelta()).To(Equal(uint64(5)))
+	})
+})
+
+type spyV2Setter struct {
+	envelopes []*v2.Envelope
+}
+
+func (s *spyV2Setter) Set(e *v2.Envelope) {
+	s.envelopes = append(s.envelopes, e)
+}
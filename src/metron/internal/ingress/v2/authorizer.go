@@ -0,0 +1,346 @@
+package v2
+
+import (
+	"crypto/x509"
+	"fmt"
+	"metricemitter"
+	"path/filepath"
+	v2 "plumbing/v2"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+// maxCachedSourceIDs bounds the number of authorization decisions kept per
+// stream.
+const maxCachedSourceIDs = 1000
+
+// ViolationMode is what the Authorizer does with an envelope whose
+// source_id the sender is not allowed to use.
+type ViolationMode int
+
+const (
+	// RejectViolations drops the envelope.
+	RejectViolations ViolationMode = iota
+
+	// RewriteViolations replaces the source_id with the first literal
+	// source_id the sender is allowed to use. Envelopes from senders
+	// without one are dropped.
+	RewriteViolations
+
+	// TagViolations keeps the envelope and tags it with the sender.
+	TagViolations
+)
+
+// ParseViolationMode returns the ViolationMode named "reject", "rewrite" or
+// "tag".
+func ParseViolationMode(s string) (ViolationMode, error) {
+	switch s {
+	case "reject":
+		return RejectViolations, nil
+	case "rewrite":
+		return RewriteViolations, nil
+	case "tag":
+		return TagViolations, nil
+	}
+
+	return 0, fmt.Errorf("unknown violation mode %q", s)
+}
+
+// SenderRule allows the senders matching it to use the source_ids matching
+// any of SourceIDs. Subject is matched against the common name of the
+// client certificate and SAN against each of its DNS, email and IP subject
+// alternative names. UIDs and GIDs match the peer credentials of processes
+// connected to the Unix domain socket. A rule must set at least one of them,
+// may not mix certificate and peer credential matchers, and matches only if
+// every one set matches. All patterns are shell patterns as used by
+// filepath.Match.
+type SenderRule struct {
+	Subject   string
+	SAN       string
+	UIDs      []uint32
+	GIDs      []uint32
+	SourceIDs []string
+}
+
+// Authorizer restricts the source_ids a sender may use based on the client
+// certificate it connected with or, on the Unix domain socket, its peer
+// credentials. Senders with neither match no rule and so may not use any
+// source_id.
+type Authorizer struct {
+	rules        []SenderRule
+	mode         ViolationMode
+	metricClient metricemitter.MetricClient
+
+	mu               sync.Mutex
+	violationMetrics map[string]*metricemitter.CounterMetric
+}
+
+// NewAuthorizer returns an Authorizer. It returns an error if a rule
+// matches every sender or has an invalid pattern.
+func NewAuthorizer(
+	rules []SenderRule,
+	mode ViolationMode,
+	metricClient metricemitter.MetricClient,
+) (*Authorizer, error) {
+	for i, r := range rules {
+		certRule := r.Subject != "" || r.SAN != ""
+		peerCredRule := len(r.UIDs) > 0 || len(r.GIDs) > 0
+		if !certRule && !peerCredRule {
+			return nil, fmt.Errorf("sender rule %d must have a Subject, SAN, UIDs or GIDs", i)
+		}
+		if certRule && peerCredRule {
+			return nil, fmt.Errorf("sender rule %d may not match both certificates and peer credentials", i)
+		}
+
+		patterns := append([]string{r.Subject, r.SAN}, r.SourceIDs...)
+		for _, p := range patterns {
+			if _, err := filepath.Match(p, ""); err != nil {
+				return nil, fmt.Errorf("sender rule %d has invalid pattern %q: %s", i, p, err)
+			}
+		}
+	}
+
+	return &Authorizer{
+		rules:            rules,
+		mode:             mode,
+		metricClient:     metricClient,
+		violationMetrics: make(map[string]*metricemitter.CounterMetric),
+	}, nil
+}
+
+func (a *Authorizer) violationMetric(action string) *metricemitter.CounterMetric {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	m, ok := a.violationMetrics[action]
+	if !ok {
+		m = a.metricClient.NewCounterMetric("source_id_violations",
+			metricemitter.WithVersion(2, 0),
+			metricemitter.WithTags(map[string]string{"action": action}),
+		)
+		a.violationMetrics[action] = m
+	}
+
+	return m
+}
+
+// senderPolicy is the authorization of a single sender. It is safe for
+// concurrent use.
+type senderPolicy struct {
+	a         *Authorizer
+	sender    string
+	sourceIDs []string
+	rewriteTo string
+
+	mu      sync.Mutex
+	allowed map[string]bool
+}
+
+// policy returns the policy for the sender of the stream with the given
+// context.
+func (a *Authorizer) policy(ctx context.Context) *senderPolicy {
+	if cert := peerCertificate(ctx); cert != nil {
+		return a.certificatePolicy(cert)
+	}
+
+	p := a.newPolicy()
+	creds, ok := peerCreds(ctx)
+	if !ok {
+		return p
+	}
+
+	p.sender = fmt.Sprintf("uid=%d gid=%d", creds.UID, creds.GID)
+	p.addRules(func(r SenderRule) bool {
+		return r.matchesPeerCreds(creds.UID, creds.GID)
+	})
+
+	return p
+}
+
+// ForCertificates returns a function that authorizes the envelopes of a
+// sender that presented the given certificate chain, such as an HTTPS
+// client. The function reports whether the envelope should be kept and may
+// rewrite or tag it. It is safe for concurrent use.
+func (a *Authorizer) ForCertificates(certs []*x509.Certificate) func(e *v2.Envelope) bool {
+	if len(certs) == 0 {
+		return a.newPolicy().authorize
+	}
+
+	return a.certificatePolicy(certs[0]).authorize
+}
+
+func (a *Authorizer) certificatePolicy(cert *x509.Certificate) *senderPolicy {
+	p := a.newPolicy()
+	p.sender = cert.Subject.CommonName
+	sans := subjectAltNames(cert)
+	p.addRules(func(r SenderRule) bool {
+		return r.matches(cert.Subject.CommonName, sans)
+	})
+
+	return p
+}
+
+func (a *Authorizer) newPolicy() *senderPolicy {
+	return &senderPolicy{
+		a:       a,
+		allowed: make(map[string]bool),
+	}
+}
+
+// addRules allows the source_ids of every rule that matches the sender.
+func (p *senderPolicy) addRules(matches func(SenderRule) bool) {
+	for _, r := range p.a.rules {
+		if !matches(r) {
+			continue
+		}
+
+		p.sourceIDs = append(p.sourceIDs, r.SourceIDs...)
+		for _, id := range r.SourceIDs {
+			if p.rewriteTo == "" && !hasMeta(id) {
+				p.rewriteTo = id
+			}
+		}
+	}
+}
+
+// authorize applies the policy to the envelope. It returns false if the
+// envelope should be dropped.
+func (p *senderPolicy) authorize(e *v2.Envelope) bool {
+	if p.isAllowed(e.GetSourceId()) {
+		return true
+	}
+
+	switch p.a.mode {
+	case RewriteViolations:
+		if p.rewriteTo == "" {
+			break
+		}
+
+		// metric-documentation-v2: (loggregator.metron.source_id_violations)
+		// Number of envelopes received with a source_id the sender may not
+		// use by the action taken
+		p.a.violationMetric("rewritten").Increment(1)
+		e.SourceId = p.rewriteTo
+		return true
+	case TagViolations:
+		// metric-documentation-v2: (loggregator.metron.source_id_violations)
+		// Number of envelopes received with a source_id the sender may not
+		// use by the action taken
+		p.a.violationMetric("tagged").Increment(1)
+		if e.Tags == nil {
+			e.Tags = make(map[string]*v2.Value)
+		}
+		e.Tags["unauthorized_sender"] = &v2.Value{
+			Data: &v2.Value_Text{Text: p.sender},
+		}
+		return true
+	}
+
+	// metric-documentation-v2: (loggregator.metron.source_id_violations)
+	// Number of envelopes received with a source_id the sender may not use
+	// by the action taken
+	p.a.violationMetric("rejected").Increment(1)
+	return false
+}
+
+func (p *senderPolicy) isAllowed(sourceID string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if allowed, ok := p.allowed[sourceID]; ok {
+		return allowed
+	}
+
+	var allowed bool
+	for _, pattern := range p.sourceIDs {
+		if ok, _ := filepath.Match(pattern, sourceID); ok {
+			allowed = true
+			break
+		}
+	}
+
+	if len(p.allowed) >= maxCachedSourceIDs {
+		p.allowed = make(map[string]bool)
+	}
+	p.allowed[sourceID] = allowed
+
+	return allowed
+}
+
+func (r SenderRule) matches(subject string, sans []string) bool {
+	if r.Subject == "" && r.SAN == "" {
+		return false
+	}
+
+	if r.Subject != "" {
+		if ok, _ := filepath.Match(r.Subject, subject); !ok {
+			return false
+		}
+	}
+
+	if r.SAN == "" {
+		return true
+	}
+
+	for _, san := range sans {
+		if ok, _ := filepath.Match(r.SAN, san); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (r SenderRule) matchesPeerCreds(uid, gid uint32) bool {
+	if len(r.UIDs) == 0 && len(r.GIDs) == 0 {
+		return false
+	}
+
+	if len(r.UIDs) > 0 && !contains(r.UIDs, uid) {
+		return false
+	}
+
+	return len(r.GIDs) == 0 || contains(r.GIDs, gid)
+}
+
+func peerCreds(ctx context.Context) (PeerCredAddr, bool) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return PeerCredAddr{}, false
+	}
+
+	addr, ok := p.Addr.(PeerCredAddr)
+	return addr, ok
+}
+
+func peerCertificate(ctx context.Context) *x509.Certificate {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil
+	}
+
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(info.State.PeerCertificates) == 0 {
+		return nil
+	}
+
+	return info.State.PeerCertificates[0]
+}
+
+func subjectAltNames(cert *x509.Certificate) []string {
+	sans := append([]string{}, cert.DNSNames...)
+	sans = append(sans, cert.EmailAddresses...)
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+
+	return sans
+}
+
+func hasMeta(pattern string) bool {
+	return strings.ContainsAny(pattern, `*?[\`)
+}
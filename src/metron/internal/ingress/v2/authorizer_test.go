@@ -0,0 +1,271 @@
+package v2_test
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"io/ioutil"
+	"metricemitter/testhelper"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	ingress "metron/internal/ingress/v2"
+	v2 "plumbing/v2"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Authorizer", func() {
+	var (
+		spy       *testhelper.SpyMetricClient
+		spySetter *SpySetter
+		rules     []ingress.SenderRule
+	)
+
+	BeforeEach(func() {
+		spy = testhelper.NewMetricClient()
+		spySetter = NewSpySetter()
+		rules = []ingress.SenderRule{
+			{Subject: "gorouter", SourceIDs: []string{"gorouter"}},
+			{SAN: "*.cell.internal", SourceIDs: []string{"rep", "app-*"}},
+		}
+	})
+
+	send := func(mode ingress.ViolationMode, ctx context.Context, envelopes ...*v2.Envelope) {
+		authorizer, err := ingress.NewAuthorizer(rules, mode, spy)
+		Expect(err).ToNot(HaveOccurred())
+		rx := ingress.NewReceiver(spySetter, spy, ingress.WithAuthorizer(authorizer))
+
+		sender := NewSpyBatchSender()
+		sender.ctx = ctx
+		sender.recvResponses <- BatchSenderRecvResponse{envelopes: envelopes}
+		sender.recvResponses <- BatchSenderRecvResponse{err: io.EOF}
+
+		rx.BatchSender(sender)
+	}
+
+	It("sets envelopes with a source_id the sender may use", func() {
+		send(
+			ingress.RejectViolations,
+			peerContext("diego-cell", "cell-1.cell.internal"),
+			&v2.Envelope{SourceId: "rep"},
+			&v2.Envelope{SourceId: "app-1234"},
+		)
+
+		Expect(spySetter.envelopes).To(Receive(Equal(&v2.Envelope{SourceId: "rep"})))
+		Expect(spySetter.envelopes).To(Receive(Equal(&v2.Envelope{SourceId: "app-1234"})))
+	})
+
+	It("rejects envelopes with a source_id the sender may not use", func() {
+		send(
+			ingress.RejectViolations,
+			peerContext("gorouter"),
+			&v2.Envelope{SourceId: "app-1234"},
+			&v2.Envelope{SourceId: "gorouter"},
+		)
+
+		Expect(spySetter.envelopes).To(Receive(Equal(&v2.Envelope{SourceId: "gorouter"})))
+		Expect(spySetter.envelopes).ToNot(Receive())
+		Expect(spy.GetDelta("source_id_violations")).To(Equal(uint64(1)))
+	})
+
+	It("does not count rejected envelopes as accepted by Send", func() {
+		authorizer, err := ingress.NewAuthorizer(rules, ingress.RejectViolations, spy)
+		Expect(err).ToNot(HaveOccurred())
+		rx := ingress.NewReceiver(spySetter, spy, ingress.WithAuthorizer(authorizer))
+
+		resp, err := rx.Send(peerContext("gorouter"), &v2.EnvelopeBatch{
+			Batch: []*v2.Envelope{{SourceId: "app-1234"}, {SourceId: "gorouter"}},
+		})
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(resp.GetAccepted()).To(Equal(uint64(1)))
+		Expect(spySetter.envelopes).To(Receive(Equal(&v2.Envelope{SourceId: "gorouter"})))
+		Expect(spySetter.envelopes).ToNot(Receive())
+	})
+
+	It("rejects every envelope from senders without a certificate", func() {
+		send(ingress.RejectViolations, context.Background(), &v2.Envelope{SourceId: "gorouter"})
+
+		Expect(spySetter.envelopes).ToNot(Receive())
+	})
+
+	It("rewrites the source_id of violating envelopes", func() {
+		send(ingress.RewriteViolations, peerContext("diego-cell", "cell-1.cell.internal"), &v2.Envelope{SourceId: "gorouter"})
+
+		Expect(spySetter.envelopes).To(Receive(Equal(&v2.Envelope{SourceId: "rep"})))
+		Expect(spy.GetDelta("source_id_violations")).To(Equal(uint64(1)))
+	})
+
+	It("rejects violating envelopes that cannot be rewritten", func() {
+		send(ingress.RewriteViolations, peerContext("unknown"), &v2.Envelope{SourceId: "gorouter"})
+
+		Expect(spySetter.envelopes).ToNot(Receive())
+	})
+
+	It("tags violating envelopes with the sender", func() {
+		send(ingress.TagViolations, peerContext("gorouter"), &v2.Envelope{SourceId: "rep"})
+
+		var e *v2.Envelope
+		Expect(spySetter.envelopes).To(Receive(&e))
+		Expect(e.GetSourceId()).To(Equal("rep"))
+		Expect(e.GetTags()["unauthorized_sender"].GetText()).To(Equal("gorouter"))
+	})
+
+	It("authorizes unix socket senders by their peer credentials", func() {
+		rules = append(rules, ingress.SenderRule{
+			UIDs:      []uint32{1000},
+			SourceIDs: []string{"local-*"},
+		})
+
+		send(
+			ingress.RejectViolations,
+			peerCredContext(1000, 1000),
+			&v2.Envelope{SourceId: "local-app"},
+			&v2.Envelope{SourceId: "gorouter"},
+		)
+
+		Expect(spySetter.envelopes).To(Receive(Equal(&v2.Envelope{SourceId: "local-app"})))
+		Expect(spySetter.envelopes).ToNot(Receive())
+	})
+
+	It("does not match certificate rules against unix socket senders", func() {
+		rules = []ingress.SenderRule{{Subject: "*", SourceIDs: []string{"*"}}}
+
+		send(ingress.RejectViolations, peerCredContext(0, 0), &v2.Envelope{SourceId: "rep"})
+
+		Expect(spySetter.envelopes).ToNot(Receive())
+	})
+
+	It("requires rules not to mix certificate and peer credential matchers", func() {
+		_, err := ingress.NewAuthorizer(
+			[]ingress.SenderRule{{Subject: "gorouter", UIDs: []uint32{0}, SourceIDs: []string{"*"}}},
+			ingress.RejectViolations,
+			spy,
+		)
+
+		Expect(err).To(HaveOccurred())
+	})
+
+	Context("on a unix socket", func() {
+		var dir string
+
+		BeforeEach(func() {
+			if runtime.GOOS != "linux" {
+				Skip("peer credentials are only supported on linux")
+			}
+
+			var err error
+			dir, err = ioutil.TempDir("", "metron-authorizer")
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(dir)
+		})
+
+		It("accepts envelopes with a source_id allowed for the process", func() {
+			rules = append(rules, ingress.SenderRule{
+				UIDs:      []uint32{uint32(os.Getuid())},
+				SourceIDs: []string{"local-*"},
+			})
+			authorizer, err := ingress.NewAuthorizer(rules, ingress.RejectViolations, spy)
+			Expect(err).ToNot(HaveOccurred())
+			rx := ingress.NewReceiver(spySetter, spy, ingress.WithAuthorizer(authorizer))
+
+			path := filepath.Join(dir, "metron.sock")
+			server := ingress.NewUnixServer(ingress.UnixSocketConfig{
+				Path:     path,
+				FileMode: 0600,
+			}, rx)
+			go server.Start()
+			defer server.Stop()
+			Eventually(func() error {
+				_, err := os.Stat(path)
+				return err
+			}).Should(Succeed())
+
+			conn, err := grpc.Dial(path,
+				grpc.WithInsecure(),
+				grpc.WithDialer(func(addr string, timeout time.Duration) (net.Conn, error) {
+					return net.DialTimeout("unix", addr, timeout)
+				}),
+			)
+			Expect(err).ToNot(HaveOccurred())
+			defer conn.Close()
+			client := v2.NewIngressClient(conn)
+
+			var resp *v2.SendResponse
+			Eventually(func() error {
+				resp, err = client.Send(context.Background(), &v2.EnvelopeBatch{
+					Batch: []*v2.Envelope{{SourceId: "local-app"}, {SourceId: "gorouter"}},
+				})
+				return err
+			}).Should(Succeed())
+
+			Expect(resp.GetAccepted()).To(Equal(uint64(1)))
+			Expect(spySetter.envelopes).To(Receive(Equal(&v2.Envelope{SourceId: "local-app"})))
+			Expect(spySetter.envelopes).ToNot(Receive())
+		})
+	})
+
+	It("requires rules to match on the subject, a SAN or peer credentials", func() {
+		_, err := ingress.NewAuthorizer(
+			[]ingress.SenderRule{{SourceIDs: []string{"*"}}},
+			ingress.RejectViolations,
+			spy,
+		)
+
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("authorizes senders by a certificate chain", func() {
+		authorizer, err := ingress.NewAuthorizer(rules, ingress.RejectViolations, spy)
+		Expect(err).ToNot(HaveOccurred())
+
+		authorize := authorizer.ForCertificates([]*x509.Certificate{{
+			Subject: pkix.Name{CommonName: "gorouter"},
+		}})
+		Expect(authorize(&v2.Envelope{SourceId: "gorouter"})).To(BeTrue())
+		Expect(authorize(&v2.Envelope{SourceId: "app-1234"})).To(BeFalse())
+
+		authorize = authorizer.ForCertificates(nil)
+		Expect(authorize(&v2.Envelope{SourceId: "gorouter"})).To(BeFalse())
+	})
+
+	It("parses violation modes", func() {
+		Expect(ingress.ParseViolationMode("rewrite")).To(Equal(ingress.RewriteViolations))
+
+		_, err := ingress.ParseViolationMode("ignore")
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+func peerContext(commonName string, dnsNames ...string) context.Context {
+	return peer.NewContext(context.Background(), &peer.Peer{
+		AuthInfo: credentials.TLSInfo{
+			State: tls.ConnectionState{
+				PeerCertificates: []*x509.Certificate{{
+					Subject:  pkix.Name{CommonName: commonName},
+					DNSNames: dnsNames,
+				}},
+			},
+		},
+	})
+}
+
+func peerCredContext(uid, gid uint32) context.Context {
+	return peer.NewContext(context.Background(), &peer.Peer{
+		Addr: ingress.PeerCredAddr{UID: uid, GID: gid},
+	})
+}
@@ -0,0 +1,36 @@
+// +build linux
+
+package v2
+
+import (
+	"errors"
+	"net"
+	"syscall"
+)
+
+func peerCredentials(conn net.Conn) (uint32, uint32, error) {
+	uc, ok := conn.(*net.UnixConn)
+	if !ok {
+		return 0, 0, errors.New("not a unix socket connection")
+	}
+
+	f, err := uc.File()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	fd := int(f.Fd())
+	cred, err := syscall.GetsockoptUcred(fd, syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+
+	// File puts the duplicated descriptor, and with it the connection, in
+	// blocking mode. Restore non-blocking mode for the connection's sake.
+	if nbErr := syscall.SetNonblock(fd, true); nbErr != nil && err == nil {
+		err = nbErr
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return cred.Uid, cred.Gid, nil
+}
@@ -0,0 +1,14 @@
+// +build !linux
+
+package v2
+
+import (
+	"errors"
+	"net"
+)
+
+// peerCredentials is only supported on linux. Every connection is rejected
+// when peer credentials are required elsewhere.
+func peerCredentials(net.Conn) (uint32, uint32, error) {
+	return 0, 0, errors.New("peer credentials are not supported on this platform")
+}
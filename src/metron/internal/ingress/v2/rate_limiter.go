@@ -0,0 +1,176 @@
+package v2
+
+import (
+	"metricemitter"
+	v2 "plumbing/v2"
+	"sync"
+	"time"
+)
+
+const (
+	maxTrackedSources = 10000
+
+	// maxDroppedMetricSources bounds the number of source_ids given their
+	// own dropped metric. Metrics are reported for the life of the process so
+	// drops from any further source_ids are counted together.
+	maxDroppedMetricSources = 100
+)
+
+// RateLimit is a token bucket limit. EnvelopesPerSecond is the refill rate
+// and Burst is the bucket size. A zero EnvelopesPerSecond means unlimited.
+type RateLimit struct {
+	EnvelopesPerSecond float64
+	Burst              uint
+}
+
+// RateLimiter is a DataSetter that applies per source_id token bucket limits
+// before handing envelopes on to the next DataSetter. Envelopes that exceed
+// their source's limit are dropped.
+type RateLimiter struct {
+	setter       DataSetter
+	defaultLimit RateLimit
+	overrides    map[string]RateLimit
+	metricClient metricemitter.MetricClient
+
+	mu             sync.Mutex
+	buckets        map[string]*tokenBucket
+	droppedMetrics map[string]*metricemitter.CounterMetric
+	otherDropped   *metricemitter.CounterMetric
+}
+
+// NewRateLimiter returns a RateLimiter. Sources without an override are
+// limited by defaultLimit.
+func NewRateLimiter(
+	setter DataSetter,
+	defaultLimit RateLimit,
+	overrides map[string]RateLimit,
+	metricClient metricemitter.MetricClient,
+) *RateLimiter {
+	return &RateLimiter{
+		setter:         setter,
+		defaultLimit:   defaultLimit,
+		overrides:      overrides,
+		metricClient:   metricClient,
+		buckets:        make(map[string]*tokenBucket),
+		droppedMetrics: make(map[string]*metricemitter.CounterMetric),
+	}
+}
+
+// Set forwards the envelope if its source has tokens available.
+func (r *RateLimiter) Set(e *v2.Envelope) {
+	r.Accept(e)
+}
+
+// Accept forwards the envelope if its source has tokens available and
+// reports whether the next DataSetter kept it.
+func (r *RateLimiter) Accept(e *v2.Envelope) bool {
+	if !r.allow(e.GetSourceId()) {
+		return false
+	}
+
+	return accept(r.setter, e)
+}
+
+func (r *RateLimiter) allow(sourceID string) bool {
+	limit, ok := r.overrides[sourceID]
+	if !ok {
+		limit = r.defaultLimit
+	}
+
+	if limit.EnvelopesPerSecond <= 0 {
+		return true
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	b, ok := r.buckets[sourceID]
+	if !ok {
+		if len(r.buckets) >= maxTrackedSources {
+			r.buckets = make(map[string]*tokenBucket)
+		}
+
+		b = newTokenBucket(limit, time.Now())
+		r.buckets[sourceID] = b
+	}
+
+	if b.take(time.Now()) {
+		return true
+	}
+
+	// metric-documentation-v2: (loggregator.metron.dropped) Number of v2
+	// envelopes dropped by the per source_id ingress rate limiter. Drops
+	// beyond the first 100 source_ids have no source_id tag.
+	r.droppedMetric(sourceID).Increment(1)
+
+	return false
+}
+
+func (r *RateLimiter) droppedMetric(sourceID string) *metricemitter.CounterMetric {
+	m, ok := r.droppedMetrics[sourceID]
+	if ok {
+		return m
+	}
+
+	if len(r.droppedMetrics) >= maxDroppedMetricSources {
+		if r.otherDropped == nil {
+			r.otherDropped = r.metricClient.NewCounterMetric("dropped",
+				metricemitter.WithVersion(2, 0),
+				metricemitter.WithTags(map[string]string{
+					"direction": "ingress",
+					"reason":    "rate_limited",
+				}),
+			)
+		}
+
+		return r.otherDropped
+	}
+
+	m = r.metricClient.NewCounterMetric("dropped",
+		metricemitter.WithVersion(2, 0),
+		metricemitter.WithTags(map[string]string{
+			"direction": "ingress",
+			"reason":    "rate_limited",
+			"source_id": sourceID,
+		}),
+	)
+	r.droppedMetrics[sourceID] = m
+
+	return m
+}
+
+type tokenBucket struct {
+	tokens float64
+	burst  float64
+	rate   float64
+	last   time.Time
+}
+
+func newTokenBucket(limit RateLimit, now time.Time) *tokenBucket {
+	burst := float64(limit.Burst)
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &tokenBucket{
+		tokens: burst,
+		burst:  burst,
+		rate:   limit.EnvelopesPerSecond,
+		last:   now,
+	}
+}
+
+func (b *tokenBucket) take(now time.Time) bool {
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+
+	b.tokens--
+	return true
+}
@@ -0,0 +1,119 @@
+package v2_test
+
+import (
+	"fmt"
+	"metricemitter"
+	"metricemitter/testhelper"
+
+	ingress "metron/internal/ingress/v2"
+	v2 "plumbing/v2"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("RateLimiter", func() {
+	var (
+		spySetter  *SpySetter
+		spyMetrics *testhelper.SpyMetricClient
+	)
+
+	BeforeEach(func() {
+		spySetter = NewSpySetter()
+		spyMetrics = testhelper.NewMetricClient()
+	})
+
+	It("forwards everything when no limit is configured", func() {
+		limiter := ingress.NewRateLimiter(spySetter, ingress.RateLimit{}, nil, spyMetrics)
+
+		for i := 0; i < 50; i++ {
+			limiter.Set(&v2.Envelope{SourceId: "some-id"})
+		}
+
+		Expect(spySetter.envelopes).To(HaveLen(50))
+	})
+
+	It("drops envelopes beyond the default limit", func() {
+		limiter := ingress.NewRateLimiter(
+			spySetter,
+			ingress.RateLimit{EnvelopesPerSecond: 0.001, Burst: 5},
+			nil,
+			spyMetrics,
+		)
+
+		for i := 0; i < 10; i++ {
+			limiter.Set(&v2.Envelope{SourceId: "some-id"})
+		}
+
+		Expect(spySetter.envelopes).To(HaveLen(5))
+		Expect(spyMetrics.GetDelta("dropped")).To(Equal(uint64(5)))
+	})
+
+	It("limits each source_id independently", func() {
+		limiter := ingress.NewRateLimiter(
+			spySetter,
+			ingress.RateLimit{EnvelopesPerSecond: 0.001, Burst: 2},
+			nil,
+			spyMetrics,
+		)
+
+		for i := 0; i < 5; i++ {
+			limiter.Set(&v2.Envelope{SourceId: "chatty"})
+		}
+		limiter.Set(&v2.Envelope{SourceId: "quiet"})
+
+		Expect(spySetter.envelopes).To(HaveLen(3))
+	})
+
+	It("uses per source_id overrides", func() {
+		limiter := ingress.NewRateLimiter(
+			spySetter,
+			ingress.RateLimit{EnvelopesPerSecond: 0.001, Burst: 1},
+			map[string]ingress.RateLimit{
+				"unlimited": {},
+				"generous":  {EnvelopesPerSecond: 0.001, Burst: 3},
+			},
+			spyMetrics,
+		)
+
+		for i := 0; i < 5; i++ {
+			limiter.Set(&v2.Envelope{SourceId: "unlimited"})
+			limiter.Set(&v2.Envelope{SourceId: "generous"})
+			limiter.Set(&v2.Envelope{SourceId: "other"})
+		}
+
+		Expect(spySetter.envelopes).To(HaveLen(5 + 3 + 1))
+	})
+
+	It("bounds the number of dropped metrics", func() {
+		metrics := &countingMetricClient{}
+		limiter := ingress.NewRateLimiter(
+			nopSetter{},
+			ingress.RateLimit{EnvelopesPerSecond: 0.001, Burst: 1},
+			nil,
+			metrics,
+		)
+
+		for i := 0; i < 500; i++ {
+			e := &v2.Envelope{SourceId: fmt.Sprintf("source-%d", i)}
+			limiter.Set(e)
+			limiter.Set(e)
+		}
+
+		Expect(metrics.created).To(Equal(101))
+	})
+})
+
+// countingMetricClient counts the metrics created.
+type countingMetricClient struct {
+	created int
+}
+
+func (c *countingMetricClient) NewCounterMetric(name string, opts ...metricemitter.MetricOption) *metricemitter.CounterMetric {
+	c.created++
+	return metricemitter.NewCounterMetric(name, "", opts...)
+}
+
+type nopSetter struct{}
+
+func (nopSetter) Set(*v2.Envelope) {}
@@ -4,29 +4,73 @@ import (
 	"log"
 	"metricemitter"
 	v2 "plumbing/v2"
+
+	"golang.org/x/net/context"
 )
 
 type DataSetter interface {
 	Set(e *v2.Envelope)
 }
 
+// Accepter is a DataSetter that reports whether the envelope was kept or
+// dropped.
+type Accepter interface {
+	DataSetter
+	Accept(e *v2.Envelope) bool
+}
+
+// accept hands the envelope to the data setter and reports whether it was
+// kept. Data setters that are not Accepters are assumed to keep every
+// envelope.
+func accept(s DataSetter, e *v2.Envelope) bool {
+	if a, ok := s.(Accepter); ok {
+		return a.Accept(e)
+	}
+
+	s.Set(e)
+	return true
+}
+
 type Receiver struct {
 	dataSetter    DataSetter
+	authorizer    *Authorizer
 	ingressMetric *metricemitter.CounterMetric
 }
 
-func NewReceiver(dataSetter DataSetter, metricClient metricemitter.MetricClient) *Receiver {
+// ReceiverOption is a type that will manipulate a Receiver
+type ReceiverOption func(*Receiver)
+
+// WithAuthorizer restricts the source_ids each sender may use to those
+// allowed by a.
+func WithAuthorizer(a *Authorizer) ReceiverOption {
+	return func(r *Receiver) {
+		r.authorizer = a
+	}
+}
+
+func NewReceiver(
+	dataSetter DataSetter,
+	metricClient metricemitter.MetricClient,
+	opts ...ReceiverOption,
+) *Receiver {
 	ingressMetric := metricClient.NewCounterMetric("ingress",
 		metricemitter.WithVersion(2, 0),
 	)
 
-	return &Receiver{
+	r := &Receiver{
 		dataSetter:    dataSetter,
 		ingressMetric: ingressMetric,
 	}
+
+	for _, o := range opts {
+		o(r)
+	}
+
+	return r
 }
 
 func (s *Receiver) Sender(sender v2.Ingress_SenderServer) error {
+	policy := s.policy(sender.Context())
 	for {
 		e, err := sender.Recv()
 		if err != nil {
@@ -34,7 +78,7 @@ func (s *Receiver) Sender(sender v2.Ingress_SenderServer) error {
 			return err
 		}
 
-		s.dataSetter.Set(e)
+		s.set(policy, e)
 		// metric-documentation-v2: (loggregator.metron.ingress) The number of
 		// received messages over Metrons V2 gRPC API.
 		s.ingressMetric.Increment(1)
@@ -44,6 +88,7 @@ func (s *Receiver) Sender(sender v2.Ingress_SenderServer) error {
 }
 
 func (s *Receiver) BatchSender(sender v2.Ingress_BatchSenderServer) error {
+	policy := s.policy(sender.Context())
 	for {
 		envelopes, err := sender.Recv()
 		if err != nil {
@@ -52,7 +97,7 @@ func (s *Receiver) BatchSender(sender v2.Ingress_BatchSenderServer) error {
 		}
 
 		for _, e := range envelopes.Batch {
-			s.dataSetter.Set(e)
+			s.set(policy, e)
 		}
 
 		// metric-documentation-v2: (loggregator.metron.ingress) The number of
@@ -62,3 +107,43 @@ func (s *Receiver) BatchSender(sender v2.Ingress_BatchSenderServer) error {
 
 	return nil
 }
+
+// Send sets a batch of envelopes and returns once every envelope has been
+// handed to the data setter. The response counts the envelopes that made it
+// into the ingress buffer.
+func (s *Receiver) Send(ctx context.Context, batch *v2.EnvelopeBatch) (*v2.SendResponse, error) {
+	policy := s.policy(ctx)
+
+	var accepted uint64
+	for _, e := range batch.GetBatch() {
+		if s.set(policy, e) {
+			accepted++
+		}
+	}
+
+	// metric-documentation-v2: (loggregator.metron.ingress) The number of
+	// received messages over Metrons V2 gRPC API.
+	s.ingressMetric.Increment(uint64(len(batch.GetBatch())))
+
+	return &v2.SendResponse{Accepted: accepted}, nil
+}
+
+// policy returns the authorization of the sender of a stream, or nil when
+// every sender may use every source_id.
+func (s *Receiver) policy(ctx context.Context) *senderPolicy {
+	if s.authorizer == nil {
+		return nil
+	}
+
+	return s.authorizer.policy(ctx)
+}
+
+// set hands the envelope to the data setter if the sender may use its
+// source_id. It reports whether the envelope was kept.
+func (s *Receiver) set(p *senderPolicy, e *v2.Envelope) bool {
+	if p != nil && !p.authorize(e) {
+		return false
+	}
+
+	return accept(s.dataSetter, e)
+}
@@ -1,6 +1,7 @@
 package v2_test
 
 import (
+	"context"
 	"errors"
 	"io"
 	"metricemitter/testhelper"
@@ -104,6 +105,46 @@ var _ = Describe("Receiver", func() {
 	})
 })
 
+var _ = Describe("Receiver Send()", func() {
+	It("sets every envelope before returning the number accepted", func() {
+		spySetter := NewSpySetter()
+		spyMetrics := testhelper.NewMetricClient()
+		rx := ingress.NewReceiver(spySetter, spyMetrics)
+
+		e := &v2.Envelope{SourceId: "some-id"}
+		resp, err := rx.Send(context.Background(), &v2.EnvelopeBatch{
+			Batch: []*v2.Envelope{e, e, e},
+		})
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(resp.GetAccepted()).To(Equal(uint64(3)))
+		Expect(spySetter.envelopes).To(HaveLen(3))
+		Expect(spyMetrics.GetDelta("ingress")).To(Equal(uint64(3)))
+	})
+
+	It("does not count envelopes dropped by the data setter as accepted", func() {
+		spySetter := NewSpySetter()
+		spyMetrics := testhelper.NewMetricClient()
+		limiter := ingress.NewRateLimiter(
+			spySetter,
+			ingress.RateLimit{EnvelopesPerSecond: 0.001, Burst: 1},
+			nil,
+			spyMetrics,
+		)
+		rx := ingress.NewReceiver(limiter, spyMetrics)
+
+		e := &v2.Envelope{SourceId: "some-id"}
+		resp, err := rx.Send(context.Background(), &v2.EnvelopeBatch{
+			Batch: []*v2.Envelope{e, e},
+		})
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(resp.GetAccepted()).To(Equal(uint64(1)))
+		Expect(spySetter.envelopes).To(HaveLen(1))
+		Expect(spyMetrics.GetDelta("ingress")).To(Equal(uint64(2)))
+	})
+})
+
 type SenderRecvResponse struct {
 	envelope *v2.Envelope
 	err      error
@@ -117,6 +158,7 @@ type BatchSenderRecvResponse struct {
 type SpySender struct {
 	v2.Ingress_SenderServer
 	recvResponses chan SenderRecvResponse
+	ctx           context.Context
 }
 
 func NewSpySender() *SpySender {
@@ -131,9 +173,18 @@ func (s *SpySender) Recv() (*v2.Envelope, error) {
 	return resp.envelope, resp.err
 }
 
+func (s *SpySender) Context() context.Context {
+	if s.ctx == nil {
+		return context.Background()
+	}
+
+	return s.ctx
+}
+
 type SpyBatchSender struct {
 	v2.Ingress_BatchSenderServer
 	recvResponses chan BatchSenderRecvResponse
+	ctx           context.Context
 }
 
 func NewSpyBatchSender() *SpyBatchSender {
@@ -148,6 +199,14 @@ func (s *SpyBatchSender) Recv() (*v2.EnvelopeBatch, error) {
 	return &v2.EnvelopeBatch{Batch: resp.envelopes}, resp.err
 }
 
+func (s *SpyBatchSender) Context() context.Context {
+	if s.ctx == nil {
+		return context.Background()
+	}
+
+	return s.ctx
+}
+
 type SpySetter struct {
 	envelopes chan *v2.Envelope
 }
@@ -10,29 +10,56 @@ import (
 )
 
 type Server struct {
-	addr string
-	rx   *Receiver
-	opts []grpc.ServerOption
+	addr       string
+	grpcServer *grpc.Server
+	listen     func() (net.Listener, error)
 }
 
 func NewServer(addr string, rx *Receiver, opts ...grpc.ServerOption) *Server {
+	return newServer(addr, rx, opts, func() (net.Listener, error) {
+		return net.Listen("tcp", addr)
+	})
+}
+
+// NewUnixServer returns a Server that serves the Ingress API on the Unix
+// domain socket described by the given config. Access is controlled by the
+// socket's file permissions and, optionally, the peer credentials of the
+// connecting process.
+func NewUnixServer(conf UnixSocketConfig, rx *Receiver, opts ...grpc.ServerOption) *Server {
+	return newServer(conf.Path, rx, opts, func() (net.Listener, error) {
+		return ListenUnix(conf)
+	})
+}
+
+func newServer(
+	addr string,
+	rx *Receiver,
+	opts []grpc.ServerOption,
+	listen func() (net.Listener, error),
+) *Server {
+	grpcServer := grpc.NewServer(opts...)
+	v2.RegisterIngressServer(grpcServer, rx)
+
 	return &Server{
-		addr: addr,
-		rx:   rx,
-		opts: opts,
+		addr:       addr,
+		grpcServer: grpcServer,
+		listen:     listen,
 	}
 }
 
 func (s *Server) Start() {
-	lis, err := net.Listen("tcp", s.addr)
+	lis, err := s.listen()
 	if err != nil {
-		log.Fatalf("failed to listen: %v", err)
+		log.Fatalf("failed to listen on %s: %v", s.addr, err)
 	}
 
-	grpcServer := grpc.NewServer(s.opts...)
-	v2.RegisterIngressServer(grpcServer, s.rx)
-
-	if err := grpcServer.Serve(lis); err != nil {
+	if err := s.grpcServer.Serve(lis); err != nil && err != grpc.ErrServerStopped {
 		log.Fatalf("failed to serve: %v", err)
 	}
 }
+
+// Stop closes the listener and any open connections. Start returns once
+// the server has stopped.
+func (s *Server) Stop() {
+	s.grpcServer.Stop()
+}
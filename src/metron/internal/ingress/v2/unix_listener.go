@@ -0,0 +1,110 @@
+package v2
+
+import (
+	"log"
+	"net"
+	"os"
+)
+
+// UnixSocketConfig describes a Unix domain socket to listen on. When
+// AllowedUIDs or AllowedGIDs are set, connections are only accepted from
+// processes whose peer credentials match one of them.
+type UnixSocketConfig struct {
+	Path        string
+	FileMode    os.FileMode
+	AllowedUIDs []uint32
+	AllowedGIDs []uint32
+}
+
+// ListenUnix removes any stale socket at the configured path, listens on it
+// and applies the configured file mode.
+func ListenUnix(conf UnixSocketConfig) (net.Listener, error) {
+	if err := os.Remove(conf.Path); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	lis, err := net.Listen("unix", conf.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.Chmod(conf.Path, conf.FileMode); err != nil {
+		lis.Close()
+		return nil, err
+	}
+
+	return &peerCredListener{
+		Listener: lis,
+		uids:     conf.AllowedUIDs,
+		gids:     conf.AllowedGIDs,
+	}, nil
+}
+
+// PeerCredAddr is the remote address of connections accepted on the Unix
+// domain socket. It carries the peer credentials of the connecting process,
+// making them available to the Authorizer through the gRPC peer.
+type PeerCredAddr struct {
+	net.Addr
+	UID uint32
+	GID uint32
+}
+
+type peerCredConn struct {
+	net.Conn
+	addr PeerCredAddr
+}
+
+func (c *peerCredConn) RemoteAddr() net.Addr {
+	return c.addr
+}
+
+type peerCredListener struct {
+	net.Listener
+	uids []uint32
+	gids []uint32
+}
+
+// Accept waits for the next connection whose peer credentials are allowed.
+// Connections from other processes are closed. When no uids or gids are
+// allowed every connection is accepted, with its peer credentials if they
+// can be read.
+func (l *peerCredListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+
+		restricted := len(l.uids) > 0 || len(l.gids) > 0
+		uid, gid, err := peerCredentials(conn)
+		if err != nil && !restricted {
+			return conn, nil
+		}
+
+		if err != nil {
+			log.Printf("failed to read peer credentials: %s", err)
+			conn.Close()
+			continue
+		}
+
+		if restricted && !contains(l.uids, uid) && !contains(l.gids, gid) {
+			log.Printf("rejected unix socket connection from uid=%d gid=%d", uid, gid)
+			conn.Close()
+			continue
+		}
+
+		return &peerCredConn{
+			Conn: conn,
+			addr: PeerCredAddr{Addr: conn.RemoteAddr(), UID: uid, GID: gid},
+		}, nil
+	}
+}
+
+func contains(ids []uint32, id uint32) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
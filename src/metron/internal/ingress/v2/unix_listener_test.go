@@ -0,0 +1,116 @@
+package v2_test
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	ingress "metron/internal/ingress/v2"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ListenUnix", func() {
+	var (
+		dir  string
+		path string
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "metron-unix")
+		Expect(err).ToNot(HaveOccurred())
+		path = filepath.Join(dir, "metron.sock")
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("applies the configured file mode", func() {
+		lis, err := ingress.ListenUnix(ingress.UnixSocketConfig{
+			Path:     path,
+			FileMode: 0600,
+		})
+		Expect(err).ToNot(HaveOccurred())
+		defer lis.Close()
+
+		info, err := os.Stat(path)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(info.Mode().Perm()).To(Equal(os.FileMode(0600)))
+	})
+
+	It("replaces a stale socket file", func() {
+		Expect(ioutil.WriteFile(path, nil, 0600)).To(Succeed())
+
+		lis, err := ingress.ListenUnix(ingress.UnixSocketConfig{
+			Path:     path,
+			FileMode: 0600,
+		})
+		Expect(err).ToNot(HaveOccurred())
+		lis.Close()
+	})
+
+	Context("with allowed peer credentials", func() {
+		BeforeEach(func() {
+			if runtime.GOOS != "linux" {
+				Skip("peer credentials are only supported on linux")
+			}
+		})
+
+		It("accepts connections from an allowed uid", func() {
+			lis, err := ingress.ListenUnix(ingress.UnixSocketConfig{
+				Path:        path,
+				FileMode:    0600,
+				AllowedUIDs: []uint32{uint32(os.Getuid())},
+			})
+			Expect(err).ToNot(HaveOccurred())
+			defer lis.Close()
+
+			accepted := make(chan net.Conn, 1)
+			go func() {
+				conn, err := lis.Accept()
+				if err == nil {
+					accepted <- conn
+				}
+			}()
+
+			conn, err := net.Dial("unix", path)
+			Expect(err).ToNot(HaveOccurred())
+			defer conn.Close()
+
+			Eventually(accepted).Should(Receive())
+		})
+
+		It("closes connections from other uids", func() {
+			lis, err := ingress.ListenUnix(ingress.UnixSocketConfig{
+				Path:        path,
+				FileMode:    0600,
+				AllowedUIDs: []uint32{uint32(os.Getuid()) + 1},
+			})
+			Expect(err).ToNot(HaveOccurred())
+			defer lis.Close()
+
+			accepted := make(chan net.Conn, 1)
+			go func() {
+				conn, err := lis.Accept()
+				if err == nil {
+					accepted <- conn
+				}
+			}()
+
+			conn, err := net.Dial("unix", path)
+			Expect(err).ToNot(HaveOccurred())
+			defer conn.Close()
+
+			conn.SetReadDeadline(time.Now().Add(time.Second))
+			_, err = conn.Read(make([]byte, 1))
+			Expect(err).To(HaveOccurred())
+			Consistently(accepted).ShouldNot(Receive())
+		})
+	})
+})
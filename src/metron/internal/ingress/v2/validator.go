@@ -0,0 +1,233 @@
+package v2
+
+import (
+	"metricemitter"
+	v2 "plumbing/v2"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// ValidationLimits bounds the size of v2 envelopes. A zero limit means
+// unlimited.
+type ValidationLimits struct {
+	// RequireFields drops envelopes with no source_id, no message or a
+	// message missing its required fields.
+	RequireFields bool
+
+	// MaxPayloadBytes is the largest log payload. Longer payloads are
+	// truncated and tagged with truncated=true.
+	MaxPayloadBytes int
+
+	// MaxTags is the largest number of tags an envelope may have.
+	MaxTags int
+
+	// MaxTagKeyBytes and MaxTagValueBytes are the longest tag name and text
+	// value an envelope may have.
+	MaxTagKeyBytes   int
+	MaxTagValueBytes int
+
+	// MaxFutureSkew is how far ahead of the time it is received an
+	// envelope's timestamp may be.
+	MaxFutureSkew time.Duration
+}
+
+// Validator is a DataSetter that drops malformed envelopes before handing
+// the rest on to the next DataSetter. Envelopes are dropped when they have
+// nil tags or gauge values, tags beyond the limits or, if RequireFields is
+// set, missing fields. Log payloads beyond the limit are truncated and
+// timestamps that are zero or too far in the future are replaced with the
+// time the envelope was received.
+type Validator struct {
+	setter       DataSetter
+	limits       ValidationLimits
+	metricClient metricemitter.MetricClient
+
+	truncatedMetric *metricemitter.CounterMetric
+	timestampMetric *metricemitter.CounterMetric
+
+	mu             sync.Mutex
+	droppedMetrics map[string]*metricemitter.CounterMetric
+}
+
+// NewValidator returns a Validator.
+func NewValidator(
+	setter DataSetter,
+	limits ValidationLimits,
+	metricClient metricemitter.MetricClient,
+) *Validator {
+	return &Validator{
+		setter:       setter,
+		limits:       limits,
+		metricClient: metricClient,
+		truncatedMetric: metricClient.NewCounterMetric("truncated",
+			metricemitter.WithVersion(2, 0),
+		),
+		timestampMetric: metricClient.NewCounterMetric("replaced_timestamps",
+			metricemitter.WithVersion(2, 0),
+		),
+		droppedMetrics: make(map[string]*metricemitter.CounterMetric),
+	}
+}
+
+// Set forwards the envelope if it is valid.
+func (v *Validator) Set(e *v2.Envelope) {
+	v.Accept(e)
+}
+
+// Accept forwards the envelope if it is valid and reports whether the next
+// DataSetter kept it.
+func (v *Validator) Accept(e *v2.Envelope) bool {
+	if reason := v.invalid(e); reason != "" {
+		// metric-documentation-v2: (loggregator.metron.dropped) Number of v2
+		// envelopes dropped by ingress validation by reason
+		v.droppedMetric(reason).Increment(1)
+		return false
+	}
+
+	v.truncate(e)
+	v.fixTimestamp(e)
+
+	return accept(v.setter, e)
+}
+
+// invalid returns the reason the envelope should be dropped, or an empty
+// string if it is valid.
+func (v *Validator) invalid(e *v2.Envelope) string {
+	if v.limits.RequireFields {
+		if reason := missingFields(e); reason != "" {
+			return reason
+		}
+	}
+
+	for _, m := range e.GetGauge().GetMetrics() {
+		if m == nil {
+			return "invalid_gauge"
+		}
+	}
+
+	if v.limits.MaxTags > 0 && len(e.GetTags()) > v.limits.MaxTags {
+		return "too_many_tags"
+	}
+
+	for k, t := range e.GetTags() {
+		if t == nil {
+			return "invalid_tag"
+		}
+
+		if v.limits.MaxTagKeyBytes > 0 && len(k) > v.limits.MaxTagKeyBytes {
+			return "tag_key_too_long"
+		}
+
+		if v.limits.MaxTagValueBytes > 0 && len(t.GetText()) > v.limits.MaxTagValueBytes {
+			return "tag_value_too_long"
+		}
+	}
+
+	return ""
+}
+
+// missingFields returns the reason the envelope is missing required
+// fields, or an empty string if it has them all.
+func missingFields(e *v2.Envelope) string {
+	if e.GetSourceId() == "" {
+		return "missing_source_id"
+	}
+
+	switch m := e.GetMessage().(type) {
+	case *v2.Envelope_Log:
+		if m.Log == nil {
+			return "missing_message"
+		}
+	case *v2.Envelope_Counter:
+		if m.Counter.GetName() == "" {
+			return "invalid_counter"
+		}
+	case *v2.Envelope_Gauge:
+		if !validGauge(m.Gauge) {
+			return "invalid_gauge"
+		}
+	case *v2.Envelope_Timer:
+		if m.Timer.GetName() == "" || m.Timer.GetStop() < m.Timer.GetStart() {
+			return "invalid_timer"
+		}
+	default:
+		return "missing_message"
+	}
+
+	return ""
+}
+
+func validGauge(g *v2.Gauge) bool {
+	if len(g.GetMetrics()) == 0 {
+		return false
+	}
+
+	for name := range g.GetMetrics() {
+		if name == "" {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (v *Validator) truncate(e *v2.Envelope) {
+	l := e.GetLog()
+	if v.limits.MaxPayloadBytes <= 0 || len(l.GetPayload()) <= v.limits.MaxPayloadBytes {
+		return
+	}
+
+	// Avoid splitting a multi-byte character.
+	n := v.limits.MaxPayloadBytes
+	for n > 0 && !utf8.RuneStart(l.Payload[n]) {
+		n--
+	}
+	l.Payload = l.Payload[:n]
+
+	if e.Tags == nil {
+		e.Tags = make(map[string]*v2.Value)
+	}
+	e.Tags["truncated"] = &v2.Value{
+		Data: &v2.Value_Text{Text: "true"},
+	}
+
+	// metric-documentation-v2: (loggregator.metron.truncated) Number of v2
+	// log envelopes whose payload was truncated on ingress
+	v.truncatedMetric.Increment(1)
+}
+
+func (v *Validator) fixTimestamp(e *v2.Envelope) {
+	now := time.Now()
+	if e.Timestamp > 0 {
+		if v.limits.MaxFutureSkew <= 0 || e.Timestamp <= now.Add(v.limits.MaxFutureSkew).UnixNano() {
+			return
+		}
+	}
+
+	e.Timestamp = now.UnixNano()
+
+	// metric-documentation-v2: (loggregator.metron.replaced_timestamps)
+	// Number of v2 envelopes received with a zero or future timestamp that
+	// was replaced with the time they were received
+	v.timestampMetric.Increment(1)
+}
+
+func (v *Validator) droppedMetric(reason string) *metricemitter.CounterMetric {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	m, ok := v.droppedMetrics[reason]
+	if !ok {
+		m = v.metricClient.NewCounterMetric("dropped",
+			metricemitter.WithVersion(2, 0),
+			metricemitter.WithTags(map[string]string{
+				"direction": "ingress",
+				"reason":    reason,
+			}),
+		)
+		v.droppedMetrics[reason] = m
+	}
+
+	return m
+}
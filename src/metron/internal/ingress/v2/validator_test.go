@@ -0,0 +1,137 @@
+package v2_test
+
+import (
+	"metricemitter/testhelper"
+	"strings"
+	"time"
+
+	ingress "metron/internal/ingress/v2"
+	v2 "plumbing/v2"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Validator", func() {
+	var (
+		spySetter  *SpySetter
+		spyMetrics *testhelper.SpyMetricClient
+		validator  *ingress.Validator
+	)
+
+	BeforeEach(func() {
+		spySetter = NewSpySetter()
+		spyMetrics = testhelper.NewMetricClient()
+		validator = ingress.NewValidator(spySetter, ingress.ValidationLimits{
+			RequireFields:    true,
+			MaxPayloadBytes:  10,
+			MaxTags:          2,
+			MaxTagKeyBytes:   5,
+			MaxTagValueBytes: 5,
+			MaxFutureSkew:    time.Minute,
+		}, spyMetrics)
+	})
+
+	counter := func() *v2.Envelope {
+		return &v2.Envelope{
+			SourceId:  "some-id",
+			Timestamp: time.Now().UnixNano(),
+			Message: &v2.Envelope_Counter{
+				Counter: &v2.Counter{Name: "requests"},
+			},
+		}
+	}
+
+	text := func(s string) *v2.Value {
+		return &v2.Value{Data: &v2.Value_Text{Text: s}}
+	}
+
+	It("forwards valid envelopes unchanged", func() {
+		e := counter()
+		e.Tags = map[string]*v2.Value{"a": text("b")}
+		validator.Set(e)
+
+		Expect(spySetter.envelopes).To(Receive(Equal(e)))
+	})
+
+	It("forwards envelopes without a source_id when fields are not required", func() {
+		validator = ingress.NewValidator(spySetter, ingress.ValidationLimits{}, spyMetrics)
+		e := counter()
+		e.SourceId = ""
+		validator.Set(e)
+
+		Expect(spySetter.envelopes).To(Receive(Equal(e)))
+	})
+
+	DescribeTable("drops invalid envelopes",
+		func(modify func(*v2.Envelope)) {
+			e := counter()
+			modify(e)
+			validator.Set(e)
+
+			Expect(spySetter.envelopes).ToNot(Receive())
+			Expect(spyMetrics.GetDelta("dropped")).To(Equal(uint64(1)))
+		},
+		Entry("missing source_id", func(e *v2.Envelope) {
+			e.SourceId = ""
+		}),
+		Entry("missing message", func(e *v2.Envelope) {
+			e.Message = nil
+		}),
+		Entry("counter without a name", func(e *v2.Envelope) {
+			e.GetCounter().Name = ""
+		}),
+		Entry("gauge without metrics", func(e *v2.Envelope) {
+			e.Message = &v2.Envelope_Gauge{Gauge: &v2.Gauge{}}
+		}),
+		Entry("timer ending before it starts", func(e *v2.Envelope) {
+			e.Message = &v2.Envelope_Timer{Timer: &v2.Timer{Name: "t", Start: 2, Stop: 1}}
+		}),
+		Entry("too many tags", func(e *v2.Envelope) {
+			e.Tags = map[string]*v2.Value{"a": text("1"), "b": text("2"), "c": text("3")}
+		}),
+		Entry("long tag key", func(e *v2.Envelope) {
+			e.Tags = map[string]*v2.Value{"abcdef": text("1")}
+		}),
+		Entry("long tag value", func(e *v2.Envelope) {
+			e.Tags = map[string]*v2.Value{"a": text("abcdef")}
+		}),
+		Entry("nil tag", func(e *v2.Envelope) {
+			e.Tags = map[string]*v2.Value{"a": nil}
+		}),
+	)
+
+	It("truncates long log payloads without splitting characters", func() {
+		validator.Set(&v2.Envelope{
+			SourceId:  "some-id",
+			Timestamp: 1,
+			Message: &v2.Envelope_Log{
+				Log: &v2.Log{Payload: []byte("123456789" + "é" + strings.Repeat("x", 100))},
+			},
+		})
+
+		var e *v2.Envelope
+		Expect(spySetter.envelopes).To(Receive(&e))
+		Expect(e.GetLog().GetPayload()).To(Equal([]byte("123456789")))
+		Expect(e.GetTags()["truncated"].GetText()).To(Equal("true"))
+		Expect(spyMetrics.GetDelta("truncated")).To(Equal(uint64(1)))
+	})
+
+	It("replaces zero and far future timestamps", func() {
+		zero := counter()
+		zero.Timestamp = 0
+		future := counter()
+		future.Timestamp = time.Now().Add(time.Hour).UnixNano()
+
+		validator.Set(zero)
+		validator.Set(future)
+
+		var e *v2.Envelope
+		Expect(spySetter.envelopes).To(Receive(&e))
+		Expect(e.GetTimestamp()).To(BeNumerically("~", time.Now().UnixNano(), int64(time.Second)))
+		Expect(spySetter.envelopes).To(Receive(&e))
+		Expect(e.GetTimestamp()).To(BeNumerically("~", time.Now().UnixNano(), int64(time.Second)))
+		Expect(spyMetrics.GetDelta("replaced_timestamps")).To(Equal(uint64(2)))
+	})
+})
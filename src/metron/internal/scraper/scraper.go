@@ -0,0 +1,285 @@
+package scraper
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"metricemitter"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	v2 "plumbing/v2"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+// maxTrackedCounters bounds the number of counters whose last value is kept
+// between scrapes.
+const maxTrackedCounters = 10000
+
+// DataSetter is the destination of scraped envelopes.
+type DataSetter interface {
+	Set(e *v2.Envelope)
+}
+
+// Target is a Prometheus text format endpoint. Every envelope scraped from
+// the target has its SourceID and Tags. Tags take precedence over labels of
+// the same name.
+type Target struct {
+	URL      string
+	SourceID string
+	Tags     map[string]string
+}
+
+// Scraper periodically scrapes Prometheus text format endpoints and
+// converts the metrics to v2 envelopes. Counters become counter deltas from
+// the previous scrape, which egress accumulates back into totals. Gauges,
+// untyped metrics, histograms and summaries become gauges.
+type Scraper struct {
+	targets  []Target
+	setter   DataSetter
+	interval time.Duration
+	client   *http.Client
+
+	scrapedMetric *metricemitter.CounterMetric
+	failedMetric  *metricemitter.CounterMetric
+
+	// counters holds the last value of every counter by source_id, name
+	// and labels.
+	counters map[string]uint64
+}
+
+// ScraperOption is a type that will manipulate a Scraper
+type ScraperOption func(*Scraper)
+
+// WithHTTPClient sets the client used to scrape targets. The default
+// client times out after the scrape interval.
+func WithHTTPClient(c *http.Client) ScraperOption {
+	return func(s *Scraper) {
+		s.client = c
+	}
+}
+
+// New returns a Scraper that scrapes every target each interval and sets
+// the resulting envelopes on setter.
+func New(
+	targets []Target,
+	setter DataSetter,
+	interval time.Duration,
+	metricClient metricemitter.MetricClient,
+	opts ...ScraperOption,
+) *Scraper {
+	s := &Scraper{
+		targets:  targets,
+		setter:   setter,
+		interval: interval,
+		client:   &http.Client{Timeout: interval},
+		counters: make(map[string]uint64),
+		scrapedMetric: metricClient.NewCounterMetric("scraped",
+			metricemitter.WithVersion(2, 0),
+		),
+		failedMetric: metricClient.NewCounterMetric("failed_scrapes",
+			metricemitter.WithVersion(2, 0),
+		),
+	}
+
+	for _, o := range opts {
+		o(s)
+	}
+
+	return s
+}
+
+// Start scrapes every target each interval. It does not return.
+func (s *Scraper) Start() {
+	s.Scrape()
+	for range time.Tick(s.interval) {
+		s.Scrape()
+	}
+}
+
+// Scrape scrapes every target once. It is not safe to call concurrently.
+func (s *Scraper) Scrape() {
+	for _, t := range s.targets {
+		n, err := s.scrape(t)
+		if err != nil {
+			// metric-documentation-v2: (loggregator.metron.failed_scrapes)
+			// Number of failed scrapes of Prometheus endpoints
+			s.failedMetric.Increment(1)
+
+			log.Printf("failed to scrape %s: %s", t.URL, err)
+			continue
+		}
+
+		// metric-documentation-v2: (loggregator.metron.scraped) Number of
+		// envelopes converted from scraped Prometheus endpoints
+		s.scrapedMetric.Increment(uint64(n))
+	}
+}
+
+func (s *Scraper) scrape(t Target) (int, error) {
+	resp, err := s.client.Get(t.URL)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(resp.Body)
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now().UnixNano()
+	var n int
+	for _, family := range families {
+		for _, m := range family.GetMetric() {
+			if family.GetType() == dto.MetricType_COUNTER {
+				s.setter.Set(s.counter(t, family.GetName(), m, now))
+				n++
+				continue
+			}
+
+			for _, e := range convert(family.GetName(), family.GetType(), m) {
+				setCommon(e, t, m, now)
+				s.setter.Set(e)
+				n++
+			}
+		}
+	}
+
+	return n, nil
+}
+
+// counter returns a counter envelope with the increase of the counter since
+// the previous scrape. The first scrape of a counter, and any scrape after
+// the counter was reset, reports the whole value.
+func (s *Scraper) counter(t Target, name string, m *dto.Metric, now int64) *v2.Envelope {
+	value := m.GetCounter().GetValue()
+	if value < 0 || math.IsNaN(value) {
+		value = 0
+	}
+	total := uint64(value)
+
+	key := counterKey(t.SourceID, name, m.GetLabel())
+	delta := total
+	if last, ok := s.counters[key]; ok && total >= last {
+		delta = total - last
+	}
+
+	if _, ok := s.counters[key]; !ok && len(s.counters) >= maxTrackedCounters {
+		s.counters = make(map[string]uint64)
+	}
+	s.counters[key] = total
+
+	e := &v2.Envelope{
+		Message: &v2.Envelope_Counter{
+			Counter: &v2.Counter{
+				Name:  name,
+				Value: &v2.Counter_Delta{Delta: delta},
+			},
+		},
+	}
+	setCommon(e, t, m, now)
+
+	return e
+}
+
+func setCommon(e *v2.Envelope, t Target, m *dto.Metric, now int64) {
+	e.SourceId = t.SourceID
+	e.Timestamp = now
+	if m.TimestampMs != nil {
+		e.Timestamp = m.GetTimestampMs() * int64(time.Millisecond)
+	}
+	e.Tags = tags(m.GetLabel(), t.Tags, e.Tags)
+}
+
+// convert returns the envelopes for a single gauge, untyped, histogram or
+// summary metric. Envelopes for histogram buckets and summary quantiles
+// carry their "le" and "quantile" labels as tags.
+func convert(name string, t dto.MetricType, m *dto.Metric) []*v2.Envelope {
+	switch t {
+	case dto.MetricType_GAUGE:
+		return []*v2.Envelope{gauge(map[string]float64{name: m.GetGauge().GetValue()})}
+	case dto.MetricType_UNTYPED:
+		return []*v2.Envelope{gauge(map[string]float64{name: m.GetUntyped().GetValue()})}
+	case dto.MetricType_HISTOGRAM:
+		h := m.GetHistogram()
+		envelopes := []*v2.Envelope{gauge(map[string]float64{
+			name + "_count": float64(h.GetSampleCount()),
+			name + "_sum":   h.GetSampleSum(),
+		})}
+		for _, b := range h.GetBucket() {
+			e := gauge(map[string]float64{name + "_bucket": float64(b.GetCumulativeCount())})
+			e.Tags = map[string]*v2.Value{"le": textValue(formatFloat(b.GetUpperBound()))}
+			envelopes = append(envelopes, e)
+		}
+		return envelopes
+	case dto.MetricType_SUMMARY:
+		s := m.GetSummary()
+		envelopes := []*v2.Envelope{gauge(map[string]float64{
+			name + "_count": float64(s.GetSampleCount()),
+			name + "_sum":   s.GetSampleSum(),
+		})}
+		for _, q := range s.GetQuantile() {
+			e := gauge(map[string]float64{name: q.GetValue()})
+			e.Tags = map[string]*v2.Value{"quantile": textValue(formatFloat(q.GetQuantile()))}
+			envelopes = append(envelopes, e)
+		}
+		return envelopes
+	}
+
+	return nil
+}
+
+func gauge(values map[string]float64) *v2.Envelope {
+	metrics := make(map[string]*v2.GaugeValue, len(values))
+	for name, v := range values {
+		metrics[name] = &v2.GaugeValue{Value: v}
+	}
+
+	return &v2.Envelope{
+		Message: &v2.Envelope_Gauge{
+			Gauge: &v2.Gauge{Metrics: metrics},
+		},
+	}
+}
+
+func tags(labels []*dto.LabelPair, targetTags map[string]string, extra map[string]*v2.Value) map[string]*v2.Value {
+	result := make(map[string]*v2.Value, len(labels)+len(targetTags)+len(extra))
+	for _, l := range labels {
+		result[l.GetName()] = textValue(l.GetValue())
+	}
+	for k, v := range extra {
+		result[k] = v
+	}
+	for k, v := range targetTags {
+		result[k] = textValue(v)
+	}
+
+	return result
+}
+
+func counterKey(sourceID, name string, labels []*dto.LabelPair) string {
+	parts := []string{sourceID, name}
+	for _, l := range labels {
+		parts = append(parts, l.GetName()+"="+l.GetValue())
+	}
+
+	return strings.Join(parts, "\x00")
+}
+
+func textValue(s string) *v2.Value {
+	return &v2.Value{Data: &v2.Value_Text{Text: s}}
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,181 @@
+package scraper_test
+
+import (
+	"fmt"
+	"metricemitter/testhelper"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"metron/internal/scraper"
+	v2 "plumbing/v2"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Scraper", func() {
+	var (
+		setter *spySetter
+		spy    *testhelper.SpyMetricClient
+		body   string
+		server *httptest.Server
+	)
+
+	BeforeEach(func() {
+		setter = &spySetter{}
+		spy = testhelper.NewMetricClient()
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, body)
+		}))
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	newScraper := func(tags map[string]string) *scraper.Scraper {
+		return scraper.New(
+			[]scraper.Target{{URL: server.URL, SourceID: "some-id", Tags: tags}},
+			setter,
+			time.Hour,
+			spy,
+		)
+	}
+
+	scrape := func(tags map[string]string) {
+		newScraper(tags).Scrape()
+	}
+
+	It("converts counters to counters", func() {
+		body = `
+# TYPE requests_total counter
+requests_total{code="200"} 42
+`
+		scrape(nil)
+
+		Expect(setter.envelopes).To(HaveLen(1))
+		e := setter.envelopes[0]
+		Expect(e.GetSourceId()).To(Equal("some-id"))
+		Expect(e.GetTimestamp()).ToNot(BeZero())
+		Expect(e.GetCounter().GetName()).To(Equal("requests_total"))
+		Expect(e.GetCounter().GetDelta()).To(Equal(uint64(42)))
+		Expect(e.GetTags()["code"].GetText()).To(Equal("200"))
+		Expect(spy.GetDelta("scraped")).To(Equal(uint64(1)))
+	})
+
+	It("reports the increase of counters since the previous scrape", func() {
+		s := newScraper(nil)
+
+		var deltas []uint64
+		for _, value := range []int{10, 25, 5} {
+			body = fmt.Sprintf("# TYPE requests_total counter\nrequests_total %d\n", value)
+			s.Scrape()
+			deltas = append(deltas, setter.envelopes[len(setter.envelopes)-1].GetCounter().GetDelta())
+		}
+
+		Expect(deltas).To(Equal([]uint64{10, 15, 5}))
+	})
+
+	It("converts gauges and untyped metrics to gauges", func() {
+		body = `
+# TYPE temperature gauge
+temperature 21.5
+untyped_value 3
+`
+		scrape(nil)
+
+		values := map[string]float64{}
+		for _, e := range setter.envelopes {
+			for name, v := range e.GetGauge().GetMetrics() {
+				values[name] = v.GetValue()
+			}
+		}
+		Expect(values).To(Equal(map[string]float64{
+			"temperature":   21.5,
+			"untyped_value": 3,
+		}))
+	})
+
+	It("converts histograms to gauges", func() {
+		body = `
+# TYPE latency histogram
+latency_bucket{le="0.1"} 1
+latency_bucket{le="1"} 3
+latency_bucket{le="+Inf"} 4
+latency_sum 5.5
+latency_count 4
+`
+		scrape(nil)
+
+		buckets := map[string]float64{}
+		for _, e := range setter.envelopes {
+			metrics := e.GetGauge().GetMetrics()
+			if b, ok := metrics["latency_bucket"]; ok {
+				buckets[e.GetTags()["le"].GetText()] = b.GetValue()
+				continue
+			}
+			Expect(metrics["latency_count"].GetValue()).To(Equal(4.0))
+			Expect(metrics["latency_sum"].GetValue()).To(Equal(5.5))
+		}
+		Expect(buckets).To(Equal(map[string]float64{
+			"0.1":  1,
+			"1":    3,
+			"+Inf": 4,
+		}))
+	})
+
+	It("converts summaries to gauges", func() {
+		body = `
+# TYPE rpc summary
+rpc{quantile="0.5"} 0.2
+rpc{quantile="0.99"} 0.9
+rpc_sum 10
+rpc_count 20
+`
+		scrape(nil)
+
+		quantiles := map[string]float64{}
+		for _, e := range setter.envelopes {
+			metrics := e.GetGauge().GetMetrics()
+			if q, ok := metrics["rpc"]; ok {
+				quantiles[e.GetTags()["quantile"].GetText()] = q.GetValue()
+				continue
+			}
+			Expect(metrics["rpc_count"].GetValue()).To(Equal(20.0))
+			Expect(metrics["rpc_sum"].GetValue()).To(Equal(10.0))
+		}
+		Expect(quantiles).To(Equal(map[string]float64{
+			"0.5":  0.2,
+			"0.99": 0.9,
+		}))
+	})
+
+	It("adds the target's tags in favor of labels", func() {
+		body = `
+temperature{zone="a",host="vm"} 1
+`
+		scrape(map[string]string{"zone": "z1"})
+
+		Expect(setter.envelopes).To(HaveLen(1))
+		tags := setter.envelopes[0].GetTags()
+		Expect(tags["zone"].GetText()).To(Equal("z1"))
+		Expect(tags["host"].GetText()).To(Equal("vm"))
+	})
+
+	It("counts failed scrapes", func() {
+		body = "not { valid"
+		scrape(nil)
+
+		Expect(setter.envelopes).To(BeEmpty())
+		Expect(spy.GetDelta("failed_scrapes")).To(Equal(uint64(1)))
+	})
+})
+
+type spySetter struct {
+	envelopes []*v2.Envelope
+}
+
+func (s *spySetter) Set(e *v2.Envelope) {
+	s.envelopes = append(s.envelopes, e)
+}
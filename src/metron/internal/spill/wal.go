@@ -0,0 +1,377 @@
+package spill
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"metricemitter"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+
+	plumbing "plumbing/v2"
+)
+
+const (
+	segmentSuffix      = ".wal"
+	recordHeaderLength = 12
+
+	defaultSegmentSize   = 4 * 1024 * 1024
+	defaultReplayBatches = 10
+)
+
+type segment struct {
+	id   uint64
+	path string
+	size int64
+}
+
+// WAL is a size bounded, on disk write-ahead log of envelope batches. Batches
+// are appended to segment files within a directory and are replayed oldest
+// first. When the log grows beyond its maximum size the oldest segments are
+// discarded.
+type WAL struct {
+	mu sync.Mutex
+
+	dir           string
+	maxBytes      int64
+	maxAge        time.Duration
+	segmentSize   int64
+	replayBatches int
+
+	segments   []*segment
+	active     *os.File
+	readOffset int64
+
+	// totalBytes is the size of the batches not yet replayed or expired.
+	totalBytes int64
+
+	spilledMetric  *metricemitter.CounterMetric
+	replayedMetric *metricemitter.CounterMetric
+	expiredMetric  *metricemitter.CounterMetric
+}
+
+// WALOption is a type that will manipulate a WAL
+type WALOption func(*WAL)
+
+// WithSegmentSize sets the number of bytes after which a new segment file is
+// started.
+func WithSegmentSize(size int64) WALOption {
+	return func(w *WAL) {
+		w.segmentSize = size
+	}
+}
+
+// WithReplayBatches sets the most batches written by each call to Replay.
+func WithReplayBatches(n int) WALOption {
+	return func(w *WAL) {
+		w.replayBatches = n
+	}
+}
+
+// New returns a WAL that stores its segments in dir. Any segments left over
+// from a previous run are picked up and will be replayed. A maxAge of zero
+// disables expiry based on age.
+func New(
+	dir string,
+	maxBytes int64,
+	maxAge time.Duration,
+	metricClient metricemitter.MetricClient,
+	opts ...WALOption,
+) (*WAL, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	w := &WAL{
+		dir:           dir,
+		maxBytes:      maxBytes,
+		maxAge:        maxAge,
+		segmentSize:   defaultSegmentSize,
+		replayBatches: defaultReplayBatches,
+		spilledMetric: metricClient.NewCounterMetric("spilled_bytes",
+			metricemitter.WithVersion(2, 0),
+		),
+		replayedMetric: metricClient.NewCounterMetric("replayed_bytes",
+			metricemitter.WithVersion(2, 0),
+		),
+		expiredMetric: metricClient.NewCounterMetric("expired_bytes",
+			metricemitter.WithVersion(2, 0),
+		),
+	}
+
+	for _, o := range opts {
+		o(w)
+	}
+
+	// Keep several segments within the size limit so that discarding the
+	// oldest one does not throw away most of the log.
+	if maxBytes > 0 && w.segmentSize > maxBytes/4 {
+		w.segmentSize = maxBytes/4 + 1
+	}
+
+	if err := w.loadSegments(); err != nil {
+		return nil, err
+	}
+
+	return w, nil
+}
+
+// Spill appends the batch to the log.
+func (w *WAL) Spill(batch []*plumbing.Envelope) error {
+	data, err := proto.Marshal(&plumbing.EnvelopeBatch{Batch: batch})
+	if err != nil {
+		return err
+	}
+
+	record := make([]byte, recordHeaderLength+len(data))
+	binary.BigEndian.PutUint64(record[0:8], uint64(time.Now().UnixNano()))
+	binary.BigEndian.PutUint32(record[8:12], uint32(len(data)))
+	copy(record[recordHeaderLength:], data)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	f, seg, err := w.activeSegment()
+	if err != nil {
+		return err
+	}
+
+	n, err := f.Write(record)
+	seg.size += int64(n)
+	w.totalBytes += int64(n)
+	if err != nil {
+		return err
+	}
+
+	// metric-documentation-v2: (loggregator.metron.spilled_bytes) Number of
+	// bytes written to the egress spill buffer when Dopplers are unreachable
+	w.spilledMetric.Increment(uint64(n))
+
+	w.enforceMaxBytes()
+
+	return nil
+}
+
+// Replay passes up to the configured number of spilled batches to write,
+// oldest first, so that writing a large log does not hold up newer batches.
+// It stops at the first failed write, leaving that batch in the log to be
+// retried.
+func (w *WAL) Replay(write func([]*plumbing.Envelope) error) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var replayed int
+	for len(w.segments) > 0 && replayed < w.replayBatches {
+		head := w.segments[0]
+		if len(w.segments) == 1 && w.active != nil {
+			w.closeActive()
+		}
+
+		n, done, err := w.replayHead(head, write, w.replayBatches-replayed)
+		replayed += n
+		if err != nil || !done {
+			return err
+		}
+
+		w.removeHead()
+	}
+
+	return nil
+}
+
+// replayHead writes up to max batches from the head segment, starting at
+// readOffset. It reports whether the segment was read to the end.
+func (w *WAL) replayHead(
+	head *segment,
+	write func([]*plumbing.Envelope) error,
+	max int,
+) (int, bool, error) {
+	f, err := os.Open(head.path)
+	if err != nil {
+		return 0, false, err
+	}
+	defer f.Close()
+
+	if _, err := f.Seek(w.readOffset, io.SeekStart); err != nil {
+		return 0, false, err
+	}
+	r := bufio.NewReader(f)
+
+	var replayed int
+	for w.readOffset < head.size {
+		if replayed >= max {
+			return replayed, false, nil
+		}
+
+		ts, batch, n, err := readRecord(r)
+		if err != nil {
+			log.Printf("discarding corrupt spill segment %s: %s", head.path, err)
+			w.expire(head.size - w.readOffset)
+			return replayed, true, nil
+		}
+
+		if w.maxAge > 0 && time.Since(ts) > w.maxAge {
+			w.expire(int64(n))
+			w.consume(int64(n))
+			continue
+		}
+
+		if err := write(batch.Batch); err != nil {
+			return replayed, false, err
+		}
+
+		// metric-documentation-v2: (loggregator.metron.replayed_bytes) Number
+		// of bytes replayed from the egress spill buffer to Dopplers
+		w.replayedMetric.Increment(uint64(n))
+		w.consume(int64(n))
+		replayed++
+	}
+
+	return replayed, true, nil
+}
+
+// consume advances past n bytes of the head segment that have been
+// replayed or expired.
+func (w *WAL) consume(n int64) {
+	w.readOffset += n
+	w.totalBytes -= n
+}
+
+// Empty reports whether there are batches waiting to be replayed.
+func (w *WAL) Empty() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return len(w.segments) == 0
+}
+
+func (w *WAL) loadSegments() error {
+	files, err := ioutil.ReadDir(w.dir)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), segmentSuffix) {
+			continue
+		}
+
+		id, err := strconv.ParseUint(strings.TrimSuffix(f.Name(), segmentSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+
+		w.segments = append(w.segments, &segment{
+			id:   id,
+			path: filepath.Join(w.dir, f.Name()),
+			size: f.Size(),
+		})
+		w.totalBytes += f.Size()
+	}
+
+	sort.Sort(byID(w.segments))
+	w.enforceMaxBytes()
+
+	return nil
+}
+
+func (w *WAL) activeSegment() (*os.File, *segment, error) {
+	if w.active != nil {
+		seg := w.segments[len(w.segments)-1]
+		if seg.size < w.segmentSize {
+			return w.active, seg, nil
+		}
+		w.closeActive()
+	}
+
+	var id uint64
+	if len(w.segments) > 0 {
+		id = w.segments[len(w.segments)-1].id + 1
+	}
+
+	seg := &segment{
+		id:   id,
+		path: filepath.Join(w.dir, fmt.Sprintf("%020d%s", id, segmentSuffix)),
+	}
+
+	f, err := os.OpenFile(seg.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	w.active = f
+	w.segments = append(w.segments, seg)
+
+	return f, seg, nil
+}
+
+func (w *WAL) closeActive() {
+	if err := w.active.Close(); err != nil {
+		log.Printf("failed to close spill segment: %s", err)
+	}
+	w.active = nil
+}
+
+func (w *WAL) enforceMaxBytes() {
+	for w.maxBytes > 0 && w.totalBytes > w.maxBytes && len(w.segments) > 1 {
+		w.expire(w.segments[0].size - w.readOffset)
+		w.removeHead()
+	}
+}
+
+func (w *WAL) expire(n int64) {
+	if n <= 0 {
+		return
+	}
+
+	// metric-documentation-v2: (loggregator.metron.expired_bytes) Number of
+	// bytes discarded from the egress spill buffer due to size or age limits
+	w.expiredMetric.Increment(uint64(n))
+}
+
+func (w *WAL) removeHead() {
+	head := w.segments[0]
+	if err := os.Remove(head.path); err != nil && !os.IsNotExist(err) {
+		log.Printf("failed to remove spill segment %s: %s", head.path, err)
+	}
+
+	w.totalBytes -= head.size - w.readOffset
+	w.readOffset = 0
+	w.segments = w.segments[1:]
+}
+
+func readRecord(r io.Reader) (time.Time, *plumbing.EnvelopeBatch, int, error) {
+	header := make([]byte, recordHeaderLength)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return time.Time{}, nil, 0, err
+	}
+
+	ts := time.Unix(0, int64(binary.BigEndian.Uint64(header[0:8])))
+	data := make([]byte, binary.BigEndian.Uint32(header[8:12]))
+	if _, err := io.ReadFull(r, data); err != nil {
+		return time.Time{}, nil, 0, err
+	}
+
+	var batch plumbing.EnvelopeBatch
+	if err := proto.Unmarshal(data, &batch); err != nil {
+		return time.Time{}, nil, 0, err
+	}
+
+	return ts, &batch, recordHeaderLength + len(data), nil
+}
+
+type byID []*segment
+
+func (s byID) Len() int           { return len(s) }
+func (s byID) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byID) Less(i, j int) bool { return s[i].id < s[j].id }
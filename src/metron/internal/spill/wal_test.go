@@ -0,0 +1,169 @@
+package spill_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"metricemitter/testhelper"
+	"metron/internal/spill"
+	plumbing "plumbing/v2"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("WAL", func() {
+	var (
+		dir        string
+		spyMetrics *testhelper.SpyMetricClient
+		written    [][]*plumbing.Envelope
+		writeErr   error
+	)
+
+	write := func(batch []*plumbing.Envelope) error {
+		if writeErr != nil {
+			return writeErr
+		}
+		written = append(written, batch)
+		return nil
+	}
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "spill")
+		Expect(err).ToNot(HaveOccurred())
+
+		spyMetrics = testhelper.NewMetricClient()
+		written = nil
+		writeErr = nil
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("replays spilled batches oldest first", func() {
+		wal, err := spill.New(dir, 0, 0, spyMetrics)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(wal.Spill(buildBatch("first"))).To(Succeed())
+		Expect(wal.Spill(buildBatch("second"))).To(Succeed())
+
+		Expect(wal.Replay(write)).To(Succeed())
+		Expect(written).To(HaveLen(2))
+		Expect(written[0][0].SourceId).To(Equal("first"))
+		Expect(written[1][0].SourceId).To(Equal("second"))
+		Expect(wal.Empty()).To(BeTrue())
+
+		Expect(spyMetrics.GetDelta("spilled_bytes")).ToNot(BeZero())
+		Expect(spyMetrics.GetDelta("replayed_bytes")).To(Equal(spyMetrics.GetDelta("spilled_bytes")))
+	})
+
+	It("keeps batches that fail to replay", func() {
+		wal, err := spill.New(dir, 0, 0, spyMetrics)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(wal.Spill(buildBatch("first"))).To(Succeed())
+		Expect(wal.Spill(buildBatch("second"))).To(Succeed())
+
+		writeErr = errors.New("some-error")
+		Expect(wal.Replay(write)).ToNot(Succeed())
+		Expect(wal.Empty()).To(BeFalse())
+
+		writeErr = nil
+		Expect(wal.Replay(write)).To(Succeed())
+		Expect(written).To(HaveLen(2))
+		Expect(written[0][0].SourceId).To(Equal("first"))
+	})
+
+	It("replays a bounded number of batches per call", func() {
+		wal, err := spill.New(dir, 0, 0, spyMetrics, spill.WithReplayBatches(2))
+		Expect(err).ToNot(HaveOccurred())
+
+		for _, id := range []string{"first", "second", "third"} {
+			Expect(wal.Spill(buildBatch(id))).To(Succeed())
+		}
+
+		Expect(wal.Replay(write)).To(Succeed())
+		Expect(written).To(HaveLen(2))
+		Expect(wal.Empty()).To(BeFalse())
+
+		Expect(wal.Replay(write)).To(Succeed())
+		Expect(written).To(HaveLen(3))
+		Expect(written[2][0].SourceId).To(Equal("third"))
+		Expect(wal.Empty()).To(BeTrue())
+	})
+
+	It("does not count replayed batches against the size limit", func() {
+		wal, err := spill.New(dir, 1024, 0, spyMetrics, spill.WithReplayBatches(5))
+		Expect(err).ToNot(HaveOccurred())
+
+		for i := 0; i < 24; i++ {
+			Expect(wal.Spill(buildBatch("some-id"))).To(Succeed())
+		}
+		Expect(wal.Replay(write)).To(Succeed())
+
+		for i := 0; i < 5; i++ {
+			Expect(wal.Spill(buildBatch("some-id"))).To(Succeed())
+		}
+		for !wal.Empty() {
+			Expect(wal.Replay(write)).To(Succeed())
+		}
+
+		Expect(written).To(HaveLen(29))
+		Expect(spyMetrics.GetDelta("expired_bytes")).To(BeZero())
+	})
+
+	It("picks up segments from a previous run", func() {
+		wal, err := spill.New(dir, 0, 0, spyMetrics)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(wal.Spill(buildBatch("first"))).To(Succeed())
+
+		wal, err = spill.New(dir, 0, 0, spyMetrics)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(wal.Spill(buildBatch("second"))).To(Succeed())
+
+		Expect(wal.Replay(write)).To(Succeed())
+		Expect(written).To(HaveLen(2))
+		Expect(written[0][0].SourceId).To(Equal("first"))
+		Expect(written[1][0].SourceId).To(Equal("second"))
+	})
+
+	It("discards the oldest segments when over the size limit", func() {
+		wal, err := spill.New(dir, 1024, 0, spyMetrics, spill.WithSegmentSize(1))
+		Expect(err).ToNot(HaveOccurred())
+
+		for i := 0; i < 100; i++ {
+			Expect(wal.Spill(buildBatch("some-id"))).To(Succeed())
+		}
+
+		Expect(wal.Replay(write)).To(Succeed())
+		Expect(len(written)).To(BeNumerically("<", 100))
+		Expect(spyMetrics.GetDelta("expired_bytes")).ToNot(BeZero())
+	})
+
+	It("expires batches older than the max age", func() {
+		wal, err := spill.New(dir, 0, time.Nanosecond, spyMetrics)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(wal.Spill(buildBatch("first"))).To(Succeed())
+		time.Sleep(time.Millisecond)
+
+		Expect(wal.Replay(write)).To(Succeed())
+		Expect(written).To(BeEmpty())
+		Expect(spyMetrics.GetDelta("expired_bytes")).To(Equal(spyMetrics.GetDelta("spilled_bytes")))
+	})
+})
+
+func buildBatch(sourceID string) []*plumbing.Envelope {
+	return []*plumbing.Envelope{{
+		SourceId: sourceID,
+		Message: &plumbing.Envelope_Log{
+			Log: &plumbing.Log{
+				Payload: []byte("some-message"),
+			},
+		},
+	}}
+}
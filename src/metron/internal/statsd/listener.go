@@ -0,0 +1,311 @@
+package statsd
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"metricemitter"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	v2 "plumbing/v2"
+)
+
+// maxTrackedMetrics bounds the number of gauges and sets whose state is
+// kept between packets.
+const maxTrackedMetrics = 10000
+
+// DataSetter is the destination of the envelopes read by a Listener.
+type DataSetter interface {
+	Set(e *v2.Envelope)
+}
+
+// Listener reads StatsD metrics from a UDP socket and converts them to v2
+// envelopes with the listener's source_id. Counters become counter deltas
+// scaled by their sample rate, gauges become gauges, timers and histograms
+// become timers ending at the time they were received, and sets are
+// reported as a gauge of the number of unique values seen each set
+// interval. DogStatsD tags become envelope tags.
+type Listener struct {
+	conn        net.PacketConn
+	sourceID    string
+	setter      DataSetter
+	setInterval time.Duration
+	done        chan struct{}
+
+	receivedMetric *metricemitter.CounterMetric
+	invalidMetric  *metricemitter.CounterMetric
+
+	mu     sync.Mutex
+	gauges map[string]float64
+	sets   map[string]*set
+}
+
+type set struct {
+	name   string
+	tags   map[string]string
+	values map[string]struct{}
+}
+
+// ListenerOption is a type that will manipulate a Listener
+type ListenerOption func(*Listener)
+
+// WithSetInterval sets the interval at which the number of unique values
+// of each set is reported. It defaults to 10 seconds.
+func WithSetInterval(d time.Duration) ListenerOption {
+	return func(l *Listener) {
+		l.setInterval = d
+	}
+}
+
+// NewListener listens for StatsD packets on the given UDP address.
+func NewListener(
+	addr string,
+	sourceID string,
+	setter DataSetter,
+	metricClient metricemitter.MetricClient,
+	opts ...ListenerOption,
+) (*Listener, error) {
+	conn, err := net.ListenPacket("udp4", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	tags := metricemitter.WithTags(map[string]string{"source_id": sourceID})
+	l := &Listener{
+		conn:        conn,
+		sourceID:    sourceID,
+		setter:      setter,
+		setInterval: 10 * time.Second,
+		done:        make(chan struct{}),
+		gauges:      make(map[string]float64),
+		sets:        make(map[string]*set),
+		receivedMetric: metricClient.NewCounterMetric("statsd_received",
+			metricemitter.WithVersion(2, 0),
+			tags,
+		),
+		invalidMetric: metricClient.NewCounterMetric("statsd_invalid",
+			metricemitter.WithVersion(2, 0),
+			tags,
+		),
+	}
+
+	for _, o := range opts {
+		o(l)
+	}
+
+	return l, nil
+}
+
+// Addr returns the address the listener is reading from.
+func (l *Listener) Addr() net.Addr {
+	return l.conn.LocalAddr()
+}
+
+// Start reads packets until the listener is stopped.
+func (l *Listener) Start() {
+	go l.flushSets()
+
+	buf := make([]byte, 65535)
+	for {
+		n, _, err := l.conn.ReadFrom(buf)
+		if err != nil {
+			log.Printf("stopped reading statsd packets: %s", err)
+			return
+		}
+
+		l.handle(string(buf[:n]))
+	}
+}
+
+// Stop closes the listener's socket.
+func (l *Listener) Stop() {
+	l.conn.Close()
+	close(l.done)
+}
+
+func (l *Listener) handle(packet string) {
+	now := time.Now()
+	for _, line := range strings.Split(packet, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		e, err := l.convert(line, now)
+		if err != nil {
+			// metric-documentation-v2: (loggregator.metron.statsd_invalid)
+			// Number of StatsD lines that could not be parsed
+			l.invalidMetric.Increment(1)
+			continue
+		}
+
+		// metric-documentation-v2: (loggregator.metron.statsd_received)
+		// Number of StatsD metrics received
+		l.receivedMetric.Increment(1)
+
+		if e != nil {
+			l.setter.Set(e)
+		}
+	}
+}
+
+// convert returns the envelope for a StatsD line. Set members are recorded
+// and reported on the next flush, so no envelope is returned for them.
+func (l *Listener) convert(line string, now time.Time) (*v2.Envelope, error) {
+	m, err := parseLine(line)
+	if err != nil {
+		return nil, err
+	}
+
+	if m.metricType == "s" {
+		l.addToSet(m)
+		return nil, nil
+	}
+
+	value, err := strconv.ParseFloat(m.value, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &v2.Envelope{
+		SourceId:  l.sourceID,
+		Timestamp: now.UnixNano(),
+		Tags:      textTags(m.tags),
+	}
+
+	switch m.metricType {
+	case "c":
+		if value < 0 {
+			return nil, fmt.Errorf("negative counter %s", m.value)
+		}
+		e.Message = &v2.Envelope_Counter{
+			Counter: &v2.Counter{
+				Name: m.name,
+				Value: &v2.Counter_Delta{
+					Delta: uint64(math.Floor(value/m.sampleRate + 0.5)),
+				},
+			},
+		}
+	case "g":
+		e.Message = &v2.Envelope_Gauge{
+			Gauge: &v2.Gauge{
+				Metrics: map[string]*v2.GaugeValue{
+					m.name: {Value: l.updateGauge(m, value)},
+				},
+			},
+		}
+	case "ms", "h":
+		stop := now.UnixNano()
+		e.Message = &v2.Envelope_Timer{
+			Timer: &v2.Timer{
+				Name:  m.name,
+				Start: stop - int64(value*float64(time.Millisecond)),
+				Stop:  stop,
+			},
+		}
+	}
+
+	return e, nil
+}
+
+// updateGauge applies relative gauge updates, signalled by a leading + or
+// -, to the last value of the gauge.
+func (l *Listener) updateGauge(m metric, value float64) float64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	key := metricKey(m.name, m.tags)
+	if strings.HasPrefix(m.value, "+") || strings.HasPrefix(m.value, "-") {
+		value += l.gauges[key]
+	}
+
+	if _, ok := l.gauges[key]; !ok && len(l.gauges) >= maxTrackedMetrics {
+		l.gauges = make(map[string]float64)
+	}
+	l.gauges[key] = value
+
+	return value
+}
+
+func (l *Listener) addToSet(m metric) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	key := metricKey(m.name, m.tags)
+	s, ok := l.sets[key]
+	if !ok {
+		if len(l.sets) >= maxTrackedMetrics {
+			return
+		}
+
+		s = &set{
+			name:   m.name,
+			tags:   m.tags,
+			values: make(map[string]struct{}),
+		}
+		l.sets[key] = s
+	}
+	s.values[m.value] = struct{}{}
+}
+
+func (l *Listener) flushSets() {
+	ticker := time.NewTicker(l.setInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.done:
+			return
+		case <-ticker.C:
+		}
+
+		l.mu.Lock()
+		sets := l.sets
+		l.sets = make(map[string]*set)
+		l.mu.Unlock()
+
+		now := time.Now().UnixNano()
+		for _, s := range sets {
+			l.setter.Set(&v2.Envelope{
+				SourceId:  l.sourceID,
+				Timestamp: now,
+				Tags:      textTags(s.tags),
+				Message: &v2.Envelope_Gauge{
+					Gauge: &v2.Gauge{
+						Metrics: map[string]*v2.GaugeValue{
+							s.name: {Unit: "count", Value: float64(len(s.values))},
+						},
+					},
+				},
+			})
+		}
+	}
+}
+
+func metricKey(name string, tags map[string]string) string {
+	pairs := make([]string, 0, len(tags))
+	for k, v := range tags {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+
+	return name + "\x00" + strings.Join(pairs, ",")
+}
+
+func textTags(tags map[string]string) map[string]*v2.Value {
+	if len(tags) == 0 {
+		return nil
+	}
+
+	result := make(map[string]*v2.Value, len(tags))
+	for k, v := range tags {
+		result[k] = &v2.Value{Data: &v2.Value_Text{Text: v}}
+	}
+
+	return result
+}
@@ -0,0 +1,123 @@
+package statsd_test
+
+import (
+	"metricemitter/testhelper"
+	"net"
+	"time"
+
+	"metron/internal/statsd"
+	v2 "plumbing/v2"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Listener", func() {
+	var (
+		setter   *spySetter
+		spy      *testhelper.SpyMetricClient
+		listener *statsd.Listener
+		conn     net.Conn
+	)
+
+	BeforeEach(func() {
+		setter = newSpySetter()
+		spy = testhelper.NewMetricClient()
+
+		var err error
+		listener, err = statsd.NewListener(
+			"127.0.0.1:0",
+			"some-id",
+			setter,
+			spy,
+			statsd.WithSetInterval(50*time.Millisecond),
+		)
+		Expect(err).ToNot(HaveOccurred())
+		go listener.Start()
+
+		conn, err = net.Dial("udp4", listener.Addr().String())
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		conn.Close()
+		listener.Stop()
+	})
+
+	send := func(packet string) {
+		_, err := conn.Write([]byte(packet))
+		Expect(err).ToNot(HaveOccurred())
+	}
+
+	It("converts counters to deltas scaled by the sample rate", func() {
+		send("requests:3|c|@0.1")
+
+		var e *v2.Envelope
+		Eventually(setter.envelopes).Should(Receive(&e))
+		Expect(e.GetSourceId()).To(Equal("some-id"))
+		Expect(e.GetCounter().GetName()).To(Equal("requests"))
+		Expect(e.GetCounter().GetDelta()).To(Equal(uint64(30)))
+	})
+
+	It("converts gauges and applies relative updates", func() {
+		send("queue:10|g\nqueue:-4|g\nqueue:+1|g")
+
+		var values []float64
+		for i := 0; i < 3; i++ {
+			var e *v2.Envelope
+			Eventually(setter.envelopes).Should(Receive(&e))
+			values = append(values, e.GetGauge().GetMetrics()["queue"].GetValue())
+		}
+		Expect(values).To(Equal([]float64{10, 6, 7}))
+	})
+
+	It("converts timers and histograms to timers", func() {
+		send("latency:250|ms\nsize:2|h")
+
+		var e *v2.Envelope
+		Eventually(setter.envelopes).Should(Receive(&e))
+		Expect(e.GetTimer().GetName()).To(Equal("latency"))
+		Expect(e.GetTimer().GetStop() - e.GetTimer().GetStart()).To(Equal(int64(250 * time.Millisecond)))
+
+		Eventually(setter.envelopes).Should(Receive(&e))
+		Expect(e.GetTimer().GetName()).To(Equal("size"))
+	})
+
+	It("reports the number of unique set members", func() {
+		send("users:alice|s\nusers:bob|s\nusers:alice|s")
+
+		var e *v2.Envelope
+		Eventually(setter.envelopes).Should(Receive(&e))
+		Expect(e.GetGauge().GetMetrics()["users"].GetValue()).To(Equal(2.0))
+	})
+
+	It("converts DogStatsD tags", func() {
+		send("requests:1|c|#route:/v2,canary")
+
+		var e *v2.Envelope
+		Eventually(setter.envelopes).Should(Receive(&e))
+		Expect(e.GetTags()).To(HaveLen(2))
+		Expect(e.GetTags()["route"].GetText()).To(Equal("/v2"))
+		Expect(e.GetTags()).To(HaveKey("canary"))
+	})
+
+	It("counts invalid lines", func() {
+		send("no-value|c\nrequests:1|x\nrequests:1|c")
+
+		Eventually(setter.envelopes).Should(Receive())
+		Expect(spy.GetDelta("statsd_invalid")).To(Equal(uint64(2)))
+		Expect(spy.GetDelta("statsd_received")).To(Equal(uint64(1)))
+	})
+})
+
+type spySetter struct {
+	envelopes chan *v2.Envelope
+}
+
+func newSpySetter() *spySetter {
+	return &spySetter{envelopes: make(chan *v2.Envelope, 100)}
+}
+
+func (s *spySetter) Set(e *v2.Envelope) {
+	s.envelopes <- e
+}
@@ -0,0 +1,79 @@
+package statsd
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// metric is a single parsed StatsD line of the form
+//
+//	name:value|type[|@sample_rate][|#tag,tag:value]
+type metric struct {
+	name       string
+	value      string
+	metricType string
+	sampleRate float64
+	tags       map[string]string
+}
+
+func parseLine(line string) (metric, error) {
+	colon := strings.IndexByte(line, ':')
+	if colon <= 0 {
+		return metric{}, errors.New("missing metric name")
+	}
+
+	parts := strings.Split(line[colon+1:], "|")
+	if len(parts) < 2 || parts[0] == "" {
+		return metric{}, errors.New("missing value or type")
+	}
+
+	m := metric{
+		name:       line[:colon],
+		value:      parts[0],
+		metricType: parts[1],
+		sampleRate: 1,
+	}
+
+	switch m.metricType {
+	case "c", "g", "ms", "h", "s":
+	default:
+		return metric{}, fmt.Errorf("unknown metric type %q", m.metricType)
+	}
+
+	for _, p := range parts[2:] {
+		switch {
+		case strings.HasPrefix(p, "@"):
+			rate, err := strconv.ParseFloat(p[1:], 64)
+			if err != nil || rate <= 0 || rate > 1 {
+				return metric{}, fmt.Errorf("invalid sample rate %q", p[1:])
+			}
+			m.sampleRate = rate
+		case strings.HasPrefix(p, "#"):
+			m.tags = parseTags(p[1:])
+		}
+	}
+
+	return m, nil
+}
+
+// parseTags parses DogStatsD tags. Tags without a value are given an empty
+// value.
+func parseTags(s string) map[string]string {
+	tags := make(map[string]string)
+	for _, t := range strings.Split(s, ",") {
+		if t == "" {
+			continue
+		}
+
+		kv := strings.SplitN(t, ":", 2)
+		if len(kv) == 1 {
+			tags[kv[0]] = ""
+			continue
+		}
+		tags[kv[0]] = kv[1]
+	}
+
+	return tags
+}
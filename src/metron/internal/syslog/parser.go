@@ -0,0 +1,244 @@
+package syslog
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	v2 "plumbing/v2"
+)
+
+const rfc3164TimeLayout = time.Stamp
+
+var facilities = []string{
+	"kern", "user", "mail", "daemon", "auth", "syslog", "lpr", "news",
+	"uucp", "cron", "authpriv", "ftp", "ntp", "security", "console", "solaris-cron",
+	"local0", "local1", "local2", "local3", "local4", "local5", "local6", "local7",
+}
+
+var severities = []string{
+	"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug",
+}
+
+// message is a parsed RFC5424 or RFC3164 syslog message. Fields that are
+// absent are empty.
+type message struct {
+	facility  int
+	severity  int
+	timestamp time.Time
+	hostname  string
+	appName   string
+	procID    string
+	msgID     string
+	sd        map[string]string
+	msg       []byte
+}
+
+// parse parses an RFC5424 message or, when the version is missing, an
+// RFC3164 message. Timestamps that are missing or can not be parsed are
+// replaced by now.
+func parse(b []byte, now time.Time) (message, error) {
+	b = bytes.TrimRight(b, "\r\n\x00")
+	if len(b) < 3 || b[0] != '<' {
+		return message{}, errors.New("missing priority")
+	}
+
+	end := bytes.IndexByte(b, '>')
+	if end < 2 || end > 4 {
+		return message{}, errors.New("invalid priority")
+	}
+
+	pri, err := strconv.Atoi(string(b[1:end]))
+	if err != nil || pri > 191 {
+		return message{}, errors.New("invalid priority")
+	}
+
+	m := message{
+		facility:  pri / 8,
+		severity:  pri % 8,
+		timestamp: now,
+	}
+
+	rest := b[end+1:]
+	if bytes.HasPrefix(rest, []byte("1 ")) {
+		return parseRFC5424(m, rest[2:])
+	}
+
+	return parseRFC3164(m, rest, now), nil
+}
+
+func parseRFC5424(m message, b []byte) (message, error) {
+	var fields [5]string
+	for i := range fields {
+		sp := bytes.IndexByte(b, ' ')
+		if sp < 0 {
+			return message{}, errors.New("truncated header")
+		}
+		fields[i] = nilValue(string(b[:sp]))
+		b = b[sp+1:]
+	}
+
+	if fields[0] != "" {
+		t, err := time.Parse(time.RFC3339Nano, fields[0])
+		if err == nil {
+			m.timestamp = t
+		}
+	}
+	m.hostname = fields[1]
+	m.appName = fields[2]
+	m.procID = fields[3]
+	m.msgID = fields[4]
+
+	sd, rest, err := parseStructuredData(b)
+	if err != nil {
+		return message{}, err
+	}
+	m.sd = sd
+
+	if len(rest) > 0 && rest[0] == ' ' {
+		rest = rest[1:]
+	}
+	m.msg = bytes.TrimPrefix(rest, []byte("\xef\xbb\xbf"))
+
+	return m, nil
+}
+
+// parseStructuredData parses the STRUCTURED-DATA of an RFC5424 message into
+// a map of parameter names to values and returns the remainder of the
+// message.
+func parseStructuredData(b []byte) (map[string]string, []byte, error) {
+	if len(b) > 0 && b[0] == '-' {
+		return nil, b[1:], nil
+	}
+
+	sd := make(map[string]string)
+	for len(b) > 0 && b[0] == '[' {
+		end := bytes.IndexAny(b, " ]")
+		if end < 0 {
+			return nil, nil, errors.New("unterminated structured data")
+		}
+		b = b[end:]
+
+		for len(b) > 0 && b[0] == ' ' {
+			b = b[1:]
+
+			eq := bytes.Index(b, []byte(`="`))
+			if eq < 0 {
+				return nil, nil, errors.New("invalid structured data parameter")
+			}
+			name := string(b[:eq])
+			b = b[eq+2:]
+
+			var value []byte
+			for {
+				if len(b) == 0 {
+					return nil, nil, errors.New("unterminated structured data parameter")
+				}
+				if b[0] == '\\' && len(b) > 1 && (b[1] == '"' || b[1] == '\\' || b[1] == ']') {
+					value = append(value, b[1])
+					b = b[2:]
+					continue
+				}
+				if b[0] == '"' {
+					b = b[1:]
+					break
+				}
+				value = append(value, b[0])
+				b = b[1:]
+			}
+			sd[name] = string(value)
+		}
+
+		if len(b) == 0 || b[0] != ']' {
+			return nil, nil, errors.New("unterminated structured data")
+		}
+		b = b[1:]
+	}
+
+	return sd, b, nil
+}
+
+// parseRFC3164 parses the BSD syslog format. As the format is loosely
+// specified, anything that does not match it is kept as the message.
+func parseRFC3164(m message, b []byte, now time.Time) message {
+	if len(b) > len(rfc3164TimeLayout) && b[len(rfc3164TimeLayout)] == ' ' {
+		t, err := time.ParseInLocation(rfc3164TimeLayout, string(b[:len(rfc3164TimeLayout)]), now.Location())
+		if err == nil {
+			m.timestamp = t.AddDate(now.Year(), 0, 0)
+			b = b[len(rfc3164TimeLayout)+1:]
+
+			if sp := bytes.IndexByte(b, ' '); sp > 0 {
+				m.hostname = string(b[:sp])
+				b = b[sp+1:]
+			}
+		}
+	}
+
+	colon := bytes.Index(b, []byte(": "))
+	if colon > 0 && colon <= 48 && bytes.IndexByte(b[:colon], ' ') < 0 {
+		tag := string(b[:colon])
+		if open := strings.IndexByte(tag, '['); open > 0 && strings.HasSuffix(tag, "]") {
+			m.procID = tag[open+1 : len(tag)-1]
+			tag = tag[:open]
+		}
+		m.appName = tag
+		b = b[colon+2:]
+	}
+	m.msg = b
+
+	return m
+}
+
+// envelope returns a v2 log envelope for the message. The source_id is the
+// APP-NAME, or defaultSourceID when it is missing.
+func (m message) envelope(defaultSourceID string) *v2.Envelope {
+	sourceID := m.appName
+	if sourceID == "" {
+		sourceID = defaultSourceID
+	}
+
+	tags := map[string]*v2.Value{
+		"facility": textValue(facilities[m.facility]),
+		"severity": textValue(severities[m.severity]),
+	}
+	for k, v := range m.sd {
+		tags[k] = textValue(v)
+	}
+	if m.hostname != "" {
+		tags["hostname"] = textValue(m.hostname)
+	}
+	if m.msgID != "" {
+		tags["msg_id"] = textValue(m.msgID)
+	}
+
+	logType := v2.Log_OUT
+	if m.severity <= 3 {
+		logType = v2.Log_ERR
+	}
+
+	return &v2.Envelope{
+		SourceId:   sourceID,
+		InstanceId: m.procID,
+		Timestamp:  m.timestamp.UnixNano(),
+		Tags:       tags,
+		Message: &v2.Envelope_Log{
+			Log: &v2.Log{
+				Payload: m.msg,
+				Type:    logType,
+			},
+		},
+	}
+}
+
+func nilValue(s string) string {
+	if s == "-" {
+		return ""
+	}
+	return s
+}
+
+func textValue(s string) *v2.Value {
+	return &v2.Value{Data: &v2.Value_Text{Text: s}}
+}
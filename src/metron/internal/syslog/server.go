@@ -0,0 +1,276 @@
+package syslog
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"metricemitter"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	v2 "plumbing/v2"
+)
+
+// maxMessageSize bounds the length of a message received over TCP.
+// Connections sending a longer message are closed.
+const maxMessageSize = 64 * 1024
+
+var errMessageTooLong = fmt.Errorf("message longer than %d bytes", maxMessageSize)
+
+// DataSetter is the destination of the envelopes received by a Server.
+type DataSetter interface {
+	Set(e *v2.Envelope)
+}
+
+// Server receives RFC5424 and RFC3164 syslog messages over TCP and UDP and
+// converts them to v2 log envelopes. TCP connections may use octet-counting
+// or newline framing. UDP datagrams hold a single message.
+type Server struct {
+	setter          DataSetter
+	defaultSourceID string
+
+	tcp net.Listener
+	udp net.PacketConn
+
+	receivedMetric *metricemitter.CounterMetric
+	invalidMetric  *metricemitter.CounterMetric
+
+	mu    sync.Mutex
+	conns map[net.Conn]struct{}
+}
+
+// ServerOption is a type that will manipulate a Server
+type ServerOption func(*Server)
+
+// WithDefaultSourceID sets the source_id of messages without an APP-NAME.
+// It defaults to "syslog".
+func WithDefaultSourceID(id string) ServerOption {
+	return func(s *Server) {
+		s.defaultSourceID = id
+	}
+}
+
+// NewServer listens for syslog messages on the given TCP and UDP addresses.
+// An empty address disables the transport.
+func NewServer(
+	tcpAddr string,
+	udpAddr string,
+	setter DataSetter,
+	metricClient metricemitter.MetricClient,
+	opts ...ServerOption,
+) (*Server, error) {
+	s := &Server{
+		setter:          setter,
+		defaultSourceID: "syslog",
+		conns:           make(map[net.Conn]struct{}),
+		receivedMetric: metricClient.NewCounterMetric("syslog_received",
+			metricemitter.WithVersion(2, 0),
+		),
+		invalidMetric: metricClient.NewCounterMetric("syslog_invalid",
+			metricemitter.WithVersion(2, 0),
+		),
+	}
+
+	for _, o := range opts {
+		o(s)
+	}
+
+	var err error
+	if tcpAddr != "" {
+		s.tcp, err = net.Listen("tcp", tcpAddr)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if udpAddr != "" {
+		s.udp, err = net.ListenPacket("udp", udpAddr)
+		if err != nil {
+			if s.tcp != nil {
+				s.tcp.Close()
+			}
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+// TCPAddr returns the address of the TCP listener or nil when it is
+// disabled.
+func (s *Server) TCPAddr() net.Addr {
+	if s.tcp == nil {
+		return nil
+	}
+	return s.tcp.Addr()
+}
+
+// UDPAddr returns the address of the UDP listener or nil when it is
+// disabled.
+func (s *Server) UDPAddr() net.Addr {
+	if s.udp == nil {
+		return nil
+	}
+	return s.udp.LocalAddr()
+}
+
+// Start receives messages until the server is stopped.
+func (s *Server) Start() {
+	var wg sync.WaitGroup
+	if s.tcp != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.acceptTCP()
+		}()
+	}
+
+	if s.udp != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.readUDP()
+		}()
+	}
+
+	wg.Wait()
+}
+
+// Stop closes the listeners and any open TCP connections.
+func (s *Server) Stop() {
+	if s.tcp != nil {
+		s.tcp.Close()
+	}
+	if s.udp != nil {
+		s.udp.Close()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for c := range s.conns {
+		c.Close()
+	}
+}
+
+func (s *Server) acceptTCP() {
+	for {
+		conn, err := s.tcp.Accept()
+		if err != nil {
+			log.Printf("stopped accepting syslog connections: %s", err)
+			return
+		}
+
+		s.mu.Lock()
+		s.conns[conn] = struct{}{}
+		s.mu.Unlock()
+
+		go s.handleConn(conn)
+	}
+}
+
+func (s *Server) handleConn(conn net.Conn) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+
+	// The buffer holds a whole message along with its trailing newline.
+	r := bufio.NewReaderSize(conn, maxMessageSize+1)
+	for {
+		msg, err := readFrame(r)
+		if err != nil {
+			if err != io.EOF {
+				log.Printf("closing syslog connection from %s: %s", conn.RemoteAddr(), err)
+			}
+			return
+		}
+
+		if len(bytes.TrimSpace(msg)) > 0 {
+			s.handle(msg)
+		}
+	}
+}
+
+// readFrame reads a single message using octet-counting framing when the
+// frame starts with a digit and newline framing otherwise. It returns
+// errMessageTooLong rather than reading a message longer than
+// maxMessageSize.
+func readFrame(r *bufio.Reader) ([]byte, error) {
+	first, err := r.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+
+	if first[0] < '0' || first[0] > '9' {
+		line, err := readSlice(r, '\n')
+		if err == io.EOF && len(line) > 0 {
+			return line, nil
+		}
+		return line, err
+	}
+
+	length, err := readSlice(r, ' ')
+	if err != nil {
+		return nil, err
+	}
+
+	n, err := strconv.Atoi(string(length[:len(length)-1]))
+	if err != nil || n <= 0 || n > maxMessageSize {
+		return nil, fmt.Errorf("invalid message length %q", length)
+	}
+
+	msg := make([]byte, n)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, err
+	}
+
+	return msg, nil
+}
+
+// readSlice reads up to and including delim, failing if the buffer of r
+// fills first. The returned bytes are a copy.
+func readSlice(r *bufio.Reader, delim byte) ([]byte, error) {
+	b, err := r.ReadSlice(delim)
+	if err == bufio.ErrBufferFull {
+		return nil, errMessageTooLong
+	}
+
+	return append([]byte(nil), b...), err
+}
+
+func (s *Server) readUDP() {
+	buf := make([]byte, 65535)
+	for {
+		n, _, err := s.udp.ReadFrom(buf)
+		if err != nil {
+			log.Printf("stopped reading syslog datagrams: %s", err)
+			return
+		}
+
+		msg := make([]byte, n)
+		copy(msg, buf[:n])
+		s.handle(msg)
+	}
+}
+
+func (s *Server) handle(b []byte) {
+	m, err := parse(b, time.Now())
+	if err != nil {
+		// metric-documentation-v2: (loggregator.metron.syslog_invalid)
+		// Number of syslog messages that could not be parsed
+		s.invalidMetric.Increment(1)
+		return
+	}
+
+	// metric-documentation-v2: (loggregator.metron.syslog_received)
+	// Number of syslog messages received
+	s.receivedMetric.Increment(1)
+
+	s.setter.Set(m.envelope(s.defaultSourceID))
+}
@@ -0,0 +1,141 @@
+package syslog_test
+
+import (
+	"fmt"
+	"metricemitter/testhelper"
+	"net"
+	"strings"
+	"time"
+
+	"metron/internal/syslog"
+	v2 "plumbing/v2"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Server", func() {
+	var (
+		setter *spySetter
+		spy    *testhelper.SpyMetricClient
+		server *syslog.Server
+	)
+
+	BeforeEach(func() {
+		setter = newSpySetter()
+		spy = testhelper.NewMetricClient()
+
+		var err error
+		server, err = syslog.NewServer(
+			"127.0.0.1:0",
+			"127.0.0.1:0",
+			setter,
+			spy,
+			syslog.WithDefaultSourceID("some-host"),
+		)
+		Expect(err).ToNot(HaveOccurred())
+		go server.Start()
+	})
+
+	AfterEach(func() {
+		server.Stop()
+	})
+
+	sendTCP := func(data string) {
+		conn, err := net.Dial("tcp", server.TCPAddr().String())
+		Expect(err).ToNot(HaveOccurred())
+		defer conn.Close()
+
+		_, err = conn.Write([]byte(data))
+		Expect(err).ToNot(HaveOccurred())
+	}
+
+	It("converts RFC5424 messages to logs", func() {
+		msg := `<165>1 2017-06-01T12:00:00.5Z web-1 gorouter 1234 ID47 [exampleSDID@32473 iut="3" eventSource="App\"lication"] an application event`
+		sendTCP(fmt.Sprintf("%d %s", len(msg), msg))
+
+		var e *v2.Envelope
+		Eventually(setter.envelopes).Should(Receive(&e))
+		Expect(e.GetSourceId()).To(Equal("gorouter"))
+		Expect(e.GetInstanceId()).To(Equal("1234"))
+		Expect(e.GetTimestamp()).To(Equal(time.Date(2017, 6, 1, 12, 0, 0, 5e8, time.UTC).UnixNano()))
+		Expect(e.GetLog().GetPayload()).To(Equal([]byte("an application event")))
+		Expect(e.GetLog().GetType()).To(Equal(v2.Log_OUT))
+
+		tags := e.GetTags()
+		Expect(tags["facility"].GetText()).To(Equal("local4"))
+		Expect(tags["severity"].GetText()).To(Equal("notice"))
+		Expect(tags["hostname"].GetText()).To(Equal("web-1"))
+		Expect(tags["msg_id"].GetText()).To(Equal("ID47"))
+		Expect(tags["iut"].GetText()).To(Equal("3"))
+		Expect(tags["eventSource"].GetText()).To(Equal(`App"lication`))
+		Expect(spy.GetDelta("syslog_received")).To(Equal(uint64(1)))
+	})
+
+	It("converts RFC3164 messages to logs", func() {
+		sendTCP("<3>Oct 11 22:14:15 web-1 kernel[0]: oom-killer invoked\n<14>Oct 11 22:14:16 web-1 cron: job done\n")
+
+		var e *v2.Envelope
+		Eventually(setter.envelopes).Should(Receive(&e))
+		Expect(e.GetSourceId()).To(Equal("kernel"))
+		Expect(e.GetInstanceId()).To(Equal("0"))
+		Expect(e.GetLog().GetPayload()).To(Equal([]byte("oom-killer invoked")))
+		Expect(e.GetLog().GetType()).To(Equal(v2.Log_ERR))
+		Expect(e.GetTags()["facility"].GetText()).To(Equal("kern"))
+		Expect(e.GetTags()["severity"].GetText()).To(Equal("err"))
+		Expect(time.Unix(0, e.GetTimestamp()).Month()).To(Equal(time.October))
+
+		Eventually(setter.envelopes).Should(Receive(&e))
+		Expect(e.GetSourceId()).To(Equal("cron"))
+		Expect(e.GetLog().GetPayload()).To(Equal([]byte("job done")))
+	})
+
+	It("receives messages over UDP", func() {
+		conn, err := net.Dial("udp", server.UDPAddr().String())
+		Expect(err).ToNot(HaveOccurred())
+		defer conn.Close()
+
+		_, err = conn.Write([]byte("<13>1 - - - - - - no header fields"))
+		Expect(err).ToNot(HaveOccurred())
+
+		var e *v2.Envelope
+		Eventually(setter.envelopes).Should(Receive(&e))
+		Expect(e.GetSourceId()).To(Equal("some-host"))
+		Expect(e.GetLog().GetPayload()).To(Equal([]byte("no header fields")))
+	})
+
+	It("closes connections that send a message that is too long", func() {
+		conn, err := net.Dial("tcp", server.TCPAddr().String())
+		Expect(err).ToNot(HaveOccurred())
+		defer conn.Close()
+
+		_, err = conn.Write([]byte("<13>1 - - app - - - " + strings.Repeat("a", 65*1024)))
+		Expect(err).ToNot(HaveOccurred())
+
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		_, err = conn.Read(make([]byte, 1))
+		Expect(err).To(HaveOccurred())
+		netErr, ok := err.(net.Error)
+		Expect(ok && netErr.Timeout()).To(BeFalse())
+		Expect(setter.envelopes).ToNot(Receive())
+	})
+
+	It("counts invalid messages", func() {
+		sendTCP("not syslog\n<13>1 - - app - - - valid\n")
+
+		Eventually(setter.envelopes).Should(Receive())
+		Expect(spy.GetDelta("syslog_invalid")).To(Equal(uint64(1)))
+	})
+})
+
+type spySetter struct {
+	envelopes chan *v2.Envelope
+}
+
+func newSpySetter() *spySetter {
+	return &spySetter{envelopes: make(chan *v2.Envelope, 100)}
+}
+
+func (s *spySetter) Set(e *v2.Envelope) {
+	s.envelopes <- e
+}
@@ -0,0 +1,209 @@
+package tagfile
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"metricemitter"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/howeyc/fsnotify"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// maxCachedSourceIDs bounds the number of source_ids whose tags are cached
+// between reloads.
+const maxCachedSourceIDs = 10000
+
+// File is the format of a tag file. Global tags apply to every envelope.
+// SourceIDs are applied in order on top of them to envelopes whose
+// source_id matches Pattern, a shell pattern as used by filepath.Match.
+//
+// Example:
+//
+//	global:
+//	  region: us-east
+//	source_ids:
+//	- pattern: "payments-*"
+//	  tags:
+//	    team: payments
+//	    cost_center: "123"
+type File struct {
+	Global    map[string]string `json:"global" yaml:"global"`
+	SourceIDs []SourceIDTags    `json:"source_ids" yaml:"source_ids"`
+}
+
+// SourceIDTags are tags for the source_ids matching Pattern.
+type SourceIDTags struct {
+	Pattern string            `json:"pattern" yaml:"pattern"`
+	Tags    map[string]string `json:"tags" yaml:"tags"`
+}
+
+// Watcher provides the tags of a JSON or YAML tag file and reloads them
+// whenever the file changes. A reload replaces the tags atomically; if the
+// new file is invalid the previous tags are kept.
+type Watcher struct {
+	path    string
+	watcher *fsnotify.Watcher
+	current atomic.Value // *tagSet
+
+	reloadMetric *metricemitter.CounterMetric
+	failedMetric *metricemitter.CounterMetric
+}
+
+// New loads the tag file at path and watches it for changes. Files with a
+// .json extension are parsed as JSON and all others as YAML.
+func New(path string, metricClient metricemitter.MetricClient) (*Watcher, error) {
+	w := &Watcher{
+		path: filepath.Clean(path),
+		reloadMetric: metricClient.NewCounterMetric("tag_file_reloads",
+			metricemitter.WithVersion(2, 0),
+		),
+		failedMetric: metricClient.NewCounterMetric("tag_file_reload_failures",
+			metricemitter.WithVersion(2, 0),
+		),
+	}
+
+	set, err := load(w.path)
+	if err != nil {
+		return nil, err
+	}
+	w.current.Store(set)
+
+	w.watcher, err = fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	// The directory is watched so that files replaced by a rename, as
+	// config management tools tend to do, are picked up.
+	if err := w.watcher.Watch(filepath.Dir(w.path)); err != nil {
+		w.watcher.Close()
+		return nil, err
+	}
+
+	go w.watch()
+
+	return w, nil
+}
+
+// Tags returns the tags for envelopes with the given source_id. The
+// returned map must not be modified.
+func (w *Watcher) Tags(sourceID string) map[string]string {
+	return w.current.Load().(*tagSet).tags(sourceID)
+}
+
+// Stop stops watching the tag file.
+func (w *Watcher) Stop() {
+	w.watcher.Close()
+}
+
+func (w *Watcher) watch() {
+	for {
+		select {
+		case e, ok := <-w.watcher.Event:
+			if !ok {
+				return
+			}
+			if filepath.Clean(e.Name) != w.path || e.IsDelete() {
+				continue
+			}
+			w.reload()
+		case err, ok := <-w.watcher.Error:
+			if !ok {
+				return
+			}
+			log.Printf("error watching tag file %s: %s", w.path, err)
+		}
+	}
+}
+
+func (w *Watcher) reload() {
+	set, err := load(w.path)
+	if err != nil {
+		// metric-documentation-v2: (loggregator.metron.tag_file_reload_failures)
+		// Number of times the tag file changed but could not be loaded
+		w.failedMetric.Increment(1)
+		log.Printf("keeping previous tags, failed to reload %s: %s", w.path, err)
+		return
+	}
+
+	w.current.Store(set)
+
+	// metric-documentation-v2: (loggregator.metron.tag_file_reloads)
+	// Number of times the tag file was reloaded
+	w.reloadMetric.Increment(1)
+	log.Printf("reloaded tags from %s", w.path)
+}
+
+func load(path string) (*tagSet, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var f File
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		err = json.Unmarshal(data, &f)
+	} else {
+		err = yaml.Unmarshal(data, &f)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid tag file: %s", err)
+	}
+
+	for _, s := range f.SourceIDs {
+		if _, err := filepath.Match(s.Pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid source_id pattern %q: %s", s.Pattern, err)
+		}
+	}
+
+	return &tagSet{
+		file:  f,
+		cache: make(map[string]map[string]string),
+	}, nil
+}
+
+// tagSet is an immutable set of tags from a single load of the tag file
+// along with the merged tags of the source_ids seen since.
+type tagSet struct {
+	file File
+
+	mu    sync.RWMutex
+	cache map[string]map[string]string
+}
+
+func (s *tagSet) tags(sourceID string) map[string]string {
+	s.mu.RLock()
+	tags, ok := s.cache[sourceID]
+	s.mu.RUnlock()
+	if ok {
+		return tags
+	}
+
+	tags = make(map[string]string, len(s.file.Global))
+	for k, v := range s.file.Global {
+		tags[k] = v
+	}
+	for _, st := range s.file.SourceIDs {
+		if ok, _ := filepath.Match(st.Pattern, sourceID); !ok {
+			continue
+		}
+		for k, v := range st.Tags {
+			tags[k] = v
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.cache) >= maxCachedSourceIDs {
+		s.cache = make(map[string]map[string]string)
+	}
+	s.cache[sourceID] = tags
+
+	return tags
+}
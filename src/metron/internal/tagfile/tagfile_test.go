@@ -0,0 +1,120 @@
+package tagfile_test
+
+import (
+	"io/ioutil"
+	"metricemitter/testhelper"
+	"os"
+	"path/filepath"
+
+	"metron/internal/tagfile"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Watcher", func() {
+	var (
+		dir string
+		spy *testhelper.SpyMetricClient
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "tagfile")
+		Expect(err).ToNot(HaveOccurred())
+		spy = testhelper.NewMetricClient()
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		Expect(ioutil.WriteFile(path, []byte(content), 0644)).To(Succeed())
+		return path
+	}
+
+	It("applies global and source_id scoped tags from YAML", func() {
+		path := write("tags.yml", `
+global:
+  region: us-east
+  team: platform
+source_ids:
+- pattern: "payments-*"
+  tags:
+    team: payments
+    cost_center: "123"
+`)
+		w, err := tagfile.New(path, spy)
+		Expect(err).ToNot(HaveOccurred())
+		defer w.Stop()
+
+		Expect(w.Tags("payments-api")).To(Equal(map[string]string{
+			"region":      "us-east",
+			"team":        "payments",
+			"cost_center": "123",
+		}))
+		Expect(w.Tags("other")).To(Equal(map[string]string{
+			"region": "us-east",
+			"team":   "platform",
+		}))
+	})
+
+	It("reads JSON files", func() {
+		path := write("tags.json", `{"source_ids": [{"pattern": "gorouter", "tags": {"team": "routing"}}]}`)
+		w, err := tagfile.New(path, spy)
+		Expect(err).ToNot(HaveOccurred())
+		defer w.Stop()
+
+		Expect(w.Tags("gorouter")).To(Equal(map[string]string{"team": "routing"}))
+	})
+
+	It("returns an error for an invalid file", func() {
+		path := write("tags.yml", `source_ids: [{pattern: "[", tags: {}}]`)
+		_, err := tagfile.New(path, spy)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("reloads the tags when the file changes", func() {
+		path := write("tags.yml", "global: {team: a}")
+		w, err := tagfile.New(path, spy)
+		Expect(err).ToNot(HaveOccurred())
+		defer w.Stop()
+
+		write("tags.yml", "global: {team: b}")
+
+		Eventually(func() map[string]string {
+			return w.Tags("some-id")
+		}).Should(Equal(map[string]string{"team": "b"}))
+		Expect(spy.GetDelta("tag_file_reloads")).ToNot(BeZero())
+	})
+
+	It("reloads files replaced by a rename", func() {
+		path := write("tags.yml", "global: {team: a}")
+		w, err := tagfile.New(path, spy)
+		Expect(err).ToNot(HaveOccurred())
+		defer w.Stop()
+
+		tmp := write("tags.yml.tmp", "global: {team: b}")
+		Expect(os.Rename(tmp, path)).To(Succeed())
+
+		Eventually(func() map[string]string {
+			return w.Tags("some-id")
+		}).Should(Equal(map[string]string{"team": "b"}))
+	})
+
+	It("keeps the previous tags when the new file is invalid", func() {
+		path := write("tags.yml", "global: {team: a}")
+		w, err := tagfile.New(path, spy)
+		Expect(err).ToNot(HaveOccurred())
+		defer w.Stop()
+
+		write("tags.yml", "global: [not, a, map]")
+
+		Eventually(func() uint64 {
+			return spy.GetDelta("tag_file_reload_failures")
+		}).ShouldNot(BeZero())
+		Expect(w.Tags("some-id")).To(Equal(map[string]string{"team": "a"}))
+	})
+})
@@ -1,11 +1,16 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
 	"math/rand"
 	"metricemitter"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 	"time"
 
 	"google.golang.org/grpc"
@@ -14,6 +19,7 @@ import (
 	"metron/internal/health"
 	"plumbing"
 	"profiler"
+	"reload"
 )
 
 func main() {
@@ -77,13 +83,123 @@ func main() {
 	server, registry := health.New(config.HealthEndpointPort)
 	go server.Run()
 
-	appV1 := app.NewV1App(config, registry, clientCreds)
-	go appV1.Start()
-
 	appV2 := app.NewV2App(config, registry, clientCreds, serverCreds, metricClient)
+
+	var v1Opts []app.AppV1Option
+	if config.RouteUDPThroughV2 {
+		v1Opts = append(v1Opts, app.WithV2Setter(appV2.Setter()))
+	}
+	appV1 := app.NewV1App(config, registry, clientCreds, v1Opts...)
+
+	go appV1.Start()
 	go appV2.Start()
 
+	var apps []stopper
+	if config.RouteUDPThroughV2 {
+		// The v1 app flushes into the v2 pipeline, so it must be stopped
+		// first.
+		apps = append(apps, handoff{from: appV1, to: appV2})
+	} else {
+		apps = append(apps, appV1, appV2)
+	}
+
+	reload.Notify(reloader(*configFilePath, config, appV1, appV2), metricClient)
+	go shutdownOnSignal(
+		time.Duration(config.ShutdownTimeoutSeconds)*time.Second,
+		apps...,
+	)
+
 	// We start the profiler last so that we can definitively say that we're
 	// all connected and ready for data by the time the profiler starts up.
 	profiler.New(config.PPROFPort).Start()
 }
+
+// reloadable are the Config fields that are applied without a restart.
+var reloadable = []string{"DopplerAddr", "Tags", "BatchIntervalMilliseconds"}
+
+// reloadableFields returns the fields of reloadable that can be applied to
+// c. The v2 app does not use DopplerAddr when DopplerTargets are configured,
+// so changing it then requires a restart.
+func reloadableFields(c *app.Config) []string {
+	if len(c.DopplerTargets) == 0 {
+		return reloadable
+	}
+
+	return []string{"Tags", "BatchIntervalMilliseconds"}
+}
+
+// reloader returns a func that re-reads the config file and applies it to
+// the apps. It fails without applying anything when a field that is not
+// reloadable has changed.
+func reloader(path string, current *app.Config, appV1 *app.AppV1, appV2 *app.AppV2) reload.Func {
+	return func() error {
+		c, err := app.ParseConfig(path)
+		if err != nil {
+			return err
+		}
+
+		if err := reload.RestartRequired(current, c, reloadableFields(current)...); err != nil {
+			return err
+		}
+
+		appV1.Reload(c)
+		appV2.Reload(c)
+		current = c
+
+		return nil
+	}
+}
+
+type stopper interface {
+	Stop(ctx context.Context) (flushed, abandoned uint64)
+}
+
+// handoff stops from, which flushes its envelopes into to, and then to.
+// The envelopes from flushes are only counted as flushed once to has
+// written them.
+type handoff struct {
+	from, to stopper
+}
+
+func (h handoff) Stop(ctx context.Context) (flushed, abandoned uint64) {
+	_, abandoned = h.from.Stop(ctx)
+	flushed, a := h.to.Stop(ctx)
+
+	return flushed, abandoned + a
+}
+
+// shutdownOnSignal waits for SIGTERM or SIGINT, then stops every app,
+// giving them until the timeout to flush their buffered envelopes, and
+// exits.
+func shutdownOnSignal(timeout time.Duration, apps ...stopper) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	sig := <-signals
+
+	log.Printf("Received %s, flushing envelopes for up to %s", sig, timeout)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		flushed   uint64
+		abandoned uint64
+	)
+	for _, a := range apps {
+		wg.Add(1)
+		go func(a stopper) {
+			defer wg.Done()
+			f, ab := a.Stop(ctx)
+
+			mu.Lock()
+			defer mu.Unlock()
+			flushed += f
+			abandoned += ab
+		}(a)
+	}
+	wg.Wait()
+
+	log.Printf("Shutdown complete: flushed %d envelopes, abandoned %d", flushed, abandoned)
+	os.Exit(0)
+}
@@ -14,6 +14,7 @@ It is generated from these files:
 
 It has these top-level messages:
 	SenderResponse
+	BatchAck
 	EgressRequest
 	Filter
 	LogFilter
@@ -29,6 +30,7 @@ It has these top-level messages:
 	IngressResponse
 	EnvelopeBatch
 	BatchSenderResponse
+	SendResponse
 */
 package loggregator_v2
 
@@ -60,8 +62,48 @@ func (m *SenderResponse) String() string            { return proto.CompactTextSt
 func (*SenderResponse) ProtoMessage()               {}
 func (*SenderResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{0} }
 
+// BatchAck is sent periodically by doppler on an AckedBatchSender stream.
+// It covers the batches received on the stream since the previous BatchAck.
+type BatchAck struct {
+	// batches is the number of batches received in the window.
+	Batches uint64 `protobuf:"varint,1,opt,name=batches" json:"batches,omitempty"`
+	// accepted is the number of envelopes in those batches that were
+	// buffered by doppler.
+	Accepted uint64 `protobuf:"varint,2,opt,name=accepted" json:"accepted,omitempty"`
+	// shed is doppler's estimate of how many of the accepted envelopes
+	// were dropped from its buffer before reaching a sink.
+	Shed uint64 `protobuf:"varint,3,opt,name=shed" json:"shed,omitempty"`
+}
+
+func (m *BatchAck) Reset()                    { *m = BatchAck{} }
+func (m *BatchAck) String() string            { return proto.CompactTextString(m) }
+func (*BatchAck) ProtoMessage()               {}
+func (*BatchAck) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{1} }
+
+func (m *BatchAck) GetBatches() uint64 {
+	if m != nil {
+		return m.Batches
+	}
+	return 0
+}
+
+func (m *BatchAck) GetAccepted() uint64 {
+	if m != nil {
+		return m.Accepted
+	}
+	return 0
+}
+
+func (m *BatchAck) GetShed() uint64 {
+	if m != nil {
+		return m.Shed
+	}
+	return 0
+}
+
 func init() {
 	proto.RegisterType((*SenderResponse)(nil), "loggregator.v2.SenderResponse")
+	proto.RegisterType((*BatchAck)(nil), "loggregator.v2.BatchAck")
 }
 
 // Reference imports to suppress errors if they are not otherwise used.
@@ -77,6 +119,7 @@ const _ = grpc.SupportPackageIsVersion4
 type DopplerIngressClient interface {
 	Sender(ctx context.Context, opts ...grpc.CallOption) (DopplerIngress_SenderClient, error)
 	BatchSender(ctx context.Context, opts ...grpc.CallOption) (DopplerIngress_BatchSenderClient, error)
+	AckedBatchSender(ctx context.Context, opts ...grpc.CallOption) (DopplerIngress_AckedBatchSenderClient, error)
 }
 
 type dopplerIngressClient struct {
@@ -155,11 +198,43 @@ func (x *dopplerIngressBatchSenderClient) CloseAndRecv() (*BatchSenderResponse,
 	return m, nil
 }
 
+func (c *dopplerIngressClient) AckedBatchSender(ctx context.Context, opts ...grpc.CallOption) (DopplerIngress_AckedBatchSenderClient, error) {
+	stream, err := grpc.NewClientStream(ctx, &_DopplerIngress_serviceDesc.Streams[2], c.cc, "/loggregator.v2.DopplerIngress/AckedBatchSender", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &dopplerIngressAckedBatchSenderClient{stream}
+	return x, nil
+}
+
+type DopplerIngress_AckedBatchSenderClient interface {
+	Send(*EnvelopeBatch) error
+	Recv() (*BatchAck, error)
+	grpc.ClientStream
+}
+
+type dopplerIngressAckedBatchSenderClient struct {
+	grpc.ClientStream
+}
+
+func (x *dopplerIngressAckedBatchSenderClient) Send(m *EnvelopeBatch) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *dopplerIngressAckedBatchSenderClient) Recv() (*BatchAck, error) {
+	m := new(BatchAck)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 // Server API for DopplerIngress service
 
 type DopplerIngressServer interface {
 	Sender(DopplerIngress_SenderServer) error
 	BatchSender(DopplerIngress_BatchSenderServer) error
+	AckedBatchSender(DopplerIngress_AckedBatchSenderServer) error
 }
 
 func RegisterDopplerIngressServer(s *grpc.Server, srv DopplerIngressServer) {
@@ -218,6 +293,32 @@ func (x *dopplerIngressBatchSenderServer) Recv() (*EnvelopeBatch, error) {
 	return m, nil
 }
 
+func _DopplerIngress_AckedBatchSender_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(DopplerIngressServer).AckedBatchSender(&dopplerIngressAckedBatchSenderServer{stream})
+}
+
+type DopplerIngress_AckedBatchSenderServer interface {
+	Send(*BatchAck) error
+	Recv() (*EnvelopeBatch, error)
+	grpc.ServerStream
+}
+
+type dopplerIngressAckedBatchSenderServer struct {
+	grpc.ServerStream
+}
+
+func (x *dopplerIngressAckedBatchSenderServer) Send(m *BatchAck) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *dopplerIngressAckedBatchSenderServer) Recv() (*EnvelopeBatch, error) {
+	m := new(EnvelopeBatch)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 var _DopplerIngress_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "loggregator.v2.DopplerIngress",
 	HandlerType: (*DopplerIngressServer)(nil),
@@ -233,6 +334,12 @@ var _DopplerIngress_serviceDesc = grpc.ServiceDesc{
 			Handler:       _DopplerIngress_BatchSender_Handler,
 			ClientStreams: true,
 		},
+		{
+			StreamName:    "AckedBatchSender",
+			Handler:       _DopplerIngress_AckedBatchSender_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
 	},
 	Metadata: "doppler.proto",
 }
@@ -240,16 +347,20 @@ var _DopplerIngress_serviceDesc = grpc.ServiceDesc{
 func init() { proto.RegisterFile("doppler.proto", fileDescriptor0) }
 
 var fileDescriptor0 = []byte{
-	// 163 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x09, 0x6e, 0x88, 0x02, 0xff, 0xe2, 0xe2, 0x4d, 0xc9, 0x2f, 0x28,
-	0xc8, 0x49, 0x2d, 0xd2, 0x2b, 0x28, 0xca, 0x2f, 0xc9, 0x17, 0xe2, 0xcb, 0xc9, 0x4f, 0x4f, 0x2f,
-	0x4a, 0x4d, 0x4f, 0x2c, 0xc9, 0x2f, 0xd2, 0x2b, 0x33, 0x92, 0xe2, 0x4b, 0xcd, 0x2b, 0x4b, 0xcd,
-	0xc9, 0x2f, 0x48, 0x85, 0xc8, 0x4b, 0xf1, 0x66, 0xe6, 0xa5, 0x17, 0xa5, 0x16, 0x17, 0x43, 0xb8,
-	0x4a, 0x02, 0x5c, 0x7c, 0xc1, 0xa9, 0x79, 0x29, 0xa9, 0x45, 0x41, 0xa9, 0xc5, 0x05, 0xf9, 0x79,
-	0xc5, 0xa9, 0x46, 0xeb, 0x19, 0xb9, 0xf8, 0x5c, 0x20, 0x46, 0x7a, 0x42, 0x94, 0x0a, 0xb9, 0x71,
-	0xb1, 0x41, 0x14, 0x09, 0x49, 0xe8, 0xa1, 0x1a, 0xaf, 0xe7, 0x0a, 0x35, 0x5d, 0x4a, 0x0e, 0x5d,
-	0x06, 0xd5, 0x58, 0x25, 0x06, 0x0d, 0x46, 0xa1, 0x50, 0x2e, 0x6e, 0xa7, 0xc4, 0x92, 0xe4, 0x0c,
-	0xa8, 0x61, 0xb2, 0xb8, 0x0c, 0x03, 0x2b, 0x92, 0x52, 0x46, 0x97, 0x46, 0xd2, 0x8b, 0x6c, 0x6c,
-	0x12, 0x1b, 0xd8, 0x2b, 0xc6, 0x80, 0x00, 0x00, 0x00, 0xff, 0xff, 0xfc, 0xc2, 0x57, 0xb9, 0x0a,
-	0x01, 0x00, 0x00,
+	// 234 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x09, 0x6e, 0x88, 0x02, 0xff, 0x94, 0x90, 0xc1, 0x4a, 0x03, 0x31,
+	0x10, 0x86, 0x4d, 0x2d, 0xb5, 0x8c, 0x34, 0x94, 0x39, 0x85, 0x80, 0x22, 0xf5, 0xd2, 0x53, 0x90,
+	0xfa, 0x04, 0x2b, 0x2a, 0x78, 0xb4, 0xea, 0x03, 0x6c, 0x93, 0x21, 0x95, 0x5d, 0x92, 0x90, 0x84,
+	0x3e, 0x80, 0x4f, 0x2e, 0x4d, 0xaa, 0xd8, 0x45, 0x0f, 0xde, 0xe6, 0xcf, 0x9f, 0x7c, 0xf9, 0x18,
+	0x98, 0x19, 0x1f, 0x42, 0x4f, 0x51, 0x85, 0xe8, 0xb3, 0x47, 0xde, 0x7b, 0x6b, 0x23, 0xd9, 0x36,
+	0xfb, 0xa8, 0x76, 0x2b, 0xc9, 0xc9, 0xed, 0xa8, 0xf7, 0x81, 0x6a, 0x2f, 0x67, 0xef, 0xce, 0x46,
+	0x4a, 0xa9, 0xc6, 0xc5, 0x1c, 0xf8, 0x0b, 0x39, 0x43, 0x71, 0x4d, 0x29, 0x78, 0x97, 0x68, 0xf1,
+	0x0a, 0xd3, 0xbb, 0x36, 0xeb, 0x6d, 0xa3, 0x3b, 0x14, 0x70, 0xb6, 0xd9, 0xcf, 0x94, 0x04, 0xbb,
+	0x62, 0xcb, 0xf1, 0xfa, 0x2b, 0xa2, 0x84, 0x69, 0xab, 0x35, 0x85, 0x4c, 0x46, 0x8c, 0x4a, 0xf5,
+	0x9d, 0x11, 0x61, 0x9c, 0xb6, 0x64, 0xc4, 0x69, 0x39, 0x2f, 0xf3, 0xea, 0x63, 0x04, 0xfc, 0xbe,
+	0x8a, 0x3e, 0x55, 0x01, 0x7c, 0x84, 0x49, 0xfd, 0x1a, 0x85, 0x3a, 0x96, 0x56, 0x0f, 0x07, 0x67,
+	0x79, 0x39, 0x6c, 0x06, 0xb2, 0x27, 0x4b, 0x86, 0x6f, 0x70, 0x5e, 0x84, 0x0f, 0xb0, 0x8b, 0xbf,
+	0x60, 0xe5, 0x92, 0xbc, 0x1e, 0xd6, 0x3f, 0xde, 0x1e, 0x61, 0x9f, 0x61, 0xde, 0xe8, 0x8e, 0xcc,
+	0x3f, 0xd8, 0xe2, 0x57, 0x76, 0xa3, 0xbb, 0x3d, 0xf0, 0x86, 0x6d, 0x26, 0x65, 0xe7, 0xb7, 0x9f,
+	0x03, 0x00, 0x1a, 0xd1, 0xa7, 0xa4, 0xb3, 0x01, 0x00, 0x00,
 }
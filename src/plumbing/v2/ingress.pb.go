@@ -50,10 +50,29 @@ func (m *BatchSenderResponse) String() string            { return proto.CompactT
 func (*BatchSenderResponse) ProtoMessage()               {}
 func (*BatchSenderResponse) Descriptor() ([]byte, []int) { return fileDescriptor4, []int{2} }
 
+// SendResponse is returned by Send once the batch has been buffered.
+type SendResponse struct {
+	// accepted is the number of envelopes in the batch that were buffered.
+	Accepted uint64 `protobuf:"varint,1,opt,name=accepted" json:"accepted,omitempty"`
+}
+
+func (m *SendResponse) Reset()                    { *m = SendResponse{} }
+func (m *SendResponse) String() string            { return proto.CompactTextString(m) }
+func (*SendResponse) ProtoMessage()               {}
+func (*SendResponse) Descriptor() ([]byte, []int) { return fileDescriptor4, []int{3} }
+
+func (m *SendResponse) GetAccepted() uint64 {
+	if m != nil {
+		return m.Accepted
+	}
+	return 0
+}
+
 func init() {
 	proto.RegisterType((*IngressResponse)(nil), "loggregator.v2.IngressResponse")
 	proto.RegisterType((*EnvelopeBatch)(nil), "loggregator.v2.EnvelopeBatch")
 	proto.RegisterType((*BatchSenderResponse)(nil), "loggregator.v2.BatchSenderResponse")
+	proto.RegisterType((*SendResponse)(nil), "loggregator.v2.SendResponse")
 }
 
 // Reference imports to suppress errors if they are not otherwise used.
@@ -69,6 +88,7 @@ const _ = grpc.SupportPackageIsVersion4
 type IngressClient interface {
 	Sender(ctx context.Context, opts ...grpc.CallOption) (Ingress_SenderClient, error)
 	BatchSender(ctx context.Context, opts ...grpc.CallOption) (Ingress_BatchSenderClient, error)
+	Send(ctx context.Context, in *EnvelopeBatch, opts ...grpc.CallOption) (*SendResponse, error)
 }
 
 type ingressClient struct {
@@ -147,11 +167,21 @@ func (x *ingressBatchSenderClient) CloseAndRecv() (*BatchSenderResponse, error)
 	return m, nil
 }
 
+func (c *ingressClient) Send(ctx context.Context, in *EnvelopeBatch, opts ...grpc.CallOption) (*SendResponse, error) {
+	out := new(SendResponse)
+	err := grpc.Invoke(ctx, "/loggregator.v2.Ingress/Send", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // Server API for Ingress service
 
 type IngressServer interface {
 	Sender(Ingress_SenderServer) error
 	BatchSender(Ingress_BatchSenderServer) error
+	Send(context.Context, *EnvelopeBatch) (*SendResponse, error)
 }
 
 func RegisterIngressServer(s *grpc.Server, srv IngressServer) {
@@ -210,10 +240,33 @@ func (x *ingressBatchSenderServer) Recv() (*EnvelopeBatch, error) {
 	return m, nil
 }
 
+func _Ingress_Send_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(EnvelopeBatch)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(IngressServer).Send(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/loggregator.v2.Ingress/Send",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(IngressServer).Send(ctx, req.(*EnvelopeBatch))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 var _Ingress_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "loggregator.v2.Ingress",
 	HandlerType: (*IngressServer)(nil),
-	Methods:     []grpc.MethodDesc{},
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Send",
+			Handler:    _Ingress_Send_Handler,
+		},
+	},
 	Streams: []grpc.StreamDesc{
 		{
 			StreamName:    "Sender",
@@ -232,7 +285,7 @@ var _Ingress_serviceDesc = grpc.ServiceDesc{
 func init() { proto.RegisterFile("ingress.proto", fileDescriptor4) }
 
 var fileDescriptor4 = []byte{
-	// 185 bytes of a gzipped FileDescriptorProto
+	// 224 bytes of a gzipped FileDescriptorProto
 	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x09, 0x6e, 0x88, 0x02, 0xff, 0xe2, 0xe2, 0xcd, 0xcc, 0x4b, 0x2f,
 	0x4a, 0x2d, 0x2e, 0xd6, 0x2b, 0x28, 0xca, 0x2f, 0xc9, 0x17, 0xe2, 0xcb, 0xc9, 0x4f, 0x4f, 0x2f,
 	0x4a, 0x4d, 0x4f, 0x2c, 0xc9, 0x2f, 0xd2, 0x2b, 0x33, 0x92, 0xe2, 0x4b, 0xcd, 0x2b, 0x4b, 0xcd,
@@ -240,9 +293,11 @@ var fileDescriptor4 = []byte{
 	0xe4, 0xe7, 0x15, 0xa7, 0x2a, 0xd9, 0x73, 0xf1, 0xba, 0x42, 0x15, 0x39, 0x25, 0x96, 0x24, 0x67,
 	0x08, 0xe9, 0x71, 0xb1, 0x26, 0x81, 0x18, 0x12, 0x8c, 0x0a, 0xcc, 0x1a, 0xdc, 0x46, 0x12, 0x7a,
 	0xa8, 0x66, 0xea, 0xc1, 0x54, 0x07, 0x41, 0x94, 0x29, 0x89, 0x72, 0x09, 0x83, 0x35, 0x06, 0xa7,
-	0xe6, 0xa5, 0xa4, 0x16, 0xc1, 0xcc, 0x35, 0x5a, 0xc9, 0xc8, 0xc5, 0x0e, 0xb5, 0x4b, 0xc8, 0x9d,
-	0x8b, 0x0d, 0x22, 0x2b, 0x84, 0xd3, 0x34, 0x29, 0x79, 0x74, 0x19, 0x74, 0x87, 0x32, 0x68, 0x30,
-	0x0a, 0x85, 0x72, 0x71, 0x23, 0xd9, 0x25, 0x24, 0x8b, 0xcb, 0x34, 0xb0, 0x22, 0x29, 0x65, 0x74,
-	0x69, 0x2c, 0xee, 0x04, 0x19, 0x9b, 0xc4, 0x06, 0x0e, 0x1d, 0x63, 0x40, 0x00, 0x00, 0x00, 0xff,
-	0xff, 0x93, 0x79, 0x36, 0x90, 0x4e, 0x01, 0x00, 0x00,
+	0xe6, 0xa5, 0xa4, 0x16, 0xc1, 0xcd, 0xd5, 0xe2, 0xe2, 0x01, 0x89, 0xc0, 0xf8, 0x42, 0x52, 0x5c,
+	0x1c, 0x89, 0xc9, 0xc9, 0xa9, 0x05, 0x25, 0xa9, 0x29, 0x12, 0x8c, 0x0a, 0x8c, 0x1a, 0x2c, 0x41,
+	0x70, 0xbe, 0xd1, 0x07, 0x46, 0x2e, 0x76, 0xa8, 0xbb, 0x84, 0xdc, 0xb9, 0xd8, 0x20, 0x26, 0x09,
+	0xe1, 0xb4, 0x59, 0x4a, 0x1e, 0x5d, 0x06, 0xdd, 0x53, 0x0c, 0x1a, 0x8c, 0x42, 0xa1, 0x5c, 0xdc,
+	0x48, 0xee, 0x12, 0x92, 0xc5, 0x65, 0x1a, 0x58, 0x91, 0x94, 0x32, 0xba, 0x34, 0x36, 0x3f, 0x81,
+	0x8c, 0x75, 0xe5, 0x62, 0x01, 0x89, 0x12, 0x32, 0x4f, 0x06, 0x5d, 0x1a, 0x39, 0x30, 0x94, 0x18,
+	0x92, 0xd8, 0xc0, 0x11, 0x62, 0x0c, 0x18, 0x00, 0x90, 0x20, 0xc6, 0x04, 0xc1, 0x01, 0x00, 0x00,
 }
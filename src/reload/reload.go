@@ -0,0 +1,102 @@
+// Package reload applies configuration changes on SIGHUP.
+package reload
+
+import (
+	"fmt"
+	"log"
+	"metricemitter"
+	"os"
+	"os/signal"
+	"reflect"
+	"strings"
+	"syscall"
+)
+
+// Func loads the configuration and applies it. It returns an error if the
+// configuration could not be loaded or applied.
+type Func func() error
+
+// Notify registers for SIGHUP and calls f every time the process receives
+// it. The signal is handled from the moment Notify returns, so a SIGHUP sent
+// afterwards can not terminate the process.
+func Notify(f Func, metricClient metricemitter.MetricClient) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+
+	go OnSignal(signals, f, metricClient)
+}
+
+// OnSignal calls f for every signal received on signals and reports
+// whether it succeeded. It returns when signals is closed.
+func OnSignal(signals <-chan os.Signal, f Func, metricClient metricemitter.MetricClient) {
+	r := newReporter(metricClient)
+	for range signals {
+		r.run(f)
+	}
+}
+
+type reporter struct {
+	succeeded *metricemitter.CounterMetric
+	failed    *metricemitter.CounterMetric
+}
+
+func newReporter(metricClient metricemitter.MetricClient) *reporter {
+	return &reporter{
+		succeeded: metricClient.NewCounterMetric("config_reloads",
+			metricemitter.WithVersion(2, 0),
+			metricemitter.WithTags(map[string]string{"result": "success"}),
+		),
+		failed: metricClient.NewCounterMetric("config_reloads",
+			metricemitter.WithVersion(2, 0),
+			metricemitter.WithTags(map[string]string{"result": "failure"}),
+		),
+	}
+}
+
+func (r *reporter) run(f Func) {
+	log.Print("Received SIGHUP, reloading config")
+
+	if err := f(); err != nil {
+		// metric-documentation-v2: (config_reloads) Number of configuration
+		// reloads triggered by SIGHUP by result
+		r.failed.Increment(1)
+		log.Printf("Config reload failed: %s", err)
+		return
+	}
+
+	// metric-documentation-v2: (config_reloads) Number of configuration
+	// reloads triggered by SIGHUP by result
+	r.succeeded.Increment(1)
+	log.Print("Config reloaded")
+}
+
+// RestartRequired returns an error naming every top level field of the
+// given structs that differs, other than the reloadable ones. old and
+// new must be pointers to structs of the same type.
+func RestartRequired(old, new interface{}, reloadable ...string) error {
+	ov := reflect.ValueOf(old).Elem()
+	nv := reflect.ValueOf(new).Elem()
+
+	skip := make(map[string]bool, len(reloadable))
+	for _, name := range reloadable {
+		skip[name] = true
+	}
+
+	var changed []string
+	for i := 0; i < ov.NumField(); i++ {
+		name := ov.Type().Field(i).Name
+		if skip[name] {
+			continue
+		}
+
+		if !reflect.DeepEqual(ov.Field(i).Interface(), nv.Field(i).Interface()) {
+			changed = append(changed, name)
+		}
+	}
+
+	if len(changed) == 0 {
+		return nil
+	}
+
+	return fmt.Errorf("changes to %s require a restart", strings.Join(changed, ", "))
+}
@@ -0,0 +1,113 @@
+package reload_test
+
+import (
+	"errors"
+	"metricemitter"
+	"os"
+	v2 "plumbing/v2"
+	"sync"
+	"syscall"
+
+	"reload"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type config struct {
+	Addr    string
+	Tags    map[string]string
+	Port    int
+	Workers int
+}
+
+var _ = Describe("Reload", func() {
+	Describe("RestartRequired()", func() {
+		It("allows changes to reloadable fields", func() {
+			old := &config{Addr: "a", Tags: map[string]string{"a": "b"}, Port: 1}
+			new := &config{Addr: "b", Tags: map[string]string{"c": "d"}, Port: 1}
+
+			Expect(reload.RestartRequired(old, new, "Addr", "Tags")).To(Succeed())
+		})
+
+		It("names the changed fields that require a restart", func() {
+			old := &config{Addr: "a", Port: 1, Workers: 1}
+			new := &config{Addr: "b", Port: 2, Workers: 2}
+
+			err := reload.RestartRequired(old, new, "Addr")
+			Expect(err).To(MatchError("changes to Port, Workers require a restart"))
+		})
+	})
+
+	Describe("OnSignal()", func() {
+		It("reports successful and failed reloads", func() {
+			spy := newSpyMetricClient()
+			signals := make(chan os.Signal)
+			results := make(chan error, 3)
+			results <- nil
+			results <- errors.New("some-error")
+			results <- nil
+
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				reload.OnSignal(signals, func() error {
+					return <-results
+				}, spy)
+			}()
+
+			for i := 0; i < 3; i++ {
+				signals <- syscall.SIGHUP
+			}
+			close(signals)
+			Eventually(done).Should(BeClosed())
+
+			Expect(spy.GetDelta("success")).To(Equal(uint64(2)))
+			Expect(spy.GetDelta("failure")).To(Equal(uint64(1)))
+		})
+	})
+
+	Describe("Notify()", func() {
+		It("handles SIGHUP as soon as it returns", func() {
+			called := make(chan struct{}, 1)
+			reload.Notify(func() error {
+				called <- struct{}{}
+				return nil
+			}, newSpyMetricClient())
+
+			Expect(syscall.Kill(syscall.Getpid(), syscall.SIGHUP)).To(Succeed())
+			Eventually(called).Should(Receive())
+		})
+	})
+})
+
+// spyMetricClient keeps the config_reloads metrics by their result tag.
+type spyMetricClient struct {
+	mu      sync.Mutex
+	metrics map[string]*metricemitter.CounterMetric
+}
+
+func newSpyMetricClient() *spyMetricClient {
+	return &spyMetricClient{
+		metrics: make(map[string]*metricemitter.CounterMetric),
+	}
+}
+
+func (s *spyMetricClient) NewCounterMetric(name string, opts ...metricemitter.MetricOption) *metricemitter.CounterMetric {
+	m := metricemitter.NewCounterMetric(name, "", opts...)
+	m.WithEnvelope(func(e *v2.Envelope) error {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.metrics[e.GetTags()["result"].GetText()] = m
+		return nil
+	})
+
+	return m
+}
+
+func (s *spyMetricClient) GetDelta(result string) uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.metrics[result].GetDelta()
+}
@@ -1,5 +1,8 @@
 #!/usr/bin/env bash
 
+# Set CONFIG=metron_v2_config.json to benchmark the v2 egress path.
+CONFIG=${CONFIG:-metron_config.json}
+
 go build metron_benchmark.go
 
 for SHA in `git rev-list v78..HEAD`; do
@@ -7,7 +10,7 @@ for SHA in `git rev-list v78..HEAD`; do
     git co $SHA
     go build metron
     ./metron_benchmark \
-      -cmd "$PWD/metron --config metron_config.json" \
+      -cmd "$PWD/metron --config $CONFIG" \
       -tag $SHA \
       -ca ca.cert \
       -cert doppler.cert \
@@ -8,6 +8,7 @@ import (
 	"net"
 	"os/exec"
 	"plumbing"
+	v2 "plumbing/v2"
 	"strings"
 	"sync/atomic"
 	"time"
@@ -96,6 +97,9 @@ func main() {
 	fmt.Printf("%s %v\n", *resultTag, results)
 }
 
+// consumeEnvelopes serves both the v1 and v2 doppler ingress APIs so that
+// either egress path of metron can be benchmarked. Starting metron with
+// metron_v2_config.json routes the envelopes through the v2 path.
 func consumeEnvelopes(c *consumer, addr string, creds credentials.TransportCredentials) {
 	lis, err := net.Listen("tcp", addr)
 	if err != nil {
@@ -104,6 +108,7 @@ func consumeEnvelopes(c *consumer, addr string, creds credentials.TransportCrede
 
 	server := grpc.NewServer(grpc.Creds(creds))
 	plumbing.RegisterDopplerIngestorServer(server, c)
+	v2.RegisterDopplerIngressServer(server, c)
 	go func() {
 		if err := server.Serve(lis); err != nil {
 			log.Panicf("Failed to serve: %s", err)
@@ -189,3 +194,49 @@ func (c *consumer) Pusher(s plumbing.DopplerIngestor_PusherServer) error {
 	}
 	return nil
 }
+
+func (c *consumer) Sender(s v2.DopplerIngress_SenderServer) error {
+	for {
+		e, err := s.Recv()
+		if err != nil {
+			log.Printf("Failed while receiving: %s", err)
+			return err
+		}
+
+		c.countV2(e)
+	}
+}
+
+func (c *consumer) BatchSender(s v2.DopplerIngress_BatchSenderServer) error {
+	return c.receiveBatches(s)
+}
+
+func (c *consumer) AckedBatchSender(s v2.DopplerIngress_AckedBatchSenderServer) error {
+	return c.receiveBatches(s)
+}
+
+type batchReceiver interface {
+	Recv() (*v2.EnvelopeBatch, error)
+}
+
+func (c *consumer) receiveBatches(s batchReceiver) error {
+	for {
+		batch, err := s.Recv()
+		if err != nil {
+			log.Printf("Failed while receiving: %s", err)
+			return err
+		}
+
+		for _, e := range batch.GetBatch() {
+			c.countV2(e)
+		}
+	}
+}
+
+func (c *consumer) countV2(e *v2.Envelope) {
+	if e.GetTags()["origin"].GetText() != "metron-benchmark" {
+		return
+	}
+
+	atomic.AddInt64(&c.count, 1)
+}
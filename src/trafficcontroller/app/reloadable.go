@@ -0,0 +1,82 @@
+package app
+
+import (
+	"fmt"
+	"net/http"
+	"plumbing"
+	"sync/atomic"
+	"time"
+
+	"trafficcontroller/internal/auth"
+)
+
+// reloadableTransport is an http.RoundTripper whose transport can be
+// replaced while requests are in flight.
+type reloadableTransport struct {
+	transport atomic.Value
+}
+
+func newReloadableTransport(t *http.Transport) *reloadableTransport {
+	rt := &reloadableTransport{}
+	rt.transport.Store(t)
+	return rt
+}
+
+func (rt *reloadableTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return rt.transport.Load().(*http.Transport).RoundTrip(req)
+}
+
+func (rt *reloadableTransport) set(t *http.Transport) {
+	old := rt.transport.Load().(*http.Transport)
+	rt.transport.Store(t)
+	old.CloseIdleConnections()
+}
+
+// newTransport returns the transport used to reach the CC and UAA. It
+// returns an error if none of the configured cipher suites are valid.
+func newTransport(c *Config) (t *http.Transport, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("invalid CipherSuites: %v", r)
+		}
+	}()
+
+	tlsConf := plumbing.NewTLSConfig(
+		plumbing.WithCipherSuites(c.CipherSuites),
+	)
+	tlsConf.InsecureSkipVerify = c.SkipCertVerify
+
+	return &http.Transport{
+		TLSHandshakeTimeout: 10 * time.Second,
+		TLSClientConfig:     tlsConf,
+		DisableKeepAlives:   true,
+	}, nil
+}
+
+// authorizers holds the log and admin access authorizers so that they can
+// be replaced when the CC or UAA endpoints change.
+type authorizers struct {
+	logAccess   atomic.Value
+	adminAccess atomic.Value
+}
+
+func newAuthorizers(c *Config, disableAccessControl bool) *authorizers {
+	a := &authorizers{}
+	a.set(c, disableAccessControl)
+	return a
+}
+
+func (a *authorizers) set(c *Config, disableAccessControl bool) {
+	uaaClient := auth.NewUaaClient(c.UaaHost, c.UaaClient, c.UaaClientSecret)
+
+	a.logAccess.Store(auth.NewLogAccessAuthorizer(disableAccessControl, c.ApiHost))
+	a.adminAccess.Store(auth.NewAdminAccessAuthorizer(disableAccessControl, &uaaClient))
+}
+
+func (a *authorizers) logAccessAuthorizer(authToken, appID string) (int, error) {
+	return a.logAccess.Load().(auth.LogAccessAuthorizer)(authToken, appID)
+}
+
+func (a *authorizers) adminAccessAuthorizer(authToken string) (bool, error) {
+	return a.adminAccess.Load().(auth.AdminAccessAuthorizer)(authToken)
+}
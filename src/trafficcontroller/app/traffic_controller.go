@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"sync"
 	"time"
 
 	"dopplerservice"
@@ -15,6 +16,7 @@ import (
 	"monitor"
 	"plumbing"
 	"profiler"
+	"reload"
 	"trafficcontroller/internal/auth"
 	"trafficcontroller/internal/proxy"
 
@@ -40,6 +42,20 @@ type trafficController struct {
 	logFilePath          string
 	disableAccessControl bool
 	metricClient         metricemitter.MetricClient
+	transport            *reloadableTransport
+	authorizers          *authorizers
+
+	mu      sync.Mutex
+	current *Config
+}
+
+// reloadable are the Config fields that are applied without a restart.
+var reloadable = []string{
+	"ApiHost",
+	"UaaHost",
+	"UaaClient",
+	"UaaClientSecret",
+	"CipherSuites",
 }
 
 // finder provides service discovery of Doppler processes
@@ -54,27 +70,48 @@ func NewTrafficController(
 	disableAccessControl bool,
 	metricClient metricemitter.MetricClient,
 ) *trafficController {
+	transport, err := newTransport(c)
+	if err != nil {
+		log.Panic(err)
+	}
+
 	return &trafficController{
 		conf:                 c,
 		logFilePath:          path,
 		disableAccessControl: disableAccessControl,
 		metricClient:         metricClient,
+		transport:            newReloadableTransport(transport),
+		authorizers:          newAuthorizers(c, disableAccessControl),
+		current:              c,
 	}
 }
 
-func (t *trafficController) Start() {
-	tlsConf := plumbing.NewTLSConfig(
-		plumbing.WithCipherSuites(t.conf.CipherSuites),
-	)
-	transport := &http.Transport{
-		TLSHandshakeTimeout: 10 * time.Second,
-		TLSClientConfig:     tlsConf,
-		DisableKeepAlives:   true,
+// Reload applies the CC and UAA endpoints, UAA credentials and cipher
+// suites of c. It returns an error without applying anything if any other
+// field differs from the running config.
+func (t *trafficController) Reload(c *Config) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if err := reload.RestartRequired(t.current, c, reloadable...); err != nil {
+		return err
 	}
-	http.DefaultClient.Transport = transport
-	http.DefaultClient.Timeout = 20 * time.Second
 
-	transport.TLSClientConfig.InsecureSkipVerify = t.conf.SkipCertVerify
+	transport, err := newTransport(c)
+	if err != nil {
+		return err
+	}
+
+	t.transport.set(transport)
+	t.authorizers.set(c, t.disableAccessControl)
+	t.current = c
+
+	return nil
+}
+
+func (t *trafficController) Start() {
+	http.DefaultClient.Transport = t.transport
+	http.DefaultClient.Timeout = 20 * time.Second
 
 	log.Print("Startup: Setting up the loggregator traffic controller")
 
@@ -92,11 +129,6 @@ func (t *trafficController) Start() {
 	go openFileMonitor.Start()
 	defer openFileMonitor.Stop()
 
-	logAuthorizer := auth.NewLogAccessAuthorizer(t.disableAccessControl, t.conf.ApiHost)
-
-	uaaClient := auth.NewUaaClient(t.conf.UaaHost, t.conf.UaaClient, t.conf.UaaClientSecret)
-	adminAuthorizer := auth.NewAdminAccessAuthorizer(t.disableAccessControl, &uaaClient)
-
 	// Start the health endpoint listener
 	promRegistry := prometheus.NewRegistry()
 	healthendpoint.StartServer(t.conf.HealthAddr, promRegistry)
@@ -159,8 +191,8 @@ func (t *trafficController) Start() {
 
 	dopplerHandler := http.Handler(
 		proxy.NewDopplerProxy(
-			logAuthorizer,
-			adminAuthorizer,
+			t.authorizers.logAccessAuthorizer,
+			t.authorizers.adminAccessAuthorizer,
 			grpcConnector,
 			"doppler."+t.conf.SystemDomain,
 			15*time.Second,
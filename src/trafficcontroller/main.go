@@ -6,6 +6,7 @@ import (
 	"log"
 	"metricemitter"
 	"plumbing"
+	"reload"
 
 	"google.golang.org/grpc"
 
@@ -46,5 +47,13 @@ func main() {
 		log.Fatalf("Couldn't connect to metric emitter: %s", err)
 	}
 	tc := app.NewTrafficController(conf, *logFilePath, *disableAccessControl, metricClient)
+	reload.Notify(func() error {
+		c, err := app.ParseConfig(*configFile)
+		if err != nil {
+			return err
+		}
+
+		return tc.Reload(c)
+	}, metricClient)
 	tc.Start()
 }